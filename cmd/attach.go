@@ -0,0 +1,211 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"google.golang.org/genai"
+)
+
+// maxInlineAttachmentSize is the largest file sent inside the request. Larger files are uploaded with the Gemini
+// Files API, because a request with inline data can't exceed 20 MiB.
+var maxInlineAttachmentSize int64 = 15 << 20
+
+// attachmentTypes maps the extensions of the media files Gemini understands to their MIME type. Text files, such
+// as source code, are recognised by their content instead.
+var attachmentTypes = map[string]string{
+	".pdf":  "application/pdf",
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".webp": "image/webp",
+	".heic": "image/heic",
+	".heif": "image/heif",
+	".gif":  "image/gif",
+	".wav":  "audio/wav",
+	".mp3":  "audio/mp3",
+	".aiff": "audio/aiff",
+	".aac":  "audio/aac",
+	".ogg":  "audio/ogg",
+	".flac": "audio/flac",
+	".mp4":  "video/mp4",
+	".mpeg": "video/mpeg",
+	".mpg":  "video/mpg",
+	".mov":  "video/mov",
+	".avi":  "video/avi",
+	".flv":  "video/x-flv",
+	".webm": "video/webm",
+	".wmv":  "video/wmv",
+	".3gp":  "video/3gpp",
+}
+
+// fileUploader is implemented by the providers that can store files too large to be sent inline, such as Gemini.
+type fileUploader interface {
+	// UploadFile uploads the file at path and returns it once it can be used in a request.
+	UploadFile(ctx context.Context, path string, mimeType string) (*genai.File, error)
+	// DeleteFile deletes a file returned by UploadFile.
+	DeleteFile(ctx context.Context, name string) error
+}
+
+// attachments holds the parts made from the files given with --attach.
+type attachments struct {
+	parts []*genai.Part
+	// uploaded holds the names of the files uploaded with the Files API.
+	uploaded []string
+}
+
+// attachFiles turns files into parts. Text files are sent as text, so that every provider can read them, and other
+// files as inline data, or through the Files API when they are larger than maxInlineAttachmentSize. Providers other
+// than Gemini only take text and images. With
+// stripMetadata, the metadata of JPEG and PNG images is removed first, as the image commands do.
+func attachFiles(ctx context.Context, provider Provider, files []string, stripMetadata bool) (*attachments, error) {
+	a := &attachments{}
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return a, invalidInput(err)
+		}
+		if info.IsDir() {
+			return a, fmt.Errorf("%w: %s is a directory, attach the files it contains instead", ErrInvalidInput, file)
+		}
+
+		mimeType, err := detectAttachmentType(file)
+		if err != nil {
+			return a, err
+		}
+		// The other providers only take images inline, and would send PDFs, audio and video as images.
+		if name := GetConfigFunc("provider"); name != "" && name != providerGemini && mimeType != "text/plain" && !strings.HasPrefix(mimeType, "image/") {
+			return a, fmt.Errorf("%w: can't attach %s, the %s provider only reads text and image files", ErrInvalidInput, file, name)
+		}
+
+		// Images are read whatever their size, so that the stripped copy is uploaded rather than the file.
+		var data []byte
+		size := info.Size()
+		if stripMetadata && strings.HasPrefix(mimeType, "image/") {
+			if data, err = os.ReadFile(file); err != nil {
+				return a, invalidInput(err)
+			}
+			data = stripAttachmentMetadata(data)
+			size = int64(len(data))
+		}
+
+		if size > maxInlineAttachmentSize {
+			uploader, ok := provider.(fileUploader)
+			if !ok {
+				return a, fmt.Errorf("%w: %s is larger than %s, which only the gemini provider can upload", ErrInvalidInput, file, formatSize(maxInlineAttachmentSize))
+			}
+			uploaded, err := uploadAttachment(ctx, uploader, file, data, mimeType)
+			if err != nil {
+				return a, classifyError(fmt.Errorf("uploading %s: %w", file, err))
+			}
+			a.uploaded = append(a.uploaded, uploaded.Name)
+			a.parts = append(a.parts, genai.NewPartFromURI(uploaded.URI, uploaded.MIMEType))
+			continue
+		}
+
+		if data == nil {
+			if data, err = os.ReadFile(file); err != nil {
+				return a, invalidInput(err)
+			}
+		}
+		if mimeType == "text/plain" {
+			a.parts = append(a.parts, genai.NewPartFromText(fmt.Sprintf("Attached file %s:\n\n%s\n\n", filepath.Base(file), data)))
+		} else {
+			a.parts = append(a.parts, genai.NewPartFromBytes(data, mimeType))
+		}
+	}
+	return a, nil
+}
+
+// stripAttachmentMetadata returns the image in data without its metadata. The format is detected from the content,
+// because the MIME type of an attachment comes from its extension.
+func stripAttachmentMetadata(data []byte) []byte {
+	format, _ := detectImageFormat(data)
+	img, _, _ := stripImageMetadata(imageFile{data: data, mimeType: "image/" + format})
+	return img.data
+}
+
+// uploadAttachment uploads file, or data when it isn't nil. data is written to a temporary copy with the name of
+// file, which is the name shown by the Files API.
+func uploadAttachment(ctx context.Context, uploader fileUploader, file string, data []byte, mimeType string) (*genai.File, error) {
+	if data == nil {
+		return uploader.UploadFile(ctx, file, mimeType)
+	}
+	dir, err := os.MkdirTemp("", "gencli-upload-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, filepath.Base(file))
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return nil, err
+	}
+	return uploader.UploadFile(ctx, path, mimeType)
+}
+
+// cleanup deletes the uploaded files when --delete-uploads is set. Gemini deletes them after 48 hours otherwise.
+// Failures are only reported, because the response has already been received.
+func (a *attachments) cleanup(ctx context.Context, provider Provider, deleteUploads bool) {
+	if a == nil || !deleteUploads {
+		return
+	}
+	uploader, ok := provider.(fileUploader)
+	if !ok {
+		return
+	}
+	for _, name := range a.uploaded {
+		if err := uploader.DeleteFile(ctx, name); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: deleting uploaded file %s: %v\n", name, err)
+		}
+	}
+	a.uploaded = nil
+}
+
+// detectAttachmentType returns the MIME type of file: the type of a known media extension, or text/plain for files
+// holding text, such as source code. Other files are rejected, because the model couldn't read them.
+func detectAttachmentType(file string) (string, error) {
+	if mimeType, ok := attachmentTypes[strings.ToLower(filepath.Ext(file))]; ok {
+		return mimeType, nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return "", invalidInput(err)
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, _ := f.Read(head)
+	head = head[:n]
+
+	// The sniffed type catches media files with an unusual extension.
+	sniffed, _, _ := strings.Cut(http.DetectContentType(head), ";")
+	if slices.Contains(slices.Collect(maps.Values(attachmentTypes)), sniffed) {
+		return sniffed, nil
+	}
+	if strings.HasPrefix(sniffed, "text/") || validUTF8Prefix(head) {
+		return "text/plain", nil
+	}
+	return "", fmt.Errorf("%w: can't attach %s, its type (%s) isn't supported; attach text, PDF, image, audio or video files", ErrInvalidInput, file, sniffed)
+}
+
+// validUTF8Prefix reports whether b is valid UTF-8 without control characters, ignoring a rune cut at its end.
+func validUTF8Prefix(b []byte) bool {
+	for len(b) > 0 {
+		r, size := utf8.DecodeRune(b)
+		if r == utf8.RuneError && size <= 1 {
+			return len(b) < utf8.UTFMax && !utf8.FullRune(b)
+		}
+		if r < 0x20 && r != '\n' && r != '\r' && r != '\t' {
+			return false
+		}
+		b = b[size:]
+	}
+	return true
+}
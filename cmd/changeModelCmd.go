@@ -1,7 +1,9 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"slices"
 
 	"github.com/AlecAivazis/survey/v2"
 	"github.com/spf13/cobra"
@@ -9,20 +11,90 @@ import (
 
 // versionCmd represents the version command
 var changeModelCmd = &cobra.Command{
-	Use:   "model",
-	Short: "To select a different GenAI model",
-	Long:  `This command will help you to select a different GenAI model.`,
-	Run: func(cmd *cobra.Command, args []string) {
-		setModelConfig()
+	Use:     "model [model id]",
+	Example: "gencli model\ngencli model my-internal-model",
+	Short:   "To select a different GenAI model",
+	Long:    `This command will help you to select a different GenAI model. With the openai provider any model ID is accepted, either as an argument or typed at the prompt. With the ollama provider the choices are the models pulled into the local Ollama server.`,
+	Args:    validArgs(cobra.MaximumNArgs(1)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return setModelConfig(args)
 	},
 }
 
-func setModelConfig() {
+// geminiModel is a Gemini model as shown in the selection prompt and as stored in the config.
+type geminiModel struct {
+	name   string
+	id     string
+	limits modelLimits
+}
+
+// modelLimits holds the largest generation options a model accepts, see generationOptions. Zero means that the limit
+// isn't known and is left to the API to check.
+type modelLimits struct {
+	maxOutputTokens  int32
+	maxTopK          int32
+	maxCandidates    int32
+	maxStopSequences int
+}
+
+// Limits of the Gemini 2.0 and 2.5 models, as documented by the Gemini API, and of the Gemini 3 models, for which only
+// the output token limit is documented. They are used when the Models API can't be reached, see geminiModelLimits.
+var (
+	gemini20Limits = modelLimits{maxOutputTokens: 8192, maxTopK: 40, maxCandidates: 8, maxStopSequences: 5}
+	gemini25Limits = modelLimits{maxOutputTokens: 65536, maxTopK: 64, maxCandidates: 8, maxStopSequences: 5}
+	gemini3Limits  = modelLimits{maxOutputTokens: 65536}
+)
+
+// geminiModels lists the Gemini models that can be selected, in the order they are shown.
+var geminiModels = []geminiModel{
+	{"Gemini 3 Pro", "gemini-3-pro-preview", gemini3Limits},
+	{"Gemini 3 Flash", "gemini-3-flash-preview", gemini3Limits},
+	{"Gemini 3.5 Flash", "gemini-3.5-flash", gemini3Limits},
+	{"Gemini 2.5 Pro", "gemini-2.5-pro", gemini25Limits},
+	{"Gemini 2.5 Flash", "gemini-2.5-flash", gemini25Limits},
+	{"Gemini 2.5 Flash-Lite", "gemini-2.5-flash-lite", gemini25Limits},
+	{"Gemini 2.0 Flash", "gemini-2.0-flash", gemini20Limits},
+	{"Gemini 2.0 Flash-Lite", "gemini-2.0-flash-lite", gemini20Limits},
+}
+
+func setModelConfig(args []string) error {
 
 	currentGenaiModel := GetConfigFunc("genai_model")
 	fmt.Println("Current model:", currentGenaiModel)
 
-	options := []string{"Gemini 3 Pro", "Gemini 3 Flash", "Gemini 3.5 Flash", "Gemini 2.5 Pro", "Gemini 2.5 Flash", "Gemini 2.5 Flash-Lite", "Gemini 2.0 Flash", "Gemini 2.0 Flash-Lite"}
+	var selected string
+	var err error
+	switch GetConfigFunc("provider") {
+	case providerOpenAI:
+		selected, err = askFreeFormModel(args)
+	case providerOllama:
+		selected, err = askListedModel(args)
+	default:
+		selected, err = askGeminiModel(args)
+	}
+	if err != nil {
+		return err
+	}
+	if err := UpdateConfigFunc("genai_model", selected); err != nil {
+		return err
+	}
+
+	fmt.Println("Model updated to:", selected)
+	return nil
+}
+
+func askGeminiModel(args []string) (string, error) {
+	if len(args) > 0 {
+		if !isGeminiModel(args[0]) {
+			return "", fmt.Errorf("%w: unknown Gemini model %q, run 'gencli model' to choose from the supported ones", ErrInvalidInput, args[0])
+		}
+		return args[0], nil
+	}
+
+	options := make([]string, 0, len(geminiModels))
+	for _, model := range geminiModels {
+		options = append(options, model.name)
+	}
 
 	var selected string
 	prompt := &survey.Select{
@@ -30,32 +102,70 @@ func setModelConfig() {
 		Options: options,
 	}
 
-	err := surveyAskOne(prompt, &selected)
-	CheckNilError(err)
-
-	switch selected {
-	case "Gemini 3 Pro":
-		selected = "gemini-3-pro-preview"
-	case "Gemini 3 Flash":
-		selected = "gemini-3-flash-preview"
-	case "Gemini 3.5 Flash":
-		selected = "gemini-3.5-flash"
-	case "Gemini 2.5 Pro":
-		selected = "gemini-2.5-pro"
-	case "Gemini 2.5 Flash":
-		selected = "gemini-2.5-flash"
-	case "Gemini 2.5 Flash-Lite":
-		selected = "gemini-2.5-flash-lite"
-	case "Gemini 2.0 Flash":
-		selected = "gemini-2.0-flash"
-	case "Gemini 2.0 Flash-Lite":
-		selected = "gemini-2.0-flash-lite"
-	default:
-		selected = "gemini-2.5-pro"
+	if err := surveyAskOne(prompt, &selected); err != nil {
+		return "", err
 	}
-	UpdateConfigFunc("genai_model", selected)
 
-	fmt.Println("Model updated to:", selected)
+	for _, model := range geminiModels {
+		if model.name == selected {
+			return model.id, nil
+		}
+	}
+	return defaultModel, nil
+}
+
+func askFreeFormModel(args []string) (string, error) {
+	if len(args) > 0 {
+		return args[0], nil
+	}
+
+	var selected string
+	prompt := &survey.Input{
+		Message: "Enter the model ID:",
+	}
+
+	if err := surveyAskOne(prompt, &selected, survey.WithValidator(survey.Required)); err != nil {
+		return "", err
+	}
+
+	return selected, nil
+}
+
+// askListedModel lets the user choose one of the models reported by the provider, such as the models pulled into Ollama.
+func askListedModel(args []string) (string, error) {
+	if len(args) > 0 {
+		return args[0], nil
+	}
+
+	provider, err := newProviderFunc(context.Background())
+	if err != nil {
+		return "", err
+	}
+	options, err := provider.ListModels(context.Background())
+	if err != nil {
+		return "", classifyError(err)
+	}
+	if len(options) == 0 {
+		return "", fmt.Errorf("%w: no models found, pull one first, for example with 'ollama pull llama3.2'", ErrConfig)
+	}
+
+	var selected string
+	prompt := &survey.Select{
+		Message: "Choose an model:",
+		Options: options,
+	}
+
+	if err := surveyAskOne(prompt, &selected); err != nil {
+		return "", err
+	}
+
+	return selected, nil
+}
+
+func isGeminiModel(id string) bool {
+	return slices.ContainsFunc(geminiModels, func(model geminiModel) bool {
+		return model.id == id
+	})
 }
 
 func init() {
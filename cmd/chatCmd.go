@@ -0,0 +1,241 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/genai"
+)
+
+var (
+	chatLanguage    string
+	chatTemperature float32
+	chatOutputFile  string
+	chatAttachPaths []string
+	deleteChatFiles bool
+)
+
+var chatCmd = &cobra.Command{
+	Use:     "chat [first message] --language [output language] --temperature [creativity] --output [transcript file]",
+	Example: "gencli chat --language english\ngit diff | gencli chat 'Review this change'",
+	Short:   "Start an interactive chat session that remembers the conversation",
+	Long:    "Start an interactive chat session with the configured GenAI model. Every message is sent together with the previous turns, so you can ask follow-up questions. Type /help inside the session to see the available commands. When a first message is given, anything piped to stdin is kept as context for the whole session, and the conversation continues on the terminal. Without a first message, piped lines are sent as messages one by one.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := validateRenderMode(); err != nil {
+			return err
+		}
+		if err := validateGeneration(cmd, GetConfigFunc("provider"), GetConfigFunc("genai_model"), 1); err != nil {
+			return err
+		}
+		session := newChatSession(GetConfigFunc("genai_model"))
+		if len(chatAttachPaths) > 0 {
+			ctx := context.Background()
+			provider, err := newProviderFunc(ctx)
+			if err != nil {
+				return err
+			}
+			session.provider = provider
+			session.attached, err = attachFiles(ctx, provider, chatAttachPaths, imageStripMetadata)
+			defer session.attached.cleanup(ctx, provider, deleteChatFiles)
+			if err != nil {
+				return err
+			}
+		}
+		if len(args) == 0 {
+			return runChat(session, cmd.InOrStdin(), "")
+		}
+
+		input, err := readPipedInput(cmd.InOrStdin(), maxPipedTextSize, "input")
+		if err != nil {
+			return err
+		}
+		if input == nil {
+			return runChat(session, cmd.InOrStdin(), strings.Join(args, " "))
+		}
+
+		// Stdin has been used up by the context, so the next messages are read from the terminal. Without one, the
+		// session ends after the first answer.
+		session.context = string(input)
+		tty, err := openTerminalFunc()
+		if err != nil {
+			return runChat(session, strings.NewReader(""), strings.Join(args, " "))
+		}
+		defer tty.Close()
+		return runChat(session, tty, strings.Join(args, " "))
+	},
+}
+
+// chatSession holds the state of a single chat REPL: the model in use and every turn so far.
+type chatSession struct {
+	model    string
+	history  []*genai.Content
+	provider Provider
+	// context is what was piped to stdin, sent with every turn.
+	context string
+	// attached holds the files given with --attach, sent with the first message of the conversation.
+	attached *attachments
+}
+
+func newChatSession(model string) *chatSession {
+	return &chatSession{model: model}
+}
+
+// contents returns the history to send, with the attachments added to its first message. They are added to every
+// request rather than stored in the history, so that they are kept by /clear.
+func (s *chatSession) contents() []*genai.Content {
+	if s.attached == nil || len(s.attached.parts) == 0 || len(s.history) == 0 {
+		return s.history
+	}
+	contents := slices.Clone(s.history)
+	contents[0] = genai.NewContentFromParts(append(slices.Clone(s.attached.parts), s.history[0].Parts...), genai.RoleUser)
+	return contents
+}
+
+// This function is used to get the response from the GenAI API, and was created to allow for testing.
+var getChatResponseFunc = getChatResponse
+
+func getChatResponse(session *chatSession) (string, error) {
+	ctx := context.Background()
+	if session.provider == nil {
+		provider, err := newProviderFunc(ctx)
+		if err != nil {
+			return "", err
+		}
+		session.provider = provider
+	}
+
+	config := generation.apply(&genai.GenerateContentConfig{
+		Temperature:       genai.Ptr(chatTemperature),
+		SystemInstruction: genai.NewContentFromText("Always respond in "+chatLanguage+" language.", genai.RoleUser),
+	})
+	if session.context != "" {
+		config.SystemInstruction.Parts = append(config.SystemInstruction.Parts, genai.NewPartFromText("\n\nUse the following content as context for the conversation:\n\n"+session.context))
+	}
+	resp, err := generateContent(ctx, session.provider, session.model, session.contents(), config)
+	if err != nil {
+		return "", err
+	}
+
+	return resp.Text(), nil
+}
+
+// runChat reads messages from in until it ends or /exit is typed. first, if not empty, is sent before reading.
+func runChat(session *chatSession, in io.Reader, first string) error {
+	fmt.Println("Chatting with", session.model+". Type /help for commands, /exit to quit.")
+	if first != "" {
+		fmt.Println(">", first)
+		sendChatMessage(session, first)
+	}
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for {
+		fmt.Print("> ")
+		if !scanner.Scan() {
+			fmt.Println()
+			break
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "/") {
+			if quit := handleChatCommand(session, line); quit {
+				break
+			}
+			continue
+		}
+
+		sendChatMessage(session, line)
+	}
+	return scanner.Err()
+}
+
+// sendChatMessage sends message with the previous turns and prints the answer. Errors are printed, so that the
+// session can go on.
+func sendChatMessage(session *chatSession, message string) {
+	session.history = append(session.history, genai.NewContentFromText(message, genai.RoleUser))
+	res, err := getChatResponseFunc(session)
+	if err != nil {
+		// Drop the unanswered message so that the next turn doesn't send it again.
+		session.history = session.history[:len(session.history)-1]
+		fmt.Println("Error:", err)
+		return
+	}
+
+	session.history = append(session.history, genai.NewContentFromText(res, genai.RoleModel))
+	fmt.Println(renderResponse(res, renderMode))
+}
+
+// handleChatCommand runs a slash-command typed inside the chat session. It reports whether the session should end.
+func handleChatCommand(session *chatSession, line string) bool {
+	fields := strings.Fields(line)
+	name, args := fields[0], fields[1:]
+
+	switch name {
+	case "/exit", "/quit":
+		return true
+	case "/clear":
+		session.history = nil
+		fmt.Println("Conversation cleared.")
+	case "/model":
+		if len(args) == 0 {
+			fmt.Println("Current model:", session.model)
+			break
+		}
+		session.model = args[0]
+		fmt.Println("Model for this session changed to:", session.model)
+	case "/save":
+		file := chatOutputFile
+		if len(args) > 0 {
+			file = args[0]
+		}
+		if err := saveChatTranscript(session, file); err != nil {
+			fmt.Println("Error:", err)
+			break
+		}
+		fmt.Printf("Conversation saved to: %s\n", file)
+	case "/help":
+		fmt.Println("Available commands:")
+		fmt.Println("  /model [name]  Show the current model or switch to another one for this session")
+		fmt.Println("  /save [file]   Save the conversation to a file (default: " + chatOutputFile + ")")
+		fmt.Println("  /clear         Forget the conversation so far")
+		fmt.Println("  /exit          End the session")
+	default:
+		fmt.Printf("Unknown command %q. Type /help for the list of commands.\n", name)
+	}
+	return false
+}
+
+func saveChatTranscript(session *chatSession, file string) error {
+	var sb strings.Builder
+	for _, content := range session.history {
+		speaker := "You"
+		if content.Role == genai.RoleModel {
+			speaker = "Model"
+		}
+		for _, part := range content.Parts {
+			fmt.Fprintf(&sb, "%s: %s\n\n", speaker, part.Text)
+		}
+	}
+
+	return saveResponseToFile(file, sb.String())
+}
+
+func init() {
+	chatCmd.Flags().StringVarP(&chatLanguage, "language", "l", defaultLanguage, "Output language")
+	chatCmd.Flags().Float32VarP(&chatTemperature, "temperature", "t", defaultTemperature, "Response creativity (0.0-1.0)")
+	chatCmd.Flags().StringArrayVarP(&chatAttachPaths, "attach", "a", nil, "Attach a file to the conversation, such as source code, a PDF, an image, audio or a video (repeatable)")
+	chatCmd.Flags().BoolVar(&deleteChatFiles, "delete-uploads", false, "Delete the attachments uploaded with the Gemini Files API when the session ends")
+	chatCmd.Flags().BoolVar(&imageStripMetadata, "strip-metadata", true, "Remove the metadata of the attached JPEG and PNG images, such as their GPS location, before sending them")
+	addGenerationFlags(chatCmd)
+	addRenderFlags(chatCmd)
+	chatCmd.Flags().StringVarP(&chatOutputFile, "output", "o", "chat.txt", "File used by /save when no file name is given")
+}
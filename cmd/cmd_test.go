@@ -2,17 +2,39 @@ package cmd
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
 	"io"
+	"iter"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/AlecAivazis/survey/v2"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/genai"
 )
 
 // TestMain sets up the test environment before running any tests.
@@ -22,9 +44,23 @@ func TestMain(m *testing.M) {
 	t := &testing.T{}
 	// Set a dummy API key so that API calls in tests don't fail.
 	t.Setenv("GOOGLE_API_KEY", "test-key")
+	// Keep the config files written by the commands out of the real home directory.
+	home, err := os.MkdirTemp("", "gencli-test")
+	if err != nil {
+		panic(err)
+	}
+	t.Setenv("HOME", home)
+	// Tests that don't pipe anything must not read the stdin of 'go test'.
+	if os.Stdin, err = os.Open(os.DevNull); err != nil {
+		panic(err)
+	}
+	// Don't wait between the retries of failed requests.
+	sleepFunc = func(ctx context.Context, d time.Duration) error { return nil }
 
 	// Run all tests.
-	os.Exit(m.Run())
+	code := m.Run()
+	os.RemoveAll(home)
+	os.Exit(code)
 }
 
 // executeCommand is a helper function that runs a cobra command with the given arguments,
@@ -44,6 +80,8 @@ func executeCommand(t *testing.T, root *cobra.Command, args ...string) (string,
 	// Ensure stdout is restored after command execution.
 	defer func() { os.Stdout = oldStdout }()
 
+	// Repeated flags keep the values of the previous executions, unlike in a new process, so they are emptied first.
+	resetRepeatedFlags(t, root)
 	// Set the command arguments.
 	root.SetArgs(args)
 	// Execute the command.
@@ -63,6 +101,134 @@ func executeCommand(t *testing.T, root *cobra.Command, args ...string) (string,
 	return buf.String(), cmdErr
 }
 
+// resetRepeatedFlags empties the repeated flags, such as --attach, of cmd and its subcommands.
+func resetRepeatedFlags(t *testing.T, cmd *cobra.Command) {
+	t.Helper()
+	cmd.LocalFlags().VisitAll(func(flag *pflag.Flag) {
+		if value, ok := flag.Value.(pflag.SliceValue); ok {
+			require.NoError(t, value.Replace(nil))
+			flag.Changed = false
+		}
+	})
+	for _, sub := range cmd.Commands() {
+		resetRepeatedFlags(t, sub)
+	}
+}
+
+// fakeProvider is an in-memory Provider that records every request and answers with canned chunks.
+type fakeProvider struct {
+	mu       sync.Mutex                   // Guards the recorded request, which batches send concurrently.
+	chunks   []string                     // Response chunks, joined for non-streaming requests.
+	err      error                        // Error returned instead of a response.
+	models   []string                     // Models returned by ListModels.
+	model    string                       // Model of the last request.
+	contents []*genai.Content             // Contents of the last request.
+	config   *genai.GenerateContentConfig // Config of the last request.
+	uploaded []string                     // Files uploaded with UploadFile.
+	deleted  []string                     // Files deleted with DeleteFile.
+	// Content of the files uploaded with UploadFile, read when they are uploaded.
+	uploadedData [][]byte
+	// Limits returned by ModelLimits, which fails when they are nil.
+	limits *modelLimits
+	// Grounding metadata added to non-streaming responses.
+	grounding *genai.GroundingMetadata
+	// Responses returned in turn by GenerateContent before falling back to chunks.
+	replies []string
+}
+
+func (p *fakeProvider) record(model string, contents []*genai.Content, config *genai.GenerateContentConfig) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.model, p.contents, p.config = model, contents, config
+}
+
+func (p *fakeProvider) GenerateContent(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error) {
+	p.record(model, contents, config)
+	if p.err != nil {
+		return nil, p.err
+	}
+	text := strings.Join(p.chunks, "")
+	p.mu.Lock()
+	if len(p.replies) > 0 {
+		text, p.replies = p.replies[0], p.replies[1:]
+	}
+	p.mu.Unlock()
+	resp := fakeResponse(text)
+	resp.Candidates[0].GroundingMetadata = p.grounding
+	// When several candidates are asked for, each is the response followed by its number.
+	if config != nil && config.CandidateCount > 1 {
+		resp.Candidates = nil
+		for i := range config.CandidateCount {
+			resp.Candidates = append(resp.Candidates, fakeResponse(fmt.Sprintf("%s %d", text, i+1)).Candidates...)
+		}
+	}
+	return resp, nil
+}
+
+func (p *fakeProvider) GenerateContentStream(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) iter.Seq2[*genai.GenerateContentResponse, error] {
+	p.record(model, contents, config)
+	return func(yield func(*genai.GenerateContentResponse, error) bool) {
+		if p.err != nil {
+			yield(nil, p.err)
+			return
+		}
+		for _, chunk := range p.chunks {
+			if !yield(fakeResponse(chunk), nil) {
+				return
+			}
+		}
+	}
+}
+
+func (p *fakeProvider) CountTokens(ctx context.Context, model string, contents []*genai.Content) (int32, error) {
+	p.record(model, contents, nil)
+	return int32(len(contents)), p.err
+}
+
+func (p *fakeProvider) ListModels(ctx context.Context) ([]string, error) {
+	return p.models, p.err
+}
+
+func (p *fakeProvider) ModelLimits(ctx context.Context, model string) (modelLimits, error) {
+	if p.limits == nil {
+		return modelLimits{}, errors.New("model not found")
+	}
+	return *p.limits, nil
+}
+
+func (p *fakeProvider) UploadFile(ctx context.Context, path string, mimeType string) (*genai.File, error) {
+	p.uploaded = append(p.uploaded, path)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p.uploadedData = append(p.uploadedData, data)
+	name := fmt.Sprintf("files/%d", len(p.uploaded))
+	return &genai.File{Name: name, URI: "https://example.com/" + name, MIMEType: mimeType}, nil
+}
+
+func (p *fakeProvider) DeleteFile(ctx context.Context, name string) error {
+	p.deleted = append(p.deleted, name)
+	return nil
+}
+
+// fakeResponse wraps text in a single-candidate response, the shape returned by the providers.
+func fakeResponse(text string) *genai.GenerateContentResponse {
+	return &genai.GenerateContentResponse{
+		Candidates: []*genai.Candidate{{Content: genai.NewContentFromText(text, genai.RoleModel)}},
+	}
+}
+
+// useFakeProvider makes the commands send their requests to p for the rest of the test.
+func useFakeProvider(t *testing.T, p *fakeProvider) {
+	t.Helper()
+	originalFunc := newProviderFunc
+	t.Cleanup(func() { newProviderFunc = originalFunc })
+	newProviderFunc = func(ctx context.Context) (Provider, error) {
+		return p, nil
+	}
+}
+
 // TestVersionCommand tests the 'version' subcommand.
 // It verifies that the command prints the expected version string.
 func TestVersionCommand(t *testing.T) {
@@ -171,12 +337,12 @@ func TestSearchCommand(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			// Override getApiResponseFunc to return the mock response for the test.
-			getApiResponseFunc = func(args []string) string {
+			getApiResponseFunc = func(args []string) (string, error) {
 				// If the query is empty, return an appropriate error message.
 				if len(args) > 0 && args[0] == "" {
-					return "query cannot be empty"
+					return "query cannot be empty", nil
 				}
-				return tc.mockResponse
+				return tc.mockResponse, nil
 			}
 
 			// Execute the search command with provided arguments.
@@ -189,194 +355,3168 @@ func TestSearchCommand(t *testing.T) {
 	}
 }
 
-// TestImageCommand tests the 'image' subcommand which analyzes images.
-// It covers scenarios like valid image path, invalid path, unsupported image format, and API error.
-func TestImageCommand(t *testing.T) {
-	// Backup the original getApiResponseImageFunc.
-	originalFunc := getApiResponseImageFunc
-	// Restore it after the tests.
-	defer func() { getApiResponseImageFunc = originalFunc }()
+// TestSearchStreaming tests the '--stream' mode of the 'search' subcommand.
+// It verifies that streamed output is printed once and that '--save' still writes the complete response.
+func TestSearchStreaming(t *testing.T) {
+	// Backup the original streamApiResponseFunc and restore it, along with the flags, after the tests.
+	originalFunc := streamApiResponseFunc
+	defer func() {
+		streamApiResponseFunc = originalFunc
+		streamOutput, saveOutput = false, false
+	}()
 
-	// Define file paths used in the tests.
-	validImagePath := filepath.Join("..", "assets", "test.jpg")
-	invalidImagePath := filepath.Join("..", "assets", "missing.jpg")
+	streamApiResponseFunc = func(args []string, w io.Writer) (string, error) {
+		for _, chunk := range []string{"streamed ", "response"} {
+			_, _ = io.WriteString(w, chunk)
+		}
+		_, _ = io.WriteString(w, "\n")
+		return "streamed response", nil
+	}
 
-	// Define test cases.
-	testCases := []struct {
-		name          string   // Name of the test case.
-		args          []string // Command line arguments to pass.
-		mockResponse  string   // Response to simulate (for non-error cases).
-		expectError   bool     // Whether we expect an error to be shown in the output.
-		errorContains string   // Substring that should be present in the error message.
+	t.Run("stream_to_stdout", func(t *testing.T) {
+		output, err := executeCommand(t, rootCmd, "search", "query", "--stream")
+		assert.NoError(t, err)
+		assert.Equal(t, "streamed response\n", output)
+	})
+
+	t.Run("stream_and_save", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "out", "response.txt")
+		output, err := executeCommand(t, rootCmd, "search", "query", "--stream", "--save", "--output", file)
+		assert.NoError(t, err)
+		assert.Contains(t, output, "streamed response\n")
+		assert.Contains(t, output, "Response saved to: "+file)
+
+		data, err := os.ReadFile(file)
+		require.NoError(t, err)
+		assert.Equal(t, "streamed response", string(data))
+	})
+}
+
+// TestRenderResponse tests the rendering of a Markdown response in every --render mode, including the cases the
+// line-based formatting it replaced got wrong: headings after the first line, snake_case identifiers and code blocks.
+func TestRenderResponse(t *testing.T) {
+	input := "Intro with snake_case_name and `go_test` [1][2].\n\n## Setup\n\nSome **bold** and _italic_ text.\n\n```go\nfunc main() {\n\t_ = my_var * 2 // **not bold**\n}\n```\n\n* first\n* second\n  1. nested\n\n> quoted\n\n| name | size |\n| --- | ---: |\n| a | 10 |\n| bb | 2 |\n\nSee [the docs](https://go.dev/doc/go1_25)."
+
+	tests := []struct {
+		mode string
+		want string
 	}{
-		{
-			name:         "valid_image",
-			args:         []string{"image", "test query", "--path", validImagePath, "--format", "jpg", "--language", "english"},
-			mockResponse: "image analysis",
-		},
-		{
-			name: "invalid_path",
-			// Simulate file-read error using an invalid file path.
-			args:          []string{"image", "query", "--path", invalidImagePath, "--format", "jpg", "--language", "english"},
-			expectError:   true,
-			errorContains: "no such file",
-		},
-		{
-			name: "unsupported_format",
-			// Provide an unsupported image format to simulate error.
-			args:          []string{"image", "query", "--path", validImagePath, "--format", "bmp", "--language", "english"},
-			expectError:   true,
-			errorContains: "unsupported format",
-		},
-		{
-			name: "api_error",
-			// Simulate an API error by returning "API_ERROR".
-			args:          []string{"image", "query", "--path", validImagePath, "--format", "jpg", "--language", "english"},
-			mockResponse:  "API_ERROR",
-			expectError:   true,
-			errorContains: "API_ERROR",
-		},
+		{renderMarkdown, input},
+		{renderPlain, "Intro with snake_case_name and go_test [1][2].\n\nSetup\n\nSome bold and italic text.\n\nfunc main() {\n\t_ = my_var * 2 // **not bold**\n}\n\n- first\n- second\n  1. nested\n\n> quoted\n\nname  size\n----  ----\na       10\nbb       2\n\nSee the docs (https://go.dev/doc/go1_25)."},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, renderResponse(input, tt.mode), tt.mode)
 	}
 
-	// Iterate over each test case.
-	for _, tc := range testCases {
-		t.Run(tc.name, func(t *testing.T) {
-			// Override getApiResponseImageFunc to simulate different responses based on flags.
-			getApiResponseImageFunc = func(args []string) string {
-				// Check if the required image path flag was provided.
-				if imageFilePath == "" {
-					return "Error: required flag \"path\" not set"
-				}
-				// Simulate error if an invalid file path is provided.
-				if imageFilePath == invalidImagePath {
-					return "Error: no such file"
-				}
-				// Simulate error for unsupported image formats.
-				if imageFileFormat == "bmp" {
-					return "Error: unsupported format"
-				}
-				// If mockResponse is "API_ERROR", simulate an API error.
-				if tc.mockResponse == "API_ERROR" {
-					return "API_ERROR"
-				}
-				// Otherwise, return the provided mock response.
-				return tc.mockResponse
-			}
+	// The ansi mode styles the same text with escape codes, and highlights the code.
+	ansi := renderResponse(input, renderANSI)
+	assert.Equal(t, renderResponse(input, renderPlain), strings.ReplaceAll(strings.ReplaceAll(ansiPattern.ReplaceAllString(ansi, ""), "• ", "- "), "│ ", "> "))
+	assert.Contains(t, ansi, "\x1b[1mSetup\x1b[22m")
+	assert.Contains(t, ansi, "\x1b[3mitalic\x1b[23m")
+	assert.Contains(t, ansi, "\x1b[36mgo_test\x1b[39m")
+	assert.Regexp(t, "\x1b\\[[0-9;]+mfunc\x1b", ansi)
+}
 
-			// Execute the image command.
-			output, err := executeCommand(t, rootCmd, tc.args...)
-			// Even if there is an error in processing, the command itself doesn't return an error (uses Run, not RunE).
-			assert.NoError(t, err)
-			if tc.expectError {
-				// Verify that the error message is included in the output.
-				assert.Contains(t, output, tc.errorContains)
-			} else {
-				// Verify that the successful response is printed.
-				assert.Contains(t, output, tc.mockResponse)
+// TestMarkdownStream verifies that rendering a streamed response gives the same result as rendering the complete
+// response, no matter where the chunks are split, including in the middle of a code block holding blank lines.
+func TestMarkdownStream(t *testing.T) {
+	input := "# Title\nSome **bold** and _italic_ text.\n\n---\n1. first\n\n2. second\n\n```\ncode\n\n**more** code\n```\nlast line"
+
+	for _, mode := range renderModes {
+		for _, size := range []int{1, 2, 3, 7, len(input)} {
+			var buf bytes.Buffer
+			stream := newMarkdownStream(&buf, mode)
+			for i := 0; i < len(input); i += size {
+				require.NoError(t, stream.Write(input[i:min(i+size, len(input))]))
 			}
-		})
+			require.NoError(t, stream.Flush())
+
+			assert.Equal(t, renderResponse(input, mode)+"\n", buf.String(), "mode %s, chunk size %d", mode, size)
+		}
 	}
 }
 
-// TestModelCommand tests the 'model' subcommand which allows the user to change the current model.
-// It simulates user selection using the survey package and verifies that the configuration is updated.
-func TestModelCommand(t *testing.T) {
-	// Backup the original functions to allow restoration later.
-	originalSurveyAskOne := surveyAskOne
+// TestProviderRequests tests the requests that search, image and chat send to the provider.
+// It uses a fake provider instead of swapping the getApiResponse functions, so the real request building runs.
+func TestProviderRequests(t *testing.T) {
 	originalGetConfigFunc := GetConfigFunc
-	originalUpdateConfigFunc := UpdateConfigFunc
-
-	// Restore the original functions after tests.
 	defer func() {
-		surveyAskOne = originalSurveyAskOne
 		GetConfigFunc = originalGetConfigFunc
-		UpdateConfigFunc = originalUpdateConfigFunc
+		rootCmd.SetIn(nil)
 	}()
-
-	// Setup an in-memory configuration map for testing purposes.
-	testConfig := make(map[string]string)
 	GetConfigFunc = func(key string) string {
-		return testConfig[key]
+		if key == "genai_model" {
+			return "gemini-2.5-flash"
+		}
+		return ""
 	}
-	UpdateConfigFunc = func(key, value string) {
-		testConfig[key] = value
+
+	provider := &fakeProvider{chunks: []string{"**Go** 1.25 ", "is out\n* item"}}
+	useFakeProvider(t, provider)
+
+	t.Run("search", func(t *testing.T) {
+		output, err := executeCommand(t, rootCmd, "search", "what is new", "in Go?", "--words", "50", "--language", "english", "--temperature", "0.2", "--stream=false", "--render", "plain")
+		require.NoError(t, err)
+		assert.Equal(t, "Go 1.25 is out\n\n- item\n", output)
+
+		assert.Equal(t, "gemini-2.5-flash", provider.model)
+		require.Len(t, provider.contents, 1)
+		assert.Equal(t, "what is new in Go? in 50 words in english language", provider.contents[0].Parts[0].Text)
+		assert.Equal(t, float32(0.2), *provider.config.Temperature)
+	})
+
+	t.Run("search_stream", func(t *testing.T) {
+		output, err := executeCommand(t, rootCmd, "search", "what is new", "--stream")
+		require.NoError(t, err)
+		assert.Equal(t, "Go 1.25 is out\n\n- item\n", output)
+		streamOutput = false
+	})
+
+	t.Run("search_markdown", func(t *testing.T) {
+		// The Markdown is kept as is, the default when the output isn't a terminal.
+		output, err := executeCommand(t, rootCmd, "search", "what is new", "--render", "markdown")
+		require.NoError(t, err)
+		assert.Equal(t, "**Go** 1.25 is out\n* item\n", output)
+
+		_, err = executeCommand(t, rootCmd, "search", "what is new", "--render", "html")
+		assert.ErrorIs(t, err, ErrInvalidInput)
+		renderMode = renderMarkdown
+	})
+
+	t.Run("image", func(t *testing.T) {
+		imagePath := filepath.Join("..", "assets", "test.jpg")
+		output, err := executeCommand(t, rootCmd, "image", "describe", "--path", imagePath, "--format", "jpeg", "--language", "german", "--stream=false")
+		require.NoError(t, err)
+		assert.Contains(t, output, "**Go** 1.25 is out")
+
+		require.Len(t, provider.contents, 1)
+		parts := provider.contents[0].Parts
+		require.Len(t, parts, 2)
+		assert.Equal(t, "image/jpeg", parts[0].InlineData.MIMEType)
+		assert.Equal(t, "describe in german language", parts[1].Text)
+	})
+
+	t.Run("chat", func(t *testing.T) {
+		rootCmd.SetIn(strings.NewReader("hello\nand then?\n"))
+		_, err := executeCommand(t, rootCmd, "chat", "--language", "english")
+		require.NoError(t, err)
+
+		// The second request carries the first question, its answer and the follow-up.
+		require.Len(t, provider.contents, 3)
+		assert.Equal(t, genai.RoleModel, provider.contents[1].Role)
+		assert.Equal(t, "and then?", provider.contents[2].Parts[0].Text)
+		assert.Contains(t, provider.config.SystemInstruction.Parts[0].Text, "english")
+	})
+}
+
+// TestGroundedSearch tests 'search --grounded', which enables Google Search and cites the pages the response is
+// based on with inline markers and a numbered list of sources.
+func TestGroundedSearch(t *testing.T) {
+	originalGetConfigFunc := GetConfigFunc
+	defer func() {
+		GetConfigFunc = originalGetConfigFunc
+		groundedSearch, streamOutput, renderMode = false, false, renderMarkdown
+	}()
+	providerName := ""
+	GetConfigFunc = func(key string) string {
+		if key == "provider" {
+			return providerName
+		}
+		return ""
 	}
 
-	// Define test cases for different model selections.
-	testCases := []struct {
-		name            string // Test case name.
-		mockSelection   string // Simulated user input from the survey.
-		expectedModel   string // Expected model configuration after the command.
-		expectedMessage string // Expected output message indicating model update.
-	}{
-		{
-			name:            "select_gemini_2.0_flash",
-			mockSelection:   "Gemini 2.0 Flash",
-			expectedModel:   "gemini-2.0-flash",
-			expectedMessage: "Model updated to: gemini-2.0-flash",
-		},
-		{
-			name:            "select_gemini_2.5_pro",
-			mockSelection:   "Gemini 2.5 Pro",
-			expectedModel:   "gemini-2.5-pro",
-			expectedMessage: "Model updated to: gemini-2.5-pro",
-		},
-		{
-			name:            "invalid_selection_default",
-			mockSelection:   "Invalid Model",
-			expectedModel:   "gemini-2.5-pro", // Default model in case of an invalid selection.
-			expectedMessage: "Model updated to: gemini-2.5-pro",
+	// The first claim ends inside "**Go 1.25**", the second is supported by two chunks, one of them a page that was
+	// also found by the first, and the third by a chunk that isn't a web page.
+	answer := "**Go 1.25** is out. It was released in August. Builds are _nice_."
+	provider := &fakeProvider{
+		chunks: []string{answer},
+		grounding: &genai.GroundingMetadata{
+			GroundingChunks: []*genai.GroundingChunk{
+				{Web: &genai.GroundingChunkWeb{Title: "go.dev", URI: "https://go.dev/doc/go1_25"}},
+				{Web: &genai.GroundingChunkWeb{Title: "Go blog", URI: "https://go.dev/blog"}},
+				{Web: &genai.GroundingChunkWeb{Title: "go.dev again", URI: "https://go.dev/doc/go1_25"}},
+				{RetrievedContext: &genai.GroundingChunkRetrievedContext{URI: "gs://notes"}},
+			},
+			GroundingSupports: []*genai.GroundingSupport{
+				{Segment: &genai.Segment{EndIndex: int32(strings.Index(answer, "**"+" is"))}, GroundingChunkIndices: []int32{0}},
+				{Segment: &genai.Segment{EndIndex: int32(strings.Index(answer, " Builds"))}, GroundingChunkIndices: []int32{2, 1, 0}},
+				{Segment: &genai.Segment{EndIndex: int32(len(answer))}, GroundingChunkIndices: []int32{3}},
+			},
 		},
 	}
+	useFakeProvider(t, provider)
 
-	// Iterate over each model selection test case.
-	for _, tc := range testCases {
-		t.Run(tc.name, func(t *testing.T) {
-			// Override surveyAskOne to simulate the user's selection.
-			surveyAskOne = func(p survey.Prompt, response interface{}, opts ...survey.AskOpt) error {
-				resp := response.(*string)
-				*resp = tc.mockSelection
-				return nil
-			}
+	t.Run("citations", func(t *testing.T) {
+		// --stream is ignored, since the citations are only known once the response is complete.
+		output, err := executeCommand(t, rootCmd, "search", "what is new in Go?", "--grounded", "--stream", "--render", "plain")
+		require.NoError(t, err)
+		assert.Equal(t, "Go 1.25[1] is out. It was released in August.[1][2] Builds are nice.\n\nSources:\n\n1. go.dev - https://go.dev/doc/go1_25\n2. Go blog - https://go.dev/blog\n", output)
+		assert.Equal(t, []*genai.Tool{{GoogleSearch: &genai.GoogleSearch{}}}, provider.config.Tools)
+	})
 
-			// Set an initial model configuration.
-			testConfig["genai_model"] = "gemini-2.0-flash"
+	t.Run("no_search", func(t *testing.T) {
+		// The model may answer without searching, in which case there are no sources.
+		grounding := provider.grounding
+		provider.grounding = nil
+		defer func() { provider.grounding = grounding }()
+		output, err := executeCommand(t, rootCmd, "search", "hello", "--grounded")
+		require.NoError(t, err)
+		assert.Equal(t, "Go 1.25 is out. It was released in August. Builds are nice.\n", output)
+	})
 
-			// Execute the model command.
-			output, err := executeCommand(t, rootCmd, "model")
-			require.NoError(t, err)
+	t.Run("not_grounded", func(t *testing.T) {
+		groundedSearch = false
+		_, err := executeCommand(t, rootCmd, "search", "hello", "--stream=false")
+		require.NoError(t, err)
+		assert.Empty(t, provider.config.Tools)
+	})
 
-			// Verify that the output contains the current model and the update message.
-			assert.Contains(t, output, "Current model: gemini-2.0-flash")
-			assert.Contains(t, output, tc.expectedMessage)
-			// Confirm that the configuration has been updated to the expected model.
-			currentModel := GetConfigFunc("genai_model")
-			assert.Equal(t, tc.expectedModel, currentModel)
-		})
-	}
+	t.Run("other_provider", func(t *testing.T) {
+		providerName = providerOllama
+		_, err := executeCommand(t, rootCmd, "search", "hello", "--grounded")
+		assert.ErrorIs(t, err, ErrInvalidInput)
+		assert.ErrorContains(t, err, "only available with the gemini provider")
+	})
 
-	// Test to ensure that the 'model' command is registered with the root command.
-	t.Run("command_registration", func(t *testing.T) {
-		found := false
-		for _, cmd := range rootCmd.Commands() {
-			if cmd.Name() == "model" {
-				found = true
-				break
-			}
+	t.Run("render_keeps_markers", func(t *testing.T) {
+		assert.Equal(t, "Title[1]\n\nSome bold[2][3] and italic[4] text.\n\n- item[5]", renderResponse("# Title[1]\nSome **bold**[2][3] and _italic_[4] text.\n* item[5]", renderPlain))
+	})
+}
+
+// TestSearchJSON tests 'search --output-format json' and '--json-schema', which ask for a JSON response, check it
+// and ask again, a bounded number of times, when it isn't valid.
+func TestSearchJSON(t *testing.T) {
+	originalGetConfigFunc := GetConfigFunc
+	defer func() {
+		GetConfigFunc = originalGetConfigFunc
+		searchFormat, searchSchemaFile, groundedSearch, streamOutput = "text", "", false, false
+	}()
+	GetConfigFunc = func(key string) string { return "" }
+	provider := &fakeProvider{}
+	useFakeProvider(t, provider)
+
+	dir := t.TempDir()
+	schemaFile := filepath.Join(dir, "moons.schema.json")
+	require.NoError(t, os.WriteFile(schemaFile, []byte(`{
+		"type": "array",
+		"items": {"type": "object", "properties": {"name": {"type": "string"}}, "required": ["name"]},
+		"minItems": 2
+	}`), 0644))
+	moons := `[{"name":"Ganymede"},{"name":"Callisto"}]`
+
+	t.Run("schema", func(t *testing.T) {
+		// The response is only printed once it matches the schema, and isn't streamed.
+		provider.replies = []string{`[{"name":"Ganymede"}]`, "```json\n" + moons + "\n```"}
+		output, err := executeCommand(t, rootCmd, "search", "largest moons of Jupiter", "--json-schema", schemaFile, "--stream")
+		require.NoError(t, err)
+		assert.Equal(t, moons+"\n", output)
+
+		assert.Equal(t, "application/json", provider.config.ResponseMIMEType)
+		assert.Equal(t, "array", provider.config.ResponseJsonSchema.(map[string]any)["type"])
+		// The second request holds the question, the first response and what is wrong with it.
+		require.Len(t, provider.contents, 3)
+		assert.Equal(t, "largest moons of Jupiter in english language. Answer with JSON only.", provider.contents[0].Parts[0].Text)
+		assert.Equal(t, `[{"name":"Ganymede"}]`, provider.contents[1].Parts[0].Text)
+		assert.Contains(t, provider.contents[2].Parts[0].Text, "doesn't match the schema")
+		searchFormat, searchSchemaFile, streamOutput = "text", "", false
+	})
+
+	t.Run("retries_exhausted", func(t *testing.T) {
+		provider.replies = nil
+		provider.chunks = []string{`[]`}
+		output, err := executeCommand(t, rootCmd, "search", "moons", "--json-schema", schemaFile)
+		assert.ErrorIs(t, err, ErrAPI)
+		assert.ErrorContains(t, err, "3 attempts")
+		assert.Empty(t, output)
+		assert.Len(t, provider.contents, 1+2*maxSchemaRetries)
+		searchFormat, searchSchemaFile = "text", ""
+	})
+
+	t.Run("json_without_schema", func(t *testing.T) {
+		provider.replies = []string{"Here are the moons: Ganymede, Callisto", `{"moons":2}`}
+		output, err := executeCommand(t, rootCmd, "search", "moons", "--output-format", "json")
+		require.NoError(t, err)
+		assert.Equal(t, `{"moons":2}`+"\n", output)
+		assert.Nil(t, provider.config.ResponseJsonSchema)
+		assert.Contains(t, provider.contents[2].Parts[0].Text, "isn't valid JSON")
+		searchFormat = "text"
+	})
+
+	t.Run("invalid_input", func(t *testing.T) {
+		invalidSchema := filepath.Join(dir, "invalid.schema.json")
+		require.NoError(t, os.WriteFile(invalidSchema, []byte(`{"type": 42}`), 0644))
+		tests := [][]string{
+			{"--json-schema", invalidSchema},
+			{"--json-schema", filepath.Join(dir, "missing.json")},
+			{"--json-schema", schemaFile, "--output-format", "text"},
+			{"--output-format", "yaml"},
+			{"--output-format", "json", "--grounded"},
+		}
+		for _, args := range tests {
+			_, err := executeCommand(t, rootCmd, append([]string{"search", "moons"}, args...)...)
+			assert.ErrorIs(t, err, ErrInvalidInput, args)
+			searchFormat, searchSchemaFile, groundedSearch = "text", "", false
 		}
-		assert.True(t, found, "model command should be registered")
 	})
 }
 
-// TestErrorHandling tests how the CLI handles invalid commands.
-// It verifies that an appropriate error message is shown when an unknown command is used.
-func TestErrorHandling(t *testing.T) {
-	t.Run("invalid_command", func(t *testing.T) {
-		// Attempt to execute a command that doesn't exist.
-		_, err := executeCommand(t, rootCmd, "invalid-command")
-		// Verify that an error is returned.
-		assert.Error(t, err)
-		// Check that the error message mentions "unknown command".
-		assert.Contains(t, err.Error(), "unknown command")
+// TestGenerationOptions tests the generation options shared by the generating commands: how they are sent, set from
+// the config and checked against the limits of the model and provider.
+func TestGenerationOptions(t *testing.T) {
+	originalGetConfigFunc := GetConfigFunc
+	defer func() {
+		GetConfigFunc = originalGetConfigFunc
+		generation, candidateCount, streamResponse, imageFileFormat = generationOptions{}, 1, false, ""
+	}()
+	config := map[string]string{"genai_model": "gemini-2.5-flash"}
+	GetConfigFunc = func(key string) string { return config[key] }
+	provider := &fakeProvider{chunks: []string{"answer"}}
+	useFakeProvider(t, provider)
+
+	t.Run("search", func(t *testing.T) {
+		_, err := executeCommand(t, rootCmd, "search", "q", "--stream=false", "--top-p", "0.9", "--top-k", "20", "--max-output-tokens", "100", "--stop", "END", "--stop", "##", "--seed", "42")
+		require.NoError(t, err)
+		assert.Equal(t, float32(0.9), *provider.config.TopP)
+		assert.Equal(t, float32(20), *provider.config.TopK)
+		assert.Equal(t, int32(100), provider.config.MaxOutputTokens)
+		assert.Equal(t, []string{"END", "##"}, provider.config.StopSequences)
+		assert.Equal(t, int32(42), *provider.config.Seed)
+		assert.Zero(t, provider.config.CandidateCount)
+		generation = generationOptions{}
+
+		// Options that aren't given are left to the model.
+		_, err = executeCommand(t, rootCmd, "search", "q", "--stream=false")
+		require.NoError(t, err)
+		assert.Nil(t, provider.config.TopP)
+		assert.Nil(t, provider.config.TopK)
+		assert.Nil(t, provider.config.Seed)
+		assert.Zero(t, provider.config.MaxOutputTokens)
+		assert.Empty(t, provider.config.StopSequences)
+	})
+
+	t.Run("image", func(t *testing.T) {
+		// The image command sends its temperature along with the options.
+		image := filepath.Join("..", "assets", "test.jpg")
+		_, err := executeCommand(t, rootCmd, "image", "describe", "-p", image, "--stream=false", "--temperature", "0.3", "--seed", "7")
+		require.NoError(t, err)
+		assert.Equal(t, float32(0.3), *provider.config.Temperature)
+		assert.Equal(t, int32(7), *provider.config.Seed)
+		generation = generationOptions{}
+	})
+
+	t.Run("candidates", func(t *testing.T) {
+		// Several responses are printed one after the other, and aren't streamed.
+		output, err := executeCommand(t, rootCmd, "search", "q", "--candidates", "2", "--stream", "--render", "markdown")
+		require.NoError(t, err)
+		assert.Equal(t, "### Candidate 1\n\nanswer 1\n\n### Candidate 2\n\nanswer 2\n", output)
+		assert.Equal(t, int32(2), provider.config.CandidateCount)
+		candidateCount, streamOutput = 1, false
+	})
+
+	t.Run("config_defaults", func(t *testing.T) {
+		config["seed"], config["stop"] = "11", "END,STOP"
+		defer func() { delete(config, "seed"); delete(config, "stop") }()
+		_, err := executeCommand(t, rootCmd, "image", "detect", "cats", "-p", filepath.Join("..", "assets", "test.jpg"))
+		require.Error(t, err) // "answer" isn't a list of boxes.
+		assert.Equal(t, int32(11), *provider.config.Seed)
+		assert.Equal(t, []string{"END", "STOP"}, provider.config.StopSequences)
+		generation = generationOptions{}
+
+		config["top_p"] = "2"
+		defer delete(config, "top_p")
+		_, err = executeCommand(t, rootCmd, "image", "extract", "-p", filepath.Join("..", "assets", "test.jpg"))
+		assert.ErrorIs(t, err, ErrConfig)
+		generation = generationOptions{}
+	})
+
+	t.Run("limits", func(t *testing.T) {
+		tests := []struct {
+			provider string
+			model    string
+			args     []string
+		}{
+			{"", "gemini-2.0-flash", []string{"--max-output-tokens", "10000"}},
+			{"", "gemini-2.0-flash", []string{"--top-k", "50"}},
+			{"", "gemini-2.5-pro", []string{"--top-k", "65"}},
+			{"", "gemini-2.5-pro", []string{"--candidates", "9"}},
+			{"", "gemini-2.5-pro", []string{"--stop", "a,b,c,d,e,f"}},
+			{"", "gemini-2.5-pro", []string{"--top-p", "1.5"}},
+			{"", "gemini-2.5-pro", []string{"--max-output-tokens", "0"}},
+			{"", "gemini-2.5-pro", []string{"--seed", "1.5"}},
+			{providerOpenAI, "gpt-internal", []string{"--top-k", "10"}},
+			{providerOllama, "llama3", []string{"--candidates", "2"}},
+		}
+		for _, tt := range tests {
+			config["provider"], config["genai_model"] = tt.provider, tt.model
+			_, err := executeCommand(t, rootCmd, append([]string{"search", "q", "--stream=false"}, tt.args...)...)
+			assert.ErrorIs(t, err, ErrInvalidInput, "%s %v", tt.model, tt.args)
+			generation, candidateCount = generationOptions{}, 1
+		}
+
+		// The same options are accepted within the limits of the model.
+		config["provider"], config["genai_model"] = "", "gemini-2.5-pro"
+		_, err := executeCommand(t, rootCmd, "search", "q", "--stream=false", "--top-k", "64", "--max-output-tokens", "65536", "--candidates", "8")
+		assert.NoError(t, err)
+		generation, candidateCount = generationOptions{}, 1
+
+		// Only the output limit of the Gemini 3 models is known, the others are left to the API.
+		config["genai_model"] = "gemini-3-pro-preview"
+		_, err = executeCommand(t, rootCmd, "search", "q", "--stream=false", "--top-k", "100")
+		assert.NoError(t, err)
+		generation = generationOptions{}
+	})
+
+	t.Run("limits_from_api", func(t *testing.T) {
+		// The limits reported by the Models API take precedence over the ones listed for the model.
+		config["provider"], config["genai_model"] = "", "gemini-2.5-pro"
+		provider.limits = &modelLimits{maxOutputTokens: 1000}
+		defer func() { provider.limits = nil }()
+		_, err := executeCommand(t, rootCmd, "search", "q", "--stream=false", "--max-output-tokens", "2000")
+		assert.ErrorIs(t, err, ErrInvalidInput)
+		assert.Contains(t, err.Error(), "at most 1000")
+		generation = generationOptions{}
+
+		// The limits that aren't reported, or all of them when the API fails, come from the list.
+		_, err = executeCommand(t, rootCmd, "search", "q", "--stream=false", "--top-k", "65")
+		assert.ErrorContains(t, err, "at most 64")
+		generation = generationOptions{}
+		provider.limits = nil
+		_, err = executeCommand(t, rootCmd, "search", "q", "--stream=false", "--max-output-tokens", "2000")
+		assert.NoError(t, err)
+		generation = generationOptions{}
+	})
+
+	t.Run("config_values_ignored", func(t *testing.T) {
+		originalStderr := os.Stderr
+		defer func() { os.Stderr = originalStderr }()
+		stderr, err := os.CreateTemp(t.TempDir(), "stderr")
+		require.NoError(t, err)
+		defer stderr.Close()
+		os.Stderr = stderr
+		// The flags given by the previous tests are still marked as changed.
+		for _, name := range []string{"top-k", "candidates", "max-output-tokens"} {
+			searchCmd.Flags().Lookup(name).Changed = false
+		}
+		defer func() {
+			delete(config, "top_k")
+			delete(config, "candidates")
+			delete(config, "max_output_tokens")
+		}()
+
+		// A top_k saved for Gemini doesn't break the commands once the provider is openai.
+		config["provider"], config["genai_model"], config["top_k"] = providerOpenAI, "gpt-internal", "40"
+		_, err = executeCommand(t, rootCmd, "search", "q", "--stream=false")
+		require.NoError(t, err)
+		assert.Nil(t, provider.config.TopK)
+		generation = generationOptions{}
+
+		config["provider"], config["genai_model"], config["candidates"] = providerOllama, "llama3", "2"
+		_, err = executeCommand(t, rootCmd, "search", "q", "--stream=false")
+		require.NoError(t, err)
+		assert.Zero(t, provider.config.CandidateCount)
+		candidateCount = 1
+
+		config["provider"], config["genai_model"], config["max_output_tokens"] = "", "gemini-2.0-flash", "65536"
+		delete(config, "top_k")
+		delete(config, "candidates")
+		_, err = executeCommand(t, rootCmd, "search", "q", "--stream=false")
+		require.NoError(t, err)
+		assert.Zero(t, provider.config.MaxOutputTokens)
+		generation = generationOptions{}
+
+		warnings, err := os.ReadFile(stderr.Name())
+		require.NoError(t, err)
+		assert.Contains(t, string(warnings), "Warning: --top-k isn't supported by the openai provider, top_k in the config is ignored")
+		assert.Contains(t, string(warnings), "Warning: --candidates isn't supported by the ollama provider, candidates in the config is ignored")
+		assert.Contains(t, string(warnings), "Warning: --max-output-tokens can be at most 8192 with gemini-2.0-flash, got 65536, max_output_tokens in the config is ignored")
+
+		// The same values are still rejected when given as flags.
+		config["provider"], config["genai_model"] = providerOpenAI, "gpt-internal"
+		_, err = executeCommand(t, rootCmd, "search", "q", "--stream=false", "--top-k", "40")
+		assert.ErrorIs(t, err, ErrInvalidInput)
+		generation = generationOptions{}
+		searchCmd.Flags().Lookup("top-k").Changed = false
+		config["provider"], config["genai_model"] = "", "gemini-2.5-flash"
+	})
+}
+
+// TestOpenAIProvider tests the OpenAI-compatible provider against a local stand-in for a /v1/chat/completions server.
+func TestOpenAIProvider(t *testing.T) {
+	// requests records the decoded body of every chat completions request.
+	var requests []map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-openai-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = io.WriteString(w, `{"error":{"message":"invalid api key"}}`)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1/models":
+			_, _ = io.WriteString(w, `{"data":[{"id":"internal-large"},{"id":"internal-small"}]}`)
+		case "/v1/chat/completions":
+			var body map[string]any
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			requests = append(requests, body)
+
+			if body["stream"] == true {
+				w.Header().Set("Content-Type", "text/event-stream")
+				for _, chunk := range []string{"Hello", " from", " stream"} {
+					_, _ = fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"content\":%q}}]}\n\n", chunk)
+				}
+				_, _ = io.WriteString(w, "data: [DONE]\n\n")
+				return
+			}
+			_, _ = io.WriteString(w, `{"choices":[{"message":{"role":"assistant","content":"Hello from openai"},"finish_reason":"stop"}],"usage":{"prompt_tokens":3,"completion_tokens":4,"total_tokens":7}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	provider, err := newOpenAIProvider(server.URL+"/v1/", "test-openai-key")
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	t.Run("generate_with_image", func(t *testing.T) {
+		requests = nil
+		contents := []*genai.Content{genai.NewContentFromParts([]*genai.Part{
+			genai.NewPartFromBytes([]byte("img"), "image/png"),
+			genai.NewPartFromText("what is this?"),
+		}, genai.RoleUser)}
+		config := &genai.GenerateContentConfig{
+			Temperature:       genai.Ptr[float32](0.3),
+			SystemInstruction: genai.NewContentFromText("be brief", genai.RoleUser),
+		}
+
+		resp, err := provider.GenerateContent(ctx, "internal-large", contents, config)
+		require.NoError(t, err)
+		assert.Equal(t, "Hello from openai", resp.Text())
+		assert.Equal(t, genai.FinishReasonStop, resp.Candidates[0].FinishReason)
+		assert.Equal(t, int32(7), resp.UsageMetadata.TotalTokenCount)
+
+		require.Len(t, requests, 1)
+		assert.Equal(t, "internal-large", requests[0]["model"])
+		assert.InDelta(t, 0.3, requests[0]["temperature"], 0.0001)
+		messages := requests[0]["messages"].([]any)
+		require.Len(t, messages, 2)
+		assert.Equal(t, map[string]any{"role": "system", "content": "be brief"}, messages[0])
+		parts := messages[1].(map[string]any)["content"].([]any)
+		assert.Equal(t, map[string]any{"type": "image_url", "image_url": map[string]any{"url": "data:image/png;base64,aW1n"}}, parts[0])
+		assert.Equal(t, map[string]any{"type": "text", "text": "what is this?"}, parts[1])
+	})
+
+	t.Run("json_schema", func(t *testing.T) {
+		// A JSON schema is sent as a json_schema response format, and JSON without a schema as json_object.
+		requests = nil
+		schema := map[string]any{"type": "array"}
+		_, err := provider.GenerateContent(ctx, "internal-large", genai.Text("list"), &genai.GenerateContentConfig{ResponseMIMEType: "application/json", ResponseJsonSchema: schema})
+		require.NoError(t, err)
+		_, err = provider.GenerateContent(ctx, "internal-large", genai.Text("list"), &genai.GenerateContentConfig{ResponseMIMEType: "application/json"})
+		require.NoError(t, err)
+		require.Len(t, requests, 2)
+		assert.Equal(t, map[string]any{"type": "json_schema", "json_schema": map[string]any{"name": "response", "schema": schema}}, requests[0]["response_format"])
+		assert.Equal(t, map[string]any{"type": "json_object"}, requests[1]["response_format"])
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		var text strings.Builder
+		for resp, err := range provider.GenerateContentStream(ctx, "internal-small", genai.Text("hi"), nil) {
+			require.NoError(t, err)
+			text.WriteString(resp.Text())
+		}
+		assert.Equal(t, "Hello from stream", text.String())
+	})
+
+	t.Run("list_models", func(t *testing.T) {
+		models, err := provider.ListModels(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"internal-large", "internal-small"}, models)
+	})
+
+	t.Run("error_status", func(t *testing.T) {
+		badProvider, err := newOpenAIProvider(server.URL+"/v1", "wrong-key")
+		require.NoError(t, err)
+
+		_, err = badProvider.GenerateContent(ctx, "internal-large", genai.Text("hi"), nil)
+		var statusErr *statusError
+		require.ErrorAs(t, err, &statusErr)
+		assert.Equal(t, http.StatusUnauthorized, statusErr.Code)
+		assert.Equal(t, "invalid api key", statusErr.Message)
+	})
+
+	t.Run("search_command", func(t *testing.T) {
+		originalGetConfigFunc := GetConfigFunc
+		defer func() { GetConfigFunc = originalGetConfigFunc }()
+		t.Setenv("INTERNAL_LLM_KEY", "test-openai-key")
+		testConfig := map[string]string{
+			"provider":    "openai",
+			"base_url":    server.URL + "/v1",
+			"api_key_env": "INTERNAL_LLM_KEY",
+			"genai_model": "internal-large",
+		}
+		GetConfigFunc = func(key string) string {
+			return testConfig[key]
+		}
+
+		output, err := executeCommand(t, rootCmd, "search", "hello", "--stream=false")
+		require.NoError(t, err)
+		assert.Contains(t, output, "Hello from openai")
+	})
+}
+
+// TestOllamaProvider tests the Ollama provider against a local stand-in for the Ollama server.
+func TestOllamaProvider(t *testing.T) {
+	// requests records the decoded body of every /api/chat request.
+	var requests []map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tags":
+			_, _ = io.WriteString(w, `{"models":[{"name":"llama3.2:latest"},{"name":"llava:7b"}]}`)
+		case "/api/chat":
+			var body map[string]any
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			requests = append(requests, body)
+
+			if body["model"] == "missing" {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = io.WriteString(w, `{"error":"model \"missing\" not found, try pulling it first"}`)
+				return
+			}
+			for _, chunk := range []string{"Offline", " answer"} {
+				_, _ = fmt.Fprintf(w, "{\"message\":{\"role\":\"assistant\",\"content\":%q},\"done\":false}\n", chunk)
+			}
+			_, _ = io.WriteString(w, `{"message":{"role":"assistant","content":""},"done":true,"done_reason":"stop","prompt_eval_count":5,"eval_count":2}`+"\n")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	provider, err := newOllamaProvider(server.URL)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	t.Run("generate_with_image", func(t *testing.T) {
+		requests = nil
+		contents := []*genai.Content{genai.NewContentFromParts([]*genai.Part{
+			genai.NewPartFromBytes([]byte("img"), "image/jpeg"),
+			genai.NewPartFromText("describe"),
+		}, genai.RoleUser)}
+
+		resp, err := provider.GenerateContent(ctx, "llava:7b", contents, &genai.GenerateContentConfig{Temperature: genai.Ptr[float32](0.1)})
+		require.NoError(t, err)
+		assert.Equal(t, "Offline answer", resp.Text())
+		assert.Equal(t, int32(7), resp.UsageMetadata.TotalTokenCount)
+
+		require.Len(t, requests, 1)
+		assert.Equal(t, false, requests[0]["stream"])
+		message := requests[0]["messages"].([]any)[0].(map[string]any)
+		assert.Equal(t, "describe", message["content"])
+		assert.Equal(t, []any{"aW1n"}, message["images"])
+		assert.InDelta(t, 0.1, requests[0]["options"].(map[string]any)["temperature"], 0.0001)
+	})
+
+	t.Run("json_schema", func(t *testing.T) {
+		requests = nil
+		schema := map[string]any{"type": "array"}
+		_, err := provider.GenerateContent(ctx, "llava:7b", genai.Text("list"), &genai.GenerateContentConfig{ResponseMIMEType: "application/json", ResponseJsonSchema: schema})
+		require.NoError(t, err)
+		_, err = provider.GenerateContent(ctx, "llava:7b", genai.Text("list"), &genai.GenerateContentConfig{ResponseMIMEType: "application/json"})
+		require.NoError(t, err)
+		require.Len(t, requests, 2)
+		assert.Equal(t, schema, requests[0]["format"])
+		assert.Equal(t, "json", requests[1]["format"])
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		var chunks []string
+		for resp, err := range provider.GenerateContentStream(ctx, "llama3.2:latest", genai.Text("hi"), nil) {
+			require.NoError(t, err)
+			chunks = append(chunks, resp.Text())
+		}
+		assert.Equal(t, []string{"Offline", " answer", ""}, chunks)
+		assert.Equal(t, true, requests[len(requests)-1]["stream"])
+	})
+
+	t.Run("list_models", func(t *testing.T) {
+		models, err := provider.ListModels(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"llama3.2:latest", "llava:7b"}, models)
+	})
+
+	t.Run("error_status", func(t *testing.T) {
+		_, err := provider.GenerateContent(ctx, "missing", genai.Text("hi"), nil)
+		var statusErr *statusError
+		require.ErrorAs(t, err, &statusErr)
+		assert.Equal(t, http.StatusNotFound, statusErr.Code)
+		assert.Contains(t, statusErr.Message, "try pulling it first")
+	})
+
+	t.Run("model_command_lists_local_models", func(t *testing.T) {
+		originalSurveyAskOne := surveyAskOne
+		originalGetConfigFunc := GetConfigFunc
+		originalUpdateConfigFunc := UpdateConfigFunc
+		defer func() {
+			surveyAskOne = originalSurveyAskOne
+			GetConfigFunc = originalGetConfigFunc
+			UpdateConfigFunc = originalUpdateConfigFunc
+		}()
+
+		testConfig := map[string]string{"provider": "ollama", "base_url": server.URL, "genai_model": "llava:7b"}
+		GetConfigFunc = func(key string) string {
+			return testConfig[key]
+		}
+		UpdateConfigFunc = func(key, value string) error {
+			testConfig[key] = value
+			return nil
+		}
+		surveyAskOne = func(p survey.Prompt, response interface{}, opts ...survey.AskOpt) error {
+			assert.Equal(t, []string{"llama3.2:latest", "llava:7b"}, p.(*survey.Select).Options)
+			*response.(*string) = "llama3.2:latest"
+			return nil
+		}
+
+		output, err := executeCommand(t, rootCmd, "model")
+		require.NoError(t, err)
+		assert.Contains(t, output, "Model updated to: llama3.2:latest")
+		assert.Equal(t, "llama3.2:latest", testConfig["genai_model"])
+	})
+}
+
+// TestStructuredOutputProviders verifies that the commands printing JSON send their schema to the OpenAI and Ollama
+// providers, as a json_schema response format and as the format of the request, and not only to Gemini.
+func TestStructuredOutputProviders(t *testing.T) {
+	defer func() {
+		searchFormat, searchSchemaFile = "text", ""
+		extractImagePath, extractSchemaFile, extractOutputFormat = "", "", "markdown"
+		detectImagePath, detectOutputFormat = "", "table"
+		imageFileFormat = ""
+	}()
+	imageFileFormat = ""
+	dir := t.TempDir()
+	schemaFile := filepath.Join(dir, "list.schema.json")
+	require.NoError(t, os.WriteFile(schemaFile, []byte(`{"type":"array","items":{"type":"string"}}`), 0644))
+	photo := filepath.Join("..", "assets", "test.jpg")
+	commands := map[string][]string{
+		"search":  {"search", "list three colors", "--json-schema", schemaFile, "--output-format", "json", "--stream=false"},
+		"extract": {"image", "extract", "--path", photo, "--schema", schemaFile, "--output-format", "json"},
+		"detect":  {"image", "detect", "the cars", "--path", photo},
+	}
+
+	testCases := []struct {
+		name string
+		// answer is the body of a response holding an empty JSON array, which matches every schema used here.
+		answer   string
+		provider func(url string) (Provider, error)
+		// schema returns the schema found in a request, nil when none was sent.
+		schema func(request map[string]any) any
+	}{
+		{
+			name:     "openai",
+			answer:   `{"choices":[{"message":{"role":"assistant","content":"[]"},"finish_reason":"stop"}]}`,
+			provider: func(url string) (Provider, error) { return newOpenAIProvider(url+"/v1/", "test-openai-key") },
+			schema: func(request map[string]any) any {
+				format, _ := request["response_format"].(map[string]any)
+				if format["type"] != "json_schema" {
+					return nil
+				}
+				return format["json_schema"].(map[string]any)["schema"]
+			},
+		},
+		{
+			name:     "ollama",
+			answer:   `{"message":{"role":"assistant","content":"[]"},"done":true,"done_reason":"stop"}` + "\n",
+			provider: newOllamaProvider,
+			schema: func(request map[string]any) any {
+				if format, ok := request["format"].(map[string]any); ok {
+					return format
+				}
+				return nil
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var requests []map[string]any
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var body map[string]any
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+				requests = append(requests, body)
+				_, _ = io.WriteString(w, tc.answer)
+			}))
+			defer server.Close()
+			provider, err := tc.provider(server.URL)
+			require.NoError(t, err)
+			originalNewProviderFunc := newProviderFunc
+			defer func() { newProviderFunc = originalNewProviderFunc }()
+			newProviderFunc = func(ctx context.Context) (Provider, error) { return provider, nil }
+
+			for name, args := range commands {
+				requests = nil
+				_, err := executeCommand(t, rootCmd, args...)
+				require.NoError(t, err, name)
+				require.Len(t, requests, 1, name)
+				schema, ok := tc.schema(requests[0]).(map[string]any)
+				require.True(t, ok, "%s sent no schema", name)
+				assert.Equal(t, "array", schema["type"], name)
+			}
+		})
+	}
+}
+
+// TestRetry verifies that transient API errors are retried with a backoff, and that streamed responses are only
+// retried before anything has been printed.
+func TestRetry(t *testing.T) {
+	originalGetConfigFunc := GetConfigFunc
+	originalSleepFunc := sleepFunc
+	defer func() {
+		GetConfigFunc = originalGetConfigFunc
+		sleepFunc = originalSleepFunc
+		maxRetries, retryMaxWait = defaultMaxRetries, defaultRetryMaxWait
+		rootCmd.PersistentFlags().Lookup("max-retries").Changed = false
+	}()
+
+	// Record the waits between retries instead of sleeping.
+	var waits []time.Duration
+	sleepFunc = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}
+
+	testConfig := map[string]string{"genai_model": "gemini-2.5-flash"}
+	GetConfigFunc = func(key string) string {
+		return testConfig[key]
+	}
+
+	// failures is the number of requests the fake server fails before answering.
+	var failures, requests int
+	gemini := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = io.WriteString(w, `{"error":{"code":503,"message":"The model is overloaded","status":"UNAVAILABLE","details":[{"@type":"type.googleapis.com/google.rpc.RetryInfo","retryDelay":"2s"}]}}`)
+			return
+		}
+
+		answer := `{"candidates":[{"content":{"role":"model","parts":[{"text":"Hello after retry"}]},"finishReason":"STOP"}]}`
+		if strings.HasSuffix(r.URL.Path, ":streamGenerateContent") {
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = fmt.Fprintf(w, "data: %s\n\n", answer)
+			return
+		}
+		_, _ = io.WriteString(w, answer)
+	}))
+	defer gemini.Close()
+	geminiBaseURL = gemini.URL
+	defer func() { geminiBaseURL = "" }()
+
+	t.Run("gemini_recovers", func(t *testing.T) {
+		failures, requests, waits = 2, 0, nil
+		output, err := executeCommand(t, rootCmd, "search", "hello", "--stream=false")
+		require.NoError(t, err)
+		assert.Contains(t, output, "Hello after retry")
+		assert.Equal(t, 3, requests)
+		// The delay sent in the RetryInfo detail is used instead of the backoff.
+		assert.Equal(t, []time.Duration{2 * time.Second, 2 * time.Second}, waits)
+	})
+
+	t.Run("gemini_stream_recovers", func(t *testing.T) {
+		failures, requests, waits = 1, 0, nil
+		output, err := executeCommand(t, rootCmd, "search", "hello", "--stream")
+		require.NoError(t, err)
+		assert.Contains(t, output, "Hello after retry")
+		assert.Equal(t, 2, requests)
+	})
+
+	t.Run("retries_exhausted", func(t *testing.T) {
+		failures, requests, waits = 10, 0, nil
+		_, err := executeCommand(t, rootCmd, "search", "hello", "--stream=false", "--max-retries", "2")
+		assert.ErrorIs(t, err, ErrAPI)
+		assert.Equal(t, 3, requests)
+	})
+
+	t.Run("config", func(t *testing.T) {
+		rootCmd.PersistentFlags().Lookup("max-retries").Changed = false
+		testConfig["max_retries"] = "0"
+		testConfig["retry_max_wait"] = "1s"
+		defer func() {
+			delete(testConfig, "max_retries")
+			delete(testConfig, "retry_max_wait")
+			// The config values became the flags' values.
+			maxRetries, retryMaxWait = defaultMaxRetries, defaultRetryMaxWait
+		}()
+
+		failures, requests, waits = 1, 0, nil
+		_, err := executeCommand(t, rootCmd, "search", "hello", "--stream=false")
+		assert.ErrorIs(t, err, ErrAPI)
+		assert.Equal(t, 1, requests)
+
+		testConfig["max_retries"] = "many"
+		_, err = executeCommand(t, rootCmd, "search", "hello", "--stream=false")
+		assert.ErrorIs(t, err, ErrConfig)
+	})
+
+	t.Run("openai_retry_after", func(t *testing.T) {
+		var openaiRequests int
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			openaiRequests++
+			if openaiRequests == 1 {
+				w.Header().Set("Retry-After", "7")
+				w.WriteHeader(http.StatusTooManyRequests)
+				_, _ = io.WriteString(w, `{"error":{"message":"slow down"}}`)
+				return
+			}
+			_, _ = io.WriteString(w, `{"choices":[{"message":{"role":"assistant","content":"Hello from openai"},"finish_reason":"stop"}]}`)
+		}))
+		defer server.Close()
+
+		provider, err := newOpenAIProvider(server.URL, "test-openai-key")
+		require.NoError(t, err)
+
+		waits = nil
+		resp, err := generateContent(context.Background(), provider, "internal", genai.Text("hi"), nil)
+		require.NoError(t, err)
+		assert.Equal(t, "Hello from openai", resp.Text())
+		assert.Equal(t, 2, openaiRequests)
+		assert.Equal(t, []time.Duration{7 * time.Second}, waits)
+	})
+
+	t.Run("backoff", func(t *testing.T) {
+		policy := retryPolicy{maxRetries: 3, maxWait: 3 * time.Second}
+		unavailable := &statusError{Code: http.StatusServiceUnavailable}
+
+		// The wait doubles with every attempt, with up to half of it random, and stops growing at maxWait.
+		for attempt, limit := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second} {
+			wait, retry := policy.backoff(attempt, unavailable)
+			assert.True(t, retry)
+			assert.GreaterOrEqual(t, wait, limit/2)
+			assert.LessOrEqual(t, wait, limit)
+		}
+		_, retry := policy.backoff(3, unavailable)
+		assert.False(t, retry, "retries exhausted")
+
+		_, retry = policy.backoff(0, &statusError{Code: http.StatusBadRequest})
+		assert.False(t, retry, "bad request")
+		_, retry = policy.backoff(0, errors.New("connection refused"))
+		assert.False(t, retry, "not an API error")
+
+		// A Retry-After beyond maxWait is capped.
+		wait, _ := policy.backoff(0, &statusError{Code: http.StatusTooManyRequests, RetryAfter: time.Minute})
+		assert.Equal(t, 3*time.Second, wait)
+
+		assert.Equal(t, 120*time.Second, parseRetryAfter("120"))
+		assert.Equal(t, time.Duration(0), parseRetryAfter("soon"))
+	})
+}
+
+// TestProviderAPIKey verifies that only the providers that need an API key refuse to work without one.
+func TestProviderAPIKey(t *testing.T) {
+	originalGetConfigFunc := GetConfigFunc
+	defer func() { GetConfigFunc = originalGetConfigFunc }()
+	t.Setenv("GOOGLE_API_KEY", "")
+
+	testCases := []struct {
+		provider    string
+		expectError error
+	}{
+		{provider: "", expectError: ErrAuth},
+		{provider: "gemini", expectError: ErrAuth},
+		{provider: "openai"},
+		{provider: "ollama"},
+		{provider: "unknown", expectError: ErrConfig},
+	}
+
+	for _, tc := range testCases {
+		GetConfigFunc = func(key string) string {
+			if key == "provider" {
+				return tc.provider
+			}
+			return ""
+		}
+		_, err := newProvider(context.Background())
+		if tc.expectError != nil {
+			assert.ErrorIs(t, err, tc.expectError, "provider %q", tc.provider)
+		} else {
+			assert.NoError(t, err, "provider %q", tc.provider)
+		}
+	}
+}
+
+// TestPipedInput tests that the search, image and chat commands read piped stdin within a size limit, and hint when
+// stdin is left open.
+func TestPipedInput(t *testing.T) {
+	provider := &fakeProvider{chunks: []string{"answer"}}
+	useFakeProvider(t, provider)
+	defer func() {
+		rootCmd.SetIn(nil)
+		searchInput, imageInput = "", nil
+	}()
+
+	t.Run("search_context", func(t *testing.T) {
+		// Piped input is sent before the question, as its context.
+		rootCmd.SetIn(strings.NewReader("diff --git a/main.go b/main.go"))
+		_, err := executeCommand(t, rootCmd, "search", "review", "this", "--stream=false", "--words", "150")
+		require.NoError(t, err)
+		parts := provider.contents[0].Parts
+		require.Len(t, parts, 2)
+		assert.Equal(t, "diff --git a/main.go b/main.go\n\n", parts[0].Text)
+		assert.Equal(t, "review this in 150 words in english language", parts[1].Text)
+	})
+
+	t.Run("search_question_from_stdin", func(t *testing.T) {
+		rootCmd.SetIn(strings.NewReader("What is Go?\n"))
+		_, err := executeCommand(t, rootCmd, "search", "--stream=false", "--words", "150")
+		require.NoError(t, err)
+		assert.Equal(t, "What is Go? in 150 words in english language", contentText(provider.contents[0]))
+
+		rootCmd.SetIn(strings.NewReader(""))
+		_, err = executeCommand(t, rootCmd, "search", "--stream=false")
+		assert.ErrorIs(t, err, ErrInvalidInput)
+	})
+
+	t.Run("size_limit", func(t *testing.T) {
+		originalLimit := maxPipedTextSize
+		maxPipedTextSize = 8
+		defer func() { maxPipedTextSize = originalLimit }()
+
+		rootCmd.SetIn(strings.NewReader("more than eight bytes"))
+		_, err := executeCommand(t, rootCmd, "search", "summarize", "--stream=false")
+		assert.ErrorIs(t, err, ErrInvalidInput)
+		assert.Contains(t, err.Error(), "larger than the 8 B limit")
+	})
+
+	t.Run("image_from_stdin", func(t *testing.T) {
+		rootCmd.SetIn(bytes.NewReader([]byte("\x89PNG\r\n\x1a\n image bytes")))
+		_, err := executeCommand(t, rootCmd, "image", "what is this?", "--path", "-", "--format", "png", "--stream=false")
+		require.NoError(t, err)
+		parts := provider.contents[0].Parts
+		require.Len(t, parts, 2)
+		assert.Equal(t, []byte("\x89PNG\r\n\x1a\n image bytes"), parts[0].InlineData.Data)
+		assert.Equal(t, "image/png", parts[0].InlineData.MIMEType)
+
+		rootCmd.SetIn(strings.NewReader(""))
+		_, err = executeCommand(t, rootCmd, "image", "what is this?", "--path", "-", "--stream=false")
+		assert.ErrorIs(t, err, ErrInvalidInput)
+		imageFileFormat = ""
+	})
+
+	t.Run("chat_context", func(t *testing.T) {
+		originalOpenTerminalFunc := openTerminalFunc
+		defer func() { openTerminalFunc = originalOpenTerminalFunc }()
+		// The follow-up message is typed on the terminal, because stdin holds the context.
+		openTerminalFunc = func() (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader("and the tests?\n/exit\n")), nil
+		}
+
+		rootCmd.SetIn(strings.NewReader("func main() {}"))
+		output, err := executeCommand(t, rootCmd, "chat", "review", "this")
+		require.NoError(t, err)
+		assert.Contains(t, output, "> review this")
+		// The last request holds both turns and the answer in between.
+		require.Len(t, provider.contents, 3)
+		assert.Equal(t, "and the tests?", contentText(provider.contents[2]))
+		assert.Contains(t, contentText(provider.config.SystemInstruction), "func main() {}")
+
+		// Without a terminal, the session ends after the first answer.
+		openTerminalFunc = func() (io.ReadCloser, error) { return nil, errors.New("no terminal") }
+		rootCmd.SetIn(strings.NewReader("func main() {}"))
+		output, err = executeCommand(t, rootCmd, "chat", "review", "this")
+		require.NoError(t, err)
+		assert.Contains(t, output, "answer")
+		require.Len(t, provider.contents, 1)
+	})
+
+	t.Run("is_piped", func(t *testing.T) {
+		r, w, err := os.Pipe()
+		require.NoError(t, err)
+		defer r.Close()
+		defer w.Close()
+		assert.True(t, isPiped(r))
+		assert.False(t, isPiped(os.Stdin), "the null device is not piped")
+	})
+
+	t.Run("open_pipe", func(t *testing.T) {
+		// A pipe whose writer is never closed, as CI runners and ssh sometimes leave stdin.
+		r, w, err := os.Pipe()
+		require.NoError(t, err)
+		defer r.Close()
+		defer w.Close()
+		defer func() {
+			noStdin = false
+			rootCmd.PersistentFlags().Lookup("no-stdin").Changed = false
+		}()
+
+		rootCmd.SetIn(r)
+		done := make(chan error, 1)
+		go func() {
+			_, err := executeCommand(t, rootCmd, "search", "what is Go?", "--stream=false", "--no-stdin")
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+			assert.Equal(t, "what is Go? in 150 words in english language", contentText(provider.contents[0]))
+		case <-time.After(5 * time.Second):
+			t.Fatal("search waited for stdin despite --no-stdin")
+		}
+
+		_, err = executeCommand(t, rootCmd, "image", "what is this?", "--path", "-", "--stream=false", "--no-stdin")
+		assert.ErrorIs(t, err, ErrInvalidInput)
+		assert.Contains(t, err.Error(), "can't be used with --no-stdin")
+	})
+
+	t.Run("open_pipe_hint", func(t *testing.T) {
+		originalDelay, originalStderr := stdinHintDelay, os.Stderr
+		defer func() { stdinHintDelay, os.Stderr = originalDelay, originalStderr }()
+		stdinHintDelay = 10 * time.Millisecond
+		stderr, err := os.CreateTemp(t.TempDir(), "stderr")
+		require.NoError(t, err)
+		defer stderr.Close()
+		os.Stderr = stderr
+
+		// Without --no-stdin, a hint is printed while nothing is written to the pipe.
+		r, w, err := os.Pipe()
+		require.NoError(t, err)
+		defer r.Close()
+		go func() {
+			time.Sleep(200 * time.Millisecond)
+			w.WriteString("late input")
+			w.Close()
+		}()
+		data, err := readPipedInput(r, maxPipedTextSize, "input")
+		require.NoError(t, err)
+		assert.Equal(t, "late input", string(data))
+		hint, err := os.ReadFile(stderr.Name())
+		require.NoError(t, err)
+		assert.Contains(t, string(hint), "pass --no-stdin")
+	})
+}
+
+// TestAttachments tests the files attached with --attach: how their type is detected, how they are sent inline or
+// uploaded, and which types each provider accepts.
+func TestAttachments(t *testing.T) {
+	provider := &fakeProvider{chunks: []string{"answer"}}
+	useFakeProvider(t, provider)
+	originalLimit := maxInlineAttachmentSize
+	defer func() {
+		maxInlineAttachmentSize = originalLimit
+		deleteSearchFiles = false
+		rootCmd.SetIn(nil)
+	}()
+
+	dir := t.TempDir()
+	writeFile := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, data, 0644))
+		return path
+	}
+	code := writeFile("main.go", []byte("package main\n\nfunc main() {}\n"))
+	pdf := writeFile("paper.pdf", []byte("%PDF-1.7 paper"))
+	// A PNG without an extension is recognised by its content.
+	png := writeFile("screenshot", []byte("\x89PNG\r\n\x1a\n rest of the image"))
+	binary := writeFile("archive.bin", []byte{0x1f, 0x8b, 0x08, 0x00, 0x00})
+
+	t.Run("detect_type", func(t *testing.T) {
+		testCases := []struct {
+			file     string
+			expected string
+		}{
+			{code, "text/plain"},
+			{pdf, "application/pdf"},
+			{png, "image/png"},
+		}
+		for _, tc := range testCases {
+			mimeType, err := detectAttachmentType(tc.file)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, mimeType, tc.file)
+		}
+
+		_, err := detectAttachmentType(binary)
+		assert.ErrorIs(t, err, ErrInvalidInput)
+	})
+
+	t.Run("search", func(t *testing.T) {
+		// Attachments come before the question, text files as text and other files as inline data.
+		_, err := executeCommand(t, rootCmd, "search", "explain", "--attach", code, "-a", pdf, "--stream=false")
+		require.NoError(t, err)
+		parts := provider.contents[0].Parts
+		require.Len(t, parts, 3)
+		assert.Equal(t, "Attached file main.go:\n\npackage main\n\nfunc main() {}\n\n\n", parts[0].Text)
+		assert.Equal(t, "application/pdf", parts[1].InlineData.MIMEType)
+		assert.Contains(t, parts[2].Text, "explain in")
+
+		_, err = executeCommand(t, rootCmd, "search", "explain", "--attach", filepath.Join(dir, "missing.txt"), "--stream=false")
+		assert.ErrorIs(t, err, ErrInvalidInput)
+		_, err = executeCommand(t, rootCmd, "search", "explain", "--attach", dir, "--stream=false")
+		assert.ErrorIs(t, err, ErrInvalidInput)
+	})
+
+	t.Run("upload", func(t *testing.T) {
+		maxInlineAttachmentSize = 8
+		provider.uploaded, provider.deleted = nil, nil
+
+		// Files above the inline limit are uploaded, and only deleted with --delete-uploads.
+		_, err := executeCommand(t, rootCmd, "search", "summarize", "--attach", pdf, "--stream=false")
+		require.NoError(t, err)
+		assert.Equal(t, []string{pdf}, provider.uploaded)
+		fileData := provider.contents[0].Parts[0].FileData
+		require.NotNil(t, fileData)
+		assert.Equal(t, "https://example.com/files/1", fileData.FileURI)
+		assert.Equal(t, "application/pdf", fileData.MIMEType)
+		assert.Empty(t, provider.deleted)
+
+		_, err = executeCommand(t, rootCmd, "search", "summarize", "--attach", pdf, "--delete-uploads", "--stream=false")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"files/2"}, provider.deleted)
+		deleteSearchFiles = false
+
+		// Providers without a Files API can't send large files.
+		originalNewProviderFunc := newProviderFunc
+		newProviderFunc = func(ctx context.Context) (Provider, error) {
+			return struct{ Provider }{provider}, nil
+		}
+		_, err = executeCommand(t, rootCmd, "search", "summarize", "--attach", pdf, "--stream=false")
+		assert.ErrorIs(t, err, ErrInvalidInput)
+		newProviderFunc = originalNewProviderFunc
+		maxInlineAttachmentSize = originalLimit
+	})
+
+	t.Run("other_providers", func(t *testing.T) {
+		originalGetConfigFunc := GetConfigFunc
+		defer func() { GetConfigFunc = originalGetConfigFunc }()
+		for _, name := range []string{providerOpenAI, providerOllama} {
+			GetConfigFunc = func(key string) string {
+				if key == "provider" {
+					return name
+				}
+				return ""
+			}
+			// Only text and images can be sent to the other providers.
+			provider.contents = nil
+			_, err := executeCommand(t, rootCmd, "search", "summarize", "--attach", pdf, "--stream=false")
+			assert.ErrorIs(t, err, ErrInvalidInput, name)
+			assert.ErrorContains(t, err, "can't attach "+pdf+", the "+name+" provider only reads text and image files")
+			assert.Nil(t, provider.contents, name)
+
+			_, err = executeCommand(t, rootCmd, "search", "explain", "--attach", code, "--attach", png, "--stream=false")
+			require.NoError(t, err, name)
+			assert.Equal(t, "image/png", provider.contents[0].Parts[1].InlineData.MIMEType)
+		}
+	})
+
+	t.Run("chat", func(t *testing.T) {
+		// The attachments are sent with the first message of every request, also after /clear.
+		rootCmd.SetIn(strings.NewReader("what does it do?\nand now?\n/clear\nagain\n/exit\n"))
+		_, err := executeCommand(t, rootCmd, "chat", "--attach", code)
+		require.NoError(t, err)
+		require.Len(t, provider.contents, 1)
+		parts := provider.contents[0].Parts
+		require.Len(t, parts, 2)
+		assert.Contains(t, parts[0].Text, "Attached file main.go")
+		assert.Equal(t, "again", parts[1].Text)
+
+		rootCmd.SetIn(strings.NewReader("/exit\n"))
+		_, err = executeCommand(t, rootCmd, "chat", "--attach", binary)
+		assert.ErrorIs(t, err, ErrInvalidInput)
+	})
+}
+
+// TestImageCommand tests the 'image' subcommand which analyzes images.
+// It covers scenarios like valid image path, invalid path, unsupported image format, and API error.
+func TestImageCommand(t *testing.T) {
+	// Backup the original getApiResponseImageFunc.
+	originalFunc := getApiResponseImageFunc
+	// Restore it after the tests.
+	defer func() { getApiResponseImageFunc = originalFunc }()
+
+	// Define file paths used in the tests.
+	validImagePath := filepath.Join("..", "assets", "test.jpg")
+	invalidImagePath := filepath.Join("..", "assets", "missing.jpg")
+
+	// Define test cases.
+	testCases := []struct {
+		name          string   // Name of the test case.
+		args          []string // Command line arguments to pass.
+		mockResponse  string   // Response to simulate (for non-error cases).
+		expectError   bool     // Whether we expect the command to return an error.
+		errorContains string   // Substring that should be present in the error message.
+	}{
+		{
+			name:         "valid_image",
+			args:         []string{"image", "test query", "--path", validImagePath, "--format", "jpg", "--language", "english"},
+			mockResponse: "image analysis",
+		},
+		{
+			name: "invalid_path",
+			// Simulate file-read error using an invalid file path.
+			args:          []string{"image", "query", "--path", invalidImagePath, "--format", "jpg", "--language", "english"},
+			expectError:   true,
+			errorContains: "no such file",
+		},
+		{
+			name: "unsupported_format",
+			// Unsupported image formats are rejected before calling the API.
+			args:          []string{"image", "query", "--path", validImagePath, "--format", "bmp", "--language", "english"},
+			expectError:   true,
+			errorContains: "unsupported image format",
+		},
+		{
+			name: "api_error",
+			// Simulate an API error by returning "API_ERROR".
+			args:          []string{"image", "query", "--path", validImagePath, "--format", "jpg", "--language", "english"},
+			mockResponse:  "API_ERROR",
+			expectError:   true,
+			errorContains: "API_ERROR",
+		},
+	}
+
+	// Iterate over each test case.
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Override getApiResponseImageFunc to simulate different responses based on flags.
+			getApiResponseImageFunc = func(args []string) (string, error) {
+				// Simulate error if an invalid file path is provided.
+				if slices.Contains(imageFilePaths, invalidImagePath) {
+					return "", invalidInput(errors.New("no such file"))
+				}
+				// If mockResponse is "API_ERROR", simulate an API error.
+				if tc.mockResponse == "API_ERROR" {
+					return "", fmt.Errorf("%w: API_ERROR", ErrAPI)
+				}
+				// Otherwise, return the provided mock response.
+				return tc.mockResponse, nil
+			}
+
+			// Execute the image command.
+			output, err := executeCommand(t, rootCmd, tc.args...)
+			if tc.expectError {
+				// Verify that the command failed with the expected error.
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.errorContains)
+			} else {
+				assert.NoError(t, err)
+				// Verify that the successful response is printed.
+				assert.Contains(t, output, tc.mockResponse)
+			}
+		})
+	}
+}
+
+// TestImageFormat verifies that the image format is detected from the image content and checked against --format.
+func TestImageFormat(t *testing.T) {
+	pngData := []byte("\x89PNG\r\n\x1a\n rest of the image")
+	jpegData, err := os.ReadFile(filepath.Join("..", "assets", "test.jpg"))
+	require.NoError(t, err)
+	heicData := []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00")
+	unknownHEIF := []byte("\x00\x00\x00\x18ftypmiaf\x00\x00\x00\x00")
+
+	testCases := []struct {
+		name     string
+		data     []byte
+		format   string
+		expected string // Expected MIME type, empty when an error is expected.
+	}{
+		{name: "detected_png", data: pngData, expected: "image/png"},
+		{name: "detected_jpeg", data: jpegData, expected: "image/jpeg"},
+		{name: "detected_heic", data: heicData, expected: "image/heic"},
+		{name: "matching_format", data: pngData, format: "PNG", expected: "image/png"},
+		// jpg is accepted as an alias of jpeg, instead of producing the invalid image/jpg.
+		{name: "jpg_alias", data: jpegData, format: "jpg", expected: "image/jpeg"},
+		{name: "mismatching_format", data: pngData, format: "jpeg"},
+		{name: "unsupported_format", data: pngData, format: "bmp"},
+		// HEIF files with an unknown brand aren't recognised, so --format heif or heic is trusted for binary data.
+		{name: "undetected_with_format", data: unknownHEIF, format: "heif", expected: "image/heif"},
+		{name: "undetected_with_other_format", data: unknownHEIF, format: "png"},
+		{name: "undetected", data: unknownHEIF},
+		// Data that isn't an image, or is an unsupported image, isn't sent whatever --format says.
+		{name: "text_with_format", data: []byte("not an image at all"), format: "heic"},
+		{name: "bmp_with_format", data: []byte("BM\x36\x00\x00\x00\x00\x00"), format: "heif"},
+		{name: "tiff_with_format", data: []byte("II*\x00\x08\x00\x00\x00"), format: "heic"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mimeType, err := imageMIMEType(tc.data, tc.format)
+			if tc.expected == "" {
+				assert.ErrorIs(t, err, ErrInvalidInput)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, mimeType)
+		})
+	}
+
+	t.Run("command", func(t *testing.T) {
+		provider := &fakeProvider{chunks: []string{"answer"}}
+		useFakeProvider(t, provider)
+		// --format keeps the value given by the previous tests.
+		imageFileFormat = ""
+		defer func() { imageFileFormat = "" }()
+		imagePath := filepath.Join(t.TempDir(), "screenshot.jpg")
+		require.NoError(t, os.WriteFile(imagePath, pngData, 0644))
+
+		// The PNG is sent as a PNG even though its extension says otherwise.
+		_, err := executeCommand(t, rootCmd, "image", "describe", "--path", imagePath, "--stream=false")
+		require.NoError(t, err)
+		assert.Equal(t, "image/png", provider.contents[0].Parts[0].InlineData.MIMEType)
+
+		// A --format that contradicts the content is rejected before calling the API.
+		provider.contents = nil
+		_, err = executeCommand(t, rootCmd, "image", "describe", "--path", imagePath, "--format", "jpeg", "--stream=false")
+		assert.ErrorIs(t, err, ErrInvalidInput)
+		assert.Contains(t, err.Error(), "the image is a png")
+		assert.Nil(t, provider.contents)
+
+		_, err = executeCommand(t, rootCmd, "image", "describe", "--path", imagePath, "--format", "tiff", "--stream=false")
+		assert.ErrorIs(t, err, ErrInvalidInput)
+		assert.Contains(t, err.Error(), "supported formats are: png, jpeg, webp, heic, heif, gif")
+		// A file that isn't an image isn't sent, even with a --format that can't be checked.
+		textPath := filepath.Join(t.TempDir(), "notes.heic")
+		require.NoError(t, os.WriteFile(textPath, []byte("shopping list: milk, eggs"), 0644))
+		_, err = executeCommand(t, rootCmd, "image", "describe", "--path", textPath, "--format", "heic", "--stream=false")
+		assert.ErrorIs(t, err, ErrInvalidInput)
+		assert.Contains(t, err.Error(), "looks like text/plain")
+		assert.Nil(t, provider.contents)
+	})
+}
+
+// TestMultipleImages verifies that repeated and globbed --path values send every image, labelled in order, in a
+// single request.
+func TestMultipleImages(t *testing.T) {
+	provider := &fakeProvider{chunks: []string{"answer"}}
+	useFakeProvider(t, provider)
+	originalLimit := maxImagesSize
+	defer func() {
+		maxImagesSize = originalLimit
+		imageInput = nil
+		rootCmd.SetIn(nil)
+	}()
+	imageFileFormat = ""
+
+	dir := t.TempDir()
+	pngData := []byte("\x89PNG\r\n\x1a\n rest of the image")
+	for _, name := range []string{"before.png", "after.png", "diagram-1.png", "diagram-2.png"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), pngData, 0644))
+	}
+
+	t.Run("repeated_path", func(t *testing.T) {
+		_, err := executeCommand(t, rootCmd, "image", "what changed?", "--path", filepath.Join(dir, "before.png"), "-p", filepath.Join(dir, "after.png"), "--stream=false")
+		require.NoError(t, err)
+		require.Len(t, provider.contents, 1)
+		parts := provider.contents[0].Parts
+		require.Len(t, parts, 5)
+		assert.Equal(t, "Image 1: before.png", parts[0].Text)
+		assert.Equal(t, "image/png", parts[1].InlineData.MIMEType)
+		assert.Equal(t, "Image 2: after.png", parts[2].Text)
+		assert.Equal(t, "image/png", parts[3].InlineData.MIMEType)
+		assert.Equal(t, "what changed? in english language", parts[4].Text)
+	})
+
+	t.Run("glob", func(t *testing.T) {
+		// Globs are expanded in lexical order, and can be mixed with the image from stdin.
+		rootCmd.SetIn(bytes.NewReader(pngData))
+		_, err := executeCommand(t, rootCmd, "image", "which one matches?", "--path", filepath.Join(dir, "diagram-*.png"), "--path", "-", "--stream=false")
+		require.NoError(t, err)
+		parts := provider.contents[0].Parts
+		require.Len(t, parts, 7)
+		assert.Equal(t, "Image 1: diagram-1.png", parts[0].Text)
+		assert.Equal(t, "Image 2: diagram-2.png", parts[2].Text)
+		assert.Equal(t, "Image 3: stdin", parts[4].Text)
+		rootCmd.SetIn(nil)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		provider.contents = nil
+		_, err := executeCommand(t, rootCmd, "image", "compare", "--path", filepath.Join(dir, "*.jpg"), "--stream=false")
+		assert.ErrorIs(t, err, ErrInvalidInput)
+		assert.Contains(t, err.Error(), "no image matches")
+
+		_, err = executeCommand(t, rootCmd, "image", "compare", "--path", "-", "--path", "-", "--stream=false")
+		assert.ErrorIs(t, err, ErrInvalidInput)
+
+		// The images must fit in a single request.
+		maxImagesSize = int64(len(pngData)) + 1
+		_, err = executeCommand(t, rootCmd, "image", "compare", "--path", filepath.Join(dir, "*.png"), "--stream=false")
+		assert.ErrorIs(t, err, ErrInvalidInput)
+		assert.Nil(t, provider.contents)
+	})
+}
+
+// TestImagePreprocessing verifies that --max-dimension and --quality shrink and re-encode the images before they
+// are sent, and that --verbose reports their sizes.
+func TestImagePreprocessing(t *testing.T) {
+	provider := &fakeProvider{chunks: []string{"answer"}}
+	useFakeProvider(t, provider)
+	defer func() { imageMaxDimension, imageQuality, imageFileFormat = 0, 0, "" }()
+	imageFileFormat = ""
+
+	// encode returns an image of the given size, filled with noise so that it doesn't compress well.
+	encode := func(width, height int, format string) []byte {
+		img := image.NewRGBA(image.Rect(0, 0, width, height))
+		random := rand.New(rand.NewPCG(1, 2))
+		for i := range img.Pix {
+			img.Pix[i] = byte(random.UintN(256))
+			if i%4 == 3 {
+				img.Pix[i] = 0xFF // Opaque.
+			}
+		}
+		var buf bytes.Buffer
+		if format == "png" {
+			require.NoError(t, png.Encode(&buf, img))
+		} else {
+			require.NoError(t, jpeg.Encode(&buf, img, nil))
+		}
+		return buf.Bytes()
+	}
+	decode := func(data []byte) image.Config {
+		config, _, err := image.DecodeConfig(bytes.NewReader(data))
+		require.NoError(t, err)
+		return config
+	}
+	dir := t.TempDir()
+	screenshot := filepath.Join(dir, "screenshot.png")
+	require.NoError(t, os.WriteFile(screenshot, encode(400, 200, "png"), 0644))
+
+	t.Run("max_dimension", func(t *testing.T) {
+		// The image keeps its aspect ratio and, being a PNG, stays lossless.
+		_, err := executeCommand(t, rootCmd, "image", "describe", "--path", screenshot, "--max-dimension", "100", "--stream=false")
+		require.NoError(t, err)
+		data := provider.contents[0].Parts[0].InlineData
+		assert.Equal(t, "image/png", data.MIMEType)
+		config := decode(data.Data)
+		assert.Equal(t, []int{100, 50}, []int{config.Width, config.Height})
+		imageMaxDimension = 0
+	})
+
+	t.Run("quality", func(t *testing.T) {
+		_, err := executeCommand(t, rootCmd, "image", "describe", "--path", screenshot, "--quality", "50", "--stream=false")
+		require.NoError(t, err)
+		data := provider.contents[0].Parts[0].InlineData
+		assert.Equal(t, "image/jpeg", data.MIMEType)
+		assert.Equal(t, 400, decode(data.Data).Width)
+		imageQuality = 0
+
+		_, err = executeCommand(t, rootCmd, "image", "describe", "--path", screenshot, "--quality", "101", "--stream=false")
+		assert.ErrorIs(t, err, ErrInvalidInput)
+		imageQuality = 0
+	})
+
+	t.Run("orientation", func(t *testing.T) {
+		// A JPEG whose EXIF data says it must be rotated 90° is sent upright, as it would be displayed.
+		photo := encode(40, 20, "jpeg")
+		exif := []byte("Exif\x00\x00MM\x00\x2a\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x06\x00\x00\x00\x00\x00\x00")
+		segment := append([]byte{0xFF, 0xE1, 0x00, byte(len(exif) + 2)}, exif...)
+		photo = append(append(photo[:2:2], segment...), photo[2:]...)
+		assert.Equal(t, 6, exifOrientation(photo))
+
+		img, err := prepareImage(imageFile{name: "photo.jpg", data: photo, mimeType: "image/jpeg"}, 10, 0, io.Discard)
+		require.NoError(t, err)
+		config := decode(img.data)
+		assert.Equal(t, []int{5, 10}, []int{config.Width, config.Height})
+	})
+
+	t.Run("verbose", func(t *testing.T) {
+		var log bytes.Buffer
+		data := encode(400, 200, "png")
+		img, err := prepareImage(imageFile{name: "screenshot.png", data: data, mimeType: "image/png"}, 100, 0, &log)
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("screenshot.png: 400x200 %s, sent as 100x50 %s (png)\n", formatSize(int64(len(data))), formatSize(int64(len(img.data)))), log.String())
+
+		// Images that are already small enough, or that can't be decoded, are sent as they are.
+		log.Reset()
+		img, err = prepareImage(imageFile{name: "screenshot.png", data: data, mimeType: "image/png"}, 1000, 0, &log)
+		require.NoError(t, err)
+		assert.Equal(t, data, img.data)
+		assert.Contains(t, log.String(), "sent as is")
+
+		log.Reset()
+		heic := []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00")
+		img, err = prepareImage(imageFile{name: "photo.heic", data: heic, mimeType: "image/heic"}, 100, 0, &log)
+		require.NoError(t, err)
+		assert.Equal(t, heic, img.data)
+		assert.Contains(t, log.String(), "heic images can't be re-encoded")
+
+		// An animated GIF would lose all its frames but the first, so it isn't resized or re-encoded.
+		log.Reset()
+		var animation bytes.Buffer
+		frame := image.NewPaletted(image.Rect(0, 0, 400, 200), color.Palette{color.Black, color.White})
+		require.NoError(t, gif.EncodeAll(&animation, &gif.GIF{Image: []*image.Paletted{frame, frame}, Delay: []int{10, 10}}))
+		img, err = prepareImage(imageFile{name: "animation.gif", data: animation.Bytes(), mimeType: "image/gif"}, 100, 50, &log)
+		require.NoError(t, err)
+		assert.Equal(t, "image/gif", img.mimeType)
+		assert.Equal(t, animation.Bytes(), img.data)
+		assert.Contains(t, log.String(), "animation.gif: 400x200, gif images are sent as is to keep their animation")
+	})
+}
+
+// TestImageMetadata verifies that the metadata of JPEG and PNG images, such as their GPS location, is removed before
+// they are sent, unless --strip-metadata=false is given.
+func TestImageMetadata(t *testing.T) {
+	provider := &fakeProvider{chunks: []string{"answer"}}
+	useFakeProvider(t, provider)
+	defer func() { imageStripMetadata, imageFileFormat = true, "" }()
+	imageFileFormat = ""
+
+	var encoded bytes.Buffer
+	require.NoError(t, jpeg.Encode(&encoded, image.NewGray(image.Rect(0, 0, 8, 4)), nil))
+	// The EXIF data holds the camera make, the orientation and a GPS location, followed by XMP data and a comment.
+	exif := "Exif\x00\x00MM\x00\x2a\x00\x00\x00\x08\x00\x03" +
+		"\x01\x0f\x00\x02\x00\x00\x00\x04Cam\x00" +
+		"\x01\x12\x00\x03\x00\x00\x00\x01\x00\x06\x00\x00" +
+		"\x88\x25\x00\x04\x00\x00\x00\x01\x00\x00\x00\x00" +
+		"\x00\x00\x00\x00"
+	segment := func(marker byte, payload string) []byte {
+		return append([]byte{0xFF, marker, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)}, payload...)
+	}
+	photo := slices.Concat(encoded.Bytes()[:2], segment(0xE1, exif), segment(0xE1, "http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>"), segment(0xFE, "taken at home"), encoded.Bytes()[2:])
+
+	t.Run("jpeg", func(t *testing.T) {
+		img, removed, ok := stripImageMetadata(imageFile{name: "photo.jpg", data: photo, mimeType: "image/jpeg"})
+		require.True(t, ok)
+		assert.Equal(t, []string{"EXIF (GPS location, camera make and model)", "XMP", "comment"}, removed)
+		// Only the orientation is kept, and the image can still be decoded.
+		assert.Equal(t, 6, exifOrientation(img.data))
+		assert.Empty(t, describeExif(jpegExif(img.data)))
+		assert.NotContains(t, string(img.data), "taken at home")
+		_, err := jpeg.Decode(bytes.NewReader(img.data))
+		assert.NoError(t, err)
+	})
+
+	t.Run("png", func(t *testing.T) {
+		var encoded bytes.Buffer
+		require.NoError(t, png.Encode(&encoded, image.NewGray(image.Rect(0, 0, 8, 4))))
+		chunk := func(chunkType, data string) []byte {
+			header := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+			return binary.BigEndian.AppendUint32(slices.Concat(header, []byte(chunkType+data)), crc32.ChecksumIEEE([]byte(chunkType+data)))
+		}
+		// The metadata chunks are inserted after the IHDR chunk, which comes first.
+		data := encoded.Bytes()
+		ihdrEnd := len(pngSignature) + 25
+		screenshot := slices.Concat(data[:ihdrEnd], chunk("tEXt", "Author\x00Jane"), chunk("eXIf", exif[6:]), data[ihdrEnd:])
+		_, err := png.Decode(bytes.NewReader(screenshot))
+		require.NoError(t, err)
+
+		img, removed, ok := stripImageMetadata(imageFile{name: "screenshot.png", data: screenshot, mimeType: "image/png"})
+		require.True(t, ok)
+		assert.Equal(t, []string{"text (Author)", "EXIF"}, removed)
+		assert.Equal(t, data, img.data)
+
+		// Images without metadata are left as they are.
+		img, removed, ok = stripImageMetadata(imageFile{name: "screenshot.png", data: data, mimeType: "image/png"})
+		assert.True(t, ok)
+		assert.Empty(t, removed)
+		assert.Equal(t, data, img.data)
+
+		// Metadata can't be removed from other formats.
+		_, _, ok = stripImageMetadata(imageFile{name: "photo.heic", data: []byte("heic"), mimeType: "image/heic"})
+		assert.False(t, ok)
+	})
+
+	t.Run("command", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "photo.jpg")
+		require.NoError(t, os.WriteFile(path, photo, 0644))
+
+		_, err := executeCommand(t, rootCmd, "image", "where was this taken?", "--path", path, "--stream=false")
+		require.NoError(t, err)
+		assert.Empty(t, describeExif(jpegExif(provider.contents[0].Parts[0].InlineData.Data)))
+
+		_, err = executeCommand(t, rootCmd, "image", "where was this taken?", "--path", path, "--strip-metadata=false", "--stream=false")
+		require.NoError(t, err)
+		assert.Equal(t, photo, provider.contents[0].Parts[0].InlineData.Data)
+	})
+
+	t.Run("unsupported_format", func(t *testing.T) {
+		// The metadata of the formats stripImageMetadata doesn't understand is reported to the log.
+		// --strip-metadata keeps the value given by the previous test.
+		imageStripMetadata = true
+		dir := t.TempDir()
+		heic := filepath.Join(dir, "photo.heic")
+		require.NoError(t, os.WriteFile(heic, []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00"), 0644))
+		var log bytes.Buffer
+		_, err := readImages([]string{heic}, "", &log)
+		require.NoError(t, err)
+		assert.Contains(t, log.String(), "Warning: metadata can't be removed from heic images, photo.heic is sent with its metadata\n")
+
+		jpg := filepath.Join(dir, "photo.jpg")
+		require.NoError(t, os.WriteFile(jpg, photo, 0644))
+		log.Reset()
+		_, err = readImages([]string{jpg}, "", &log)
+		require.NoError(t, err)
+		assert.NotContains(t, log.String(), "Warning")
+	})
+
+	t.Run("mpf", func(t *testing.T) {
+		// Phones store depth maps and previews as secondary images after the end of the first, with their own EXIF.
+		mpf := slices.Concat(photo[:2], segment(0xE2, "MPF\x00MM\x00\x2a\x00\x00\x00\x08"), photo[2:], photo)
+		img, removed, ok := stripImageMetadata(imageFile{name: "photo.jpg", data: mpf, mimeType: "image/jpeg"})
+		require.True(t, ok)
+		assert.Contains(t, removed, "MPF")
+		assert.Contains(t, removed, "data after the end of the image")
+		assert.NotContains(t, string(img.data), "taken at home")
+		assert.NotContains(t, string(img.data), "Cam\x00")
+		_, err := jpeg.Decode(bytes.NewReader(img.data))
+		assert.NoError(t, err)
+
+		// The image data can hold stuffed 0xFF bytes, which don't end the image.
+		assert.Equal(t, len(encoded.Bytes()), jpegEnd(slices.Concat(encoded.Bytes(), []byte("trailing")), 2))
+	})
+
+	t.Run("attachments", func(t *testing.T) {
+		originalLimit := maxInlineAttachmentSize
+		defer func() { maxInlineAttachmentSize = originalLimit }()
+		// --strip-metadata keeps the value given by the previous test.
+		imageStripMetadata = true
+		// The extension of an attachment doesn't matter, the metadata is found from the content.
+		path := filepath.Join(t.TempDir(), "photo.png")
+		require.NoError(t, os.WriteFile(path, photo, 0644))
+
+		_, err := executeCommand(t, rootCmd, "search", "where was this taken?", "--attach", path, "--stream=false")
+		require.NoError(t, err)
+		assert.Empty(t, describeExif(jpegExif(provider.contents[0].Parts[0].InlineData.Data)))
+
+		// Large images are uploaded from a stripped copy with the same name.
+		maxInlineAttachmentSize = 8
+		provider.uploaded, provider.uploadedData = nil, nil
+		_, err = executeCommand(t, rootCmd, "search", "where was this taken?", "--attach", path, "--stream=false")
+		require.NoError(t, err)
+		require.Len(t, provider.uploadedData, 1)
+		assert.Equal(t, "photo.png", filepath.Base(provider.uploaded[0]))
+		assert.NotEqual(t, path, provider.uploaded[0])
+		assert.Empty(t, describeExif(jpegExif(provider.uploadedData[0])))
+		assert.NoFileExists(t, provider.uploaded[0], "the copy is removed once uploaded")
+
+		maxInlineAttachmentSize = originalLimit
+		_, err = executeCommand(t, rootCmd, "search", "where was this taken?", "--attach", path, "--strip-metadata=false", "--stream=false")
+		require.NoError(t, err)
+		assert.Equal(t, photo, provider.contents[0].Parts[0].InlineData.Data)
+	})
+}
+
+// TestImageBatch verifies that 'image batch' answers about every image of a directory, keeps going when an image
+// fails, and skips the images answered by a previous run.
+func TestImageBatch(t *testing.T) {
+	provider := &fakeProvider{chunks: []string{" A red shoe. "}}
+	useFakeProvider(t, provider)
+	defer func() { batchOutputFormat, batchOutputFile, batchConcurrency = "jsonl", "", defaultBatchConcurrency }()
+
+	var pngData, jpegData bytes.Buffer
+	require.NoError(t, png.Encode(&pngData, image.NewGray(image.Rect(0, 0, 4, 4))))
+	require.NoError(t, jpeg.Encode(&jpegData, image.NewGray(image.Rect(0, 0, 4, 4)), nil))
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "sub"), 0755))
+	files := map[string][]byte{
+		"a.png":      pngData.Bytes(),
+		"sub/b.JPG":  jpegData.Bytes(),
+		"broken.png": []byte("not an image"),
+		"notes.md":   []byte("not an image either, and skipped"),
+	}
+	for name, data := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), data, 0644))
+	}
+	readLines := func(path string) []string {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		return strings.Split(strings.TrimSpace(string(data)), "\n")
+	}
+
+	t.Run("jsonl_and_resume", func(t *testing.T) {
+		// The broken image is reported without stopping the others.
+		output, err := executeCommand(t, rootCmd, "image", "batch", dir, "--prompt", "Write alt text", "--concurrency", "2")
+		require.ErrorIs(t, err, ErrInvalidInput)
+		assert.Contains(t, err.Error(), "1 of 3 images failed")
+		assert.Contains(t, output, "Answered 2 images, skipped 0 already answered, 1 failed")
+		lines := readLines(filepath.Join(dir, "captions.jsonl"))
+		slices.Sort(lines)
+		assert.Equal(t, []string{`{"file":"a.png","caption":"A red shoe."}`, `{"file":"sub/b.JPG","caption":"A red shoe."}`}, lines)
+		assert.Equal(t, "Write alt text in english language", provider.contents[0].Parts[1].Text)
+
+		// Once fixed, only the broken image is sent again.
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "broken.png"), pngData.Bytes(), 0644))
+		output, err = executeCommand(t, rootCmd, "image", "batch", dir, "--prompt", "Write alt text")
+		require.NoError(t, err)
+		assert.Contains(t, output, "Answered 1 images, skipped 2 already answered, 0 failed")
+		lines = readLines(filepath.Join(dir, "captions.jsonl"))
+		assert.Len(t, lines, 3)
+		assert.Equal(t, `{"file":"broken.png","caption":"A red shoe."}`, lines[2])
+
+		output, err = executeCommand(t, rootCmd, "image", "batch", dir, "--prompt", "Write alt text")
+		require.NoError(t, err)
+		assert.Contains(t, output, "All 3 images already have an answer")
+	})
+
+	t.Run("resume_truncated", func(t *testing.T) {
+		originalStderr := os.Stderr
+		defer func() { os.Stderr = originalStderr }()
+		stderr, err := os.CreateTemp(t.TempDir(), "stderr")
+		require.NoError(t, err)
+		defer stderr.Close()
+		os.Stderr = stderr
+		defer func() { batchOutputFile, batchConcurrency = "", defaultBatchConcurrency }()
+
+		// A run killed while writing leaves an incomplete last line, which is removed and its image answered again.
+		path := filepath.Join(t.TempDir(), "alt.jsonl")
+		require.NoError(t, os.WriteFile(path, []byte(`{"file":"a.png","caption":"A red shoe."}`+"\n"+`{"file":"sub/b.JPG","capt`), 0644))
+		output, err := executeCommand(t, rootCmd, "image", "batch", dir, "--prompt", "Write alt text", "--output", path, "--concurrency", "1")
+		require.NoError(t, err)
+		assert.Contains(t, output, "Answered 2 images, skipped 1 already answered, 0 failed")
+		warnings, err := os.ReadFile(stderr.Name())
+		require.NoError(t, err)
+		assert.Contains(t, string(warnings), "Warning: the last line of "+path+" is incomplete")
+		lines := readLines(path)
+		require.Len(t, lines, 3)
+		assert.Equal(t, `{"file":"a.png","caption":"A red shoe."}`, lines[0])
+		assert.ElementsMatch(t, []string{`{"file":"broken.png","caption":"A red shoe."}`, `{"file":"sub/b.JPG","caption":"A red shoe."}`}, lines[1:])
+
+		output, err = executeCommand(t, rootCmd, "image", "batch", dir, "--prompt", "Write alt text", "--output", path)
+		require.NoError(t, err)
+		assert.Contains(t, output, "All 3 images already have an answer")
+
+		// A line that can't be decoded before the last one isn't a run that was killed, so it is reported.
+		require.NoError(t, os.WriteFile(path, []byte("not json\n"+`{"file":"a.png","caption":"A red shoe."}`+"\n"), 0644))
+		_, err = executeCommand(t, rootCmd, "image", "batch", dir, "--prompt", "Write alt text", "--output", path)
+		assert.ErrorIs(t, err, ErrInvalidInput)
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "alt.csv")
+		_, err := executeCommand(t, rootCmd, "image", "batch", dir, "--prompt", "Write alt text", "--output-format", "csv", "--output", path)
+		require.NoError(t, err)
+		lines := readLines(path)
+		require.Len(t, lines, 4)
+		assert.Equal(t, "file,caption", lines[0])
+		assert.Contains(t, lines, "sub/b.JPG,A red shoe.")
+		batchOutputFile = ""
+	})
+
+	t.Run("txt", func(t *testing.T) {
+		_, err := executeCommand(t, rootCmd, "image", "batch", dir, "--prompt", "Write alt text", "--output-format", "txt")
+		require.NoError(t, err)
+		caption, err := os.ReadFile(filepath.Join(dir, "sub", "b.JPG.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "A red shoe.\n", string(caption))
+
+		// Images with the same name get their own file, and an unrelated .txt file isn't taken for an answer.
+		photos := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(photos, "photo.png"), pngData.Bytes(), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(photos, "photo.jpg"), jpegData.Bytes(), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(photos, "photo.txt"), []byte("notes about the shoot\n"), 0644))
+		output, err := executeCommand(t, rootCmd, "image", "batch", photos, "--prompt", "Write alt text", "--output-format", "txt")
+		require.NoError(t, err)
+		assert.Contains(t, output, "Answered 2 images")
+		for _, name := range []string{"photo.png.txt", "photo.jpg.txt"} {
+			assert.FileExists(t, filepath.Join(photos, name))
+		}
+		notes, err := os.ReadFile(filepath.Join(photos, "photo.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "notes about the shoot\n", string(notes))
+		batchOutputFormat = "jsonl"
+	})
+
+	t.Run("invalid_input", func(t *testing.T) {
+		batchPrompt = ""
+		testCases := [][]string{
+			{"image", "batch", dir},
+			{"image", "batch", dir, "--prompt", "alt", "--concurrency", "0"},
+			{"image", "batch", dir, "--prompt", "alt", "--output-format", "xml"},
+			{"image", "batch", filepath.Join(dir, "a.png"), "--prompt", "alt"},
+			{"image", "batch", filepath.Join(dir, "sub", "missing"), "--prompt", "alt"},
+		}
+		for _, args := range testCases {
+			_, err := executeCommand(t, rootCmd, args...)
+			assert.ErrorIs(t, err, ErrInvalidInput, args)
+			batchPrompt, batchConcurrency, batchOutputFormat = "", defaultBatchConcurrency, "jsonl"
+		}
+	})
+}
+
+// TestImageDetect verifies that 'image detect' asks for boxes matching detectionSchema, prints them in the pixels of
+// the image, and draws them with --annotate.
+func TestImageDetect(t *testing.T) {
+	// The response is wrapped in a code fence and holds an empty box, which is dropped.
+	provider := &fakeProvider{chunks: []string{"```json\n" + `[{"label":"plate","box_2d":[100,250,500,750]},{"label":"empty","box_2d":[500,500,400,600]}]` + "\n```"}}
+	useFakeProvider(t, provider)
+	defer func() { detectImagePath, detectOutputFormat, detectAnnotateFile = "", "table", "" }()
+	imageFileFormat = ""
+
+	canvas := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	draw.Draw(canvas, canvas.Bounds(), image.White, image.Point{}, draw.Src)
+	var encoded bytes.Buffer
+	require.NoError(t, png.Encode(&encoded, canvas))
+	dir := t.TempDir()
+	car := filepath.Join(dir, "car.png")
+	require.NoError(t, os.WriteFile(car, encoded.Bytes(), 0644))
+
+	t.Run("table_and_annotate", func(t *testing.T) {
+		annotated := filepath.Join(dir, "out", "car-boxes.png")
+		output, err := executeCommand(t, rootCmd, "image", "detect", "find", "license", "plates", "-p", car, "--annotate", annotated)
+		require.NoError(t, err)
+		assert.Equal(t, "LABEL  X_MIN  Y_MIN  X_MAX  Y_MAX  BOX_2D\nplate  50     10     150    50     [100, 250, 500, 750]\n", output)
+
+		assert.Equal(t, "application/json", provider.config.ResponseMIMEType)
+		assert.Equal(t, detectionSchema, provider.config.ResponseJsonSchema)
+		assert.Contains(t, provider.contents[0].Parts[1].Text, "find license plates")
+
+		// The box is drawn in the first color, over the left edge of the plate.
+		data, err := os.ReadFile(annotated)
+		require.NoError(t, err)
+		img, err := png.Decode(bytes.NewReader(data))
+		require.NoError(t, err)
+		assert.Equal(t, image.Rect(0, 0, 200, 100), img.Bounds())
+		assert.Equal(t, color.RGBAModel.Convert(boxColors[0]), color.RGBAModel.Convert(img.At(50, 30)))
+		assert.Equal(t, color.RGBAModel.Convert(color.White), color.RGBAModel.Convert(img.At(100, 30)))
+		detectAnnotateFile = ""
+	})
+
+	t.Run("json", func(t *testing.T) {
+		output, err := executeCommand(t, rootCmd, "image", "detect", "plates", "-p", car, "--output-format", "json")
+		require.NoError(t, err)
+		var detections []detection
+		require.NoError(t, json.Unmarshal([]byte(output), &detections))
+		assert.Equal(t, []detection{{Label: "plate", Box: [4]int{100, 250, 500, 750}, Pixels: &pixelBox{XMin: 50, YMin: 10, XMax: 150, YMax: 50}}}, detections)
+		detectOutputFormat = "table"
+	})
+
+	t.Run("orientation", func(t *testing.T) {
+		// A JPEG whose EXIF data says it must be rotated 90° is sent upright, like the image the boxes are mapped onto.
+		var sideways bytes.Buffer
+		require.NoError(t, jpeg.Encode(&sideways, image.NewGray(image.Rect(0, 0, 200, 100)), nil))
+		exif := []byte("Exif\x00\x00MM\x00\x2a\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x06\x00\x00\x00\x00\x00\x00")
+		segment := append([]byte{0xFF, 0xE1, 0x00, byte(len(exif) + 2)}, exif...)
+		photo := filepath.Join(dir, "photo.jpg")
+		require.NoError(t, os.WriteFile(photo, slices.Concat(sideways.Bytes()[:2], segment, sideways.Bytes()[2:]), 0644))
+
+		output, err := executeCommand(t, rootCmd, "image", "detect", "plates", "-p", photo, "--output-format", "json")
+		require.NoError(t, err)
+		sent := provider.contents[0].Parts[0].InlineData.Data
+		config, _, err := image.DecodeConfig(bytes.NewReader(sent))
+		require.NoError(t, err)
+		assert.Equal(t, []int{100, 200}, []int{config.Width, config.Height})
+		assert.Equal(t, 1, exifOrientation(sent))
+		assert.Contains(t, output, `"x_max": 75`)
+		assert.Contains(t, output, `"y_max": 100`)
+		detectOutputFormat = "table"
+	})
+
+	t.Run("annotate_undecodable", func(t *testing.T) {
+		// HEIC images can be sent but not decoded, so --annotate fails before the request.
+		heic := filepath.Join(dir, "car.heic")
+		require.NoError(t, os.WriteFile(heic, []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00"), 0644))
+		provider.contents = nil
+		_, err := executeCommand(t, rootCmd, "image", "detect", "plates", "-p", heic, "--annotate", filepath.Join(dir, "heic-boxes.png"))
+		assert.ErrorIs(t, err, ErrInvalidInput)
+		assert.ErrorContains(t, err, "the image can't be annotated")
+		assert.Nil(t, provider.contents)
+		detectAnnotateFile = ""
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		provider.chunks = []string{"I can't see any plates."}
+		_, err := executeCommand(t, rootCmd, "image", "detect", "plates", "-p", car)
+		assert.ErrorIs(t, err, ErrAPI)
+
+		_, err = executeCommand(t, rootCmd, "image", "detect", "plates", "-p", car, "--output-format", "yaml")
+		assert.ErrorIs(t, err, ErrInvalidInput)
+		detectOutputFormat, detectImagePath = "table", ""
+
+		_, err = executeCommand(t, rootCmd, "image", "detect", "plates")
+		assert.ErrorIs(t, err, ErrInvalidInput)
+	})
+}
+
+// TestImageExtract tests the 'image extract' subcommand, which turns the table in an image into a Markdown table, CSV
+// or JSON, and checks the response against the default table schema or a user JSON Schema.
+func TestImageExtract(t *testing.T) {
+	table := `{"columns":["item","price"],"rows":[["Coffee","3.50"],["Tea | large","2.00"]]}`
+	provider := &fakeProvider{chunks: []string{"```json\n" + table + "\n```"}}
+	useFakeProvider(t, provider)
+	defer func() { extractImagePath, extractSchemaFile, extractOutputFormat = "", "", "markdown" }()
+	imageFileFormat = ""
+
+	dir := t.TempDir()
+	receipt := filepath.Join(dir, "receipt.png")
+	require.NoError(t, os.WriteFile(receipt, []byte(pngSignature+"fake"), 0644))
+
+	t.Run("default_table", func(t *testing.T) {
+		tests := []struct {
+			format string
+			want   string
+		}{
+			{"markdown", "| item | price |\n| --- | --- |\n| Coffee | 3.50 |\n| Tea \\| large | 2.00 |\n"},
+			{"csv", "item,price\nCoffee,3.50\nTea | large,2.00\n"},
+			{"json", "{\n  \"columns\": [\n    \"item\",\n    \"price\"\n  ],\n  \"rows\": [\n    [\n      \"Coffee\",\n      \"3.50\"\n    ],\n    [\n      \"Tea | large\",\n      \"2.00\"\n    ]\n  ]\n}\n"},
+		}
+		for _, tt := range tests {
+			output, err := executeCommand(t, rootCmd, "image", "extract", "-p", receipt, "--output-format", tt.format)
+			require.NoError(t, err, tt.format)
+			assert.Equal(t, tt.want, output, tt.format)
+		}
+		extractOutputFormat = "markdown"
+
+		assert.Equal(t, "application/json", provider.config.ResponseMIMEType)
+		assert.Equal(t, []any{"columns", "rows"}, provider.config.ResponseJsonSchema.(map[string]any)["required"])
+		assert.Contains(t, provider.contents[0].Parts[1].Text, defaultExtractInstructions)
+	})
+
+	schemaFile := filepath.Join(dir, "receipt.schema.json")
+	require.NoError(t, os.WriteFile(schemaFile, []byte(`{
+		"type": "array",
+		"items": {
+			"type": "object",
+			"properties": {"item": {"type": "string"}, "price": {"type": "number"}, "tags": {"type": "array"}},
+			"required": ["item", "price"]
+		}
+	}`), 0644))
+
+	t.Run("user_schema", func(t *testing.T) {
+		// An array of objects becomes a row per object, with the columns in the order of their fields.
+		provider.chunks = []string{`[{"item":"Coffee","price":3.5,"tags":["hot"]},{"item":"Tea","price":2,"note":null}]`}
+		output, err := executeCommand(t, rootCmd, "image", "extract", "the", "items", "-p", receipt, "--schema", schemaFile, "--output-format", "csv")
+		require.NoError(t, err)
+		assert.Equal(t, "item,price,tags,note\nCoffee,3.5,\"[\"\"hot\"\"]\",\nTea,2,,\n", output)
+		assert.Equal(t, "array", provider.config.ResponseJsonSchema.(map[string]any)["type"])
+		assert.Contains(t, provider.contents[0].Parts[1].Text, "the items")
+
+		// A response that doesn't match the schema isn't printed.
+		provider.chunks = []string{`[{"item":"Coffee","price":"3.50"}]`}
+		output, err = executeCommand(t, rootCmd, "image", "extract", "-p", receipt, "--schema", schemaFile)
+		assert.ErrorIs(t, err, ErrAPI)
+		assert.ErrorContains(t, err, "doesn't match the schema")
+		assert.Empty(t, output)
+	})
+
+	t.Run("object_fields", func(t *testing.T) {
+		columns, rows, err := tabulate([]byte(`{"store":"Cafe","total":5.5,"paid":true}`))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"field", "value"}, columns)
+		assert.Equal(t, [][]string{{"store", "Cafe"}, {"total", "5.5"}, {"paid", "true"}}, rows)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		invalidSchema := filepath.Join(dir, "invalid.schema.json")
+		require.NoError(t, os.WriteFile(invalidSchema, []byte(`{"type": "table"}`), 0644))
+		tests := [][]string{
+			{"-p", receipt, "--schema", invalidSchema},
+			{"-p", receipt, "--schema", filepath.Join(dir, "missing.json")},
+			{"-p", receipt, "--output-format", "yaml"},
+			{},
+		}
+		for _, args := range tests {
+			_, err := executeCommand(t, rootCmd, append([]string{"image", "extract"}, args...)...)
+			assert.ErrorIs(t, err, ErrInvalidInput, args)
+			extractImagePath, extractSchemaFile, extractOutputFormat = "", "", "markdown"
+		}
+
+		provider.chunks = []string{"The receipt is too blurry."}
+		_, err := executeCommand(t, rootCmd, "image", "extract", "-p", receipt)
+		assert.ErrorIs(t, err, ErrAPI)
+	})
+}
+
+// TestChatCommand tests the 'chat' subcommand which runs an interactive session.
+// It feeds the session from an in-memory stdin and verifies that history and slash-commands behave as expected.
+func TestChatCommand(t *testing.T) {
+	// Backup the original functions to allow restoration later.
+	originalFunc := getChatResponseFunc
+	originalGetConfigFunc := GetConfigFunc
+	defer func() {
+		getChatResponseFunc = originalFunc
+		GetConfigFunc = originalGetConfigFunc
+		rootCmd.SetIn(nil)
+	}()
+
+	GetConfigFunc = func(key string) string {
+		if key == "genai_model" {
+			return "gemini-2.5-pro"
+		}
+		return ""
+	}
+
+	// Record the model and the number of turns sent with every request.
+	var models []string
+	var historyLens []int
+	getChatResponseFunc = func(session *chatSession) (string, error) {
+		models = append(models, session.model)
+		historyLens = append(historyLens, len(session.history))
+		return "reply " + session.history[len(session.history)-1].Parts[0].Text, nil
+	}
+
+	transcript := filepath.Join(t.TempDir(), "chat.txt")
+
+	testCases := []struct {
+		name           string   // Name of the test case.
+		input          string   // Lines typed by the user.
+		expectedOutput []string // Substrings expected in the output.
+		expectedModels []string // Model used for each request.
+		expectedLens   []int    // Number of turns sent with each request.
+	}{
+		{
+			name:           "follow_up_keeps_history",
+			input:          "first question\nfollow up\n/exit\n",
+			expectedOutput: []string{"reply first question", "reply follow up"},
+			expectedModels: []string{"gemini-2.5-pro", "gemini-2.5-pro"},
+			expectedLens:   []int{1, 3},
+		},
+		{
+			name:           "clear_resets_history",
+			input:          "first question\n/clear\nnew topic\n",
+			expectedOutput: []string{"Conversation cleared.", "reply new topic"},
+			expectedModels: []string{"gemini-2.5-pro", "gemini-2.5-pro"},
+			expectedLens:   []int{1, 1},
+		},
+		{
+			name:           "model_switch",
+			input:          "/model gemini-2.5-flash\nhello\n/model\n",
+			expectedOutput: []string{"Model for this session changed to: gemini-2.5-flash", "Current model: gemini-2.5-flash"},
+			expectedModels: []string{"gemini-2.5-flash"},
+			expectedLens:   []int{1},
+		},
+		{
+			name:           "save_transcript",
+			input:          "hello\n/save " + transcript + "\n",
+			expectedOutput: []string{"Conversation saved to: " + transcript},
+			expectedModels: []string{"gemini-2.5-pro"},
+			expectedLens:   []int{1},
+		},
+		{
+			name:           "unknown_command",
+			input:          "/nope\n",
+			expectedOutput: []string{"Unknown command \"/nope\""},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			models, historyLens = nil, nil
+			rootCmd.SetIn(strings.NewReader(tc.input))
+
+			output, err := executeCommand(t, rootCmd, "chat")
+			require.NoError(t, err)
+
+			for _, expected := range tc.expectedOutput {
+				assert.Contains(t, output, expected)
+			}
+			assert.Equal(t, tc.expectedModels, models)
+			assert.Equal(t, tc.expectedLens, historyLens)
+		})
+	}
+
+	t.Run("saved_transcript_contents", func(t *testing.T) {
+		data, err := os.ReadFile(transcript)
+		require.NoError(t, err)
+		assert.Equal(t, "You: hello\n\nModel: reply hello\n\n", string(data))
+	})
+}
+
+// TestModelCommand tests the 'model' subcommand which allows the user to change the current model.
+// It simulates user selection using the survey package and verifies that the configuration is updated.
+func TestModelCommand(t *testing.T) {
+	// Backup the original functions to allow restoration later.
+	originalSurveyAskOne := surveyAskOne
+	originalGetConfigFunc := GetConfigFunc
+	originalUpdateConfigFunc := UpdateConfigFunc
+
+	// Restore the original functions after tests.
+	defer func() {
+		surveyAskOne = originalSurveyAskOne
+		GetConfigFunc = originalGetConfigFunc
+		UpdateConfigFunc = originalUpdateConfigFunc
+	}()
+
+	// Setup an in-memory configuration map for testing purposes.
+	testConfig := make(map[string]string)
+	GetConfigFunc = func(key string) string {
+		return testConfig[key]
+	}
+	UpdateConfigFunc = func(key, value string) error {
+		testConfig[key] = value
+		return nil
+	}
+
+	// Define test cases for different model selections.
+	testCases := []struct {
+		name            string // Test case name.
+		mockSelection   string // Simulated user input from the survey.
+		expectedModel   string // Expected model configuration after the command.
+		expectedMessage string // Expected output message indicating model update.
+	}{
+		{
+			name:            "select_gemini_2.0_flash",
+			mockSelection:   "Gemini 2.0 Flash",
+			expectedModel:   "gemini-2.0-flash",
+			expectedMessage: "Model updated to: gemini-2.0-flash",
+		},
+		{
+			name:            "select_gemini_2.5_pro",
+			mockSelection:   "Gemini 2.5 Pro",
+			expectedModel:   "gemini-2.5-pro",
+			expectedMessage: "Model updated to: gemini-2.5-pro",
+		},
+		{
+			name:            "invalid_selection_default",
+			mockSelection:   "Invalid Model",
+			expectedModel:   "gemini-2.5-pro", // Default model in case of an invalid selection.
+			expectedMessage: "Model updated to: gemini-2.5-pro",
+		},
+	}
+
+	// Iterate over each model selection test case.
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Override surveyAskOne to simulate the user's selection.
+			surveyAskOne = func(p survey.Prompt, response interface{}, opts ...survey.AskOpt) error {
+				resp := response.(*string)
+				*resp = tc.mockSelection
+				return nil
+			}
+
+			// Set an initial model configuration.
+			testConfig["genai_model"] = "gemini-2.0-flash"
+
+			// Execute the model command.
+			output, err := executeCommand(t, rootCmd, "model")
+			require.NoError(t, err)
+
+			// Verify that the output contains the current model and the update message.
+			assert.Contains(t, output, "Current model: gemini-2.0-flash")
+			assert.Contains(t, output, tc.expectedMessage)
+			// Confirm that the configuration has been updated to the expected model.
+			currentModel := GetConfigFunc("genai_model")
+			assert.Equal(t, tc.expectedModel, currentModel)
+		})
+	}
+
+	// Test that the openai provider accepts any model ID, either as an argument or typed at the prompt.
+	t.Run("openai_free_form_model", func(t *testing.T) {
+		testConfig["provider"] = "openai"
+		defer delete(testConfig, "provider")
+
+		output, err := executeCommand(t, rootCmd, "model", "my-internal-model")
+		require.NoError(t, err)
+		assert.Contains(t, output, "Model updated to: my-internal-model")
+
+		surveyAskOne = func(p survey.Prompt, response interface{}, opts ...survey.AskOpt) error {
+			_, isInput := p.(*survey.Input)
+			assert.True(t, isInput, "openai models should be typed, not selected")
+			*response.(*string) = "typed-model"
+			return nil
+		}
+		output, err = executeCommand(t, rootCmd, "model")
+		require.NoError(t, err)
+		assert.Contains(t, output, "Model updated to: typed-model")
+		assert.Equal(t, "typed-model", testConfig["genai_model"])
+	})
+
+	// Test that a Gemini model can be given as an argument instead of being selected.
+	t.Run("gemini_model_argument", func(t *testing.T) {
+		output, err := executeCommand(t, rootCmd, "model", "gemini-2.5-flash-lite")
+		require.NoError(t, err)
+		assert.Contains(t, output, "Model updated to: gemini-2.5-flash-lite")
+	})
+
+	// Test to ensure that the 'model' command is registered with the root command.
+	t.Run("command_registration", func(t *testing.T) {
+		found := false
+		for _, cmd := range rootCmd.Commands() {
+			if cmd.Name() == "model" {
+				found = true
+				break
+			}
+		}
+		assert.True(t, found, "model command should be registered")
+	})
+}
+
+// TestGeminiBaseURL tests that the Gemini API key is only sent to a base_url written by the user, never to one set by
+// the environment or a project file.
+func TestGeminiBaseURL(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("GENCLI_PROFILE", "")
+	defer func() { require.NoError(t, SetDefaultConfig()) }()
+
+	// keys records the API key received by every server.
+	keys := map[string][]string{}
+	newServer := func(name string) *httptest.Server {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			keys[name] = append(keys[name], r.Header.Get("x-goog-api-key"))
+			_, _ = io.WriteString(w, `{"candidates":[{"content":{"role":"model","parts":[{"text":"Hello from `+name+`"}]},"finishReason":"STOP"}]}`)
+		}))
+		t.Cleanup(server.Close)
+		return server
+	}
+	proxy, other := newServer("proxy"), newServer("other")
+
+	project := t.TempDir()
+	t.Chdir(project)
+	require.NoError(t, saveResponseToFile(filepath.Join(home, ".gencli", "config.yaml"), "genai_model: gemini-2.5-flash\nbase_url: "+proxy.URL+"\n"))
+	require.NoError(t, saveResponseToFile(filepath.Join(project, ".gencli.yaml"), "base_url: "+other.URL+"\n"))
+	t.Setenv("GENCLI_BASE_URL", other.URL)
+
+	output, err := executeCommand(t, rootCmd, "search", "hello", "--stream=false")
+	require.NoError(t, err)
+	assert.Contains(t, output, "Hello from proxy")
+	assert.Equal(t, []string{"test-key"}, keys["proxy"])
+	assert.Empty(t, keys["other"])
+}
+
+// TestGeminiModelLimits tests that the output token limit of a Gemini model is read from the Models API, and that its
+// default top-k isn't taken for a maximum.
+func TestGeminiModelLimits(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		_, _ = io.WriteString(w, `{"name":"models/gemini-2.5-pro","inputTokenLimit":1048576,"outputTokenLimit":32768,"topK":64}`)
+	}))
+	defer server.Close()
+	geminiBaseURL = server.URL
+	defer func() { geminiBaseURL = "" }()
+
+	provider, err := newGeminiProvider(context.Background())
+	require.NoError(t, err)
+	limits, err := provider.(modelDescriber).ModelLimits(context.Background(), "gemini-2.5-pro")
+	require.NoError(t, err)
+	assert.Equal(t, modelLimits{maxOutputTokens: 32768}, limits)
+	assert.Equal(t, []string{"/v1beta/models/gemini-2.5-pro"}, paths)
+}
+
+// TestEditorCommand tests that the editor of config edit, persona and prompt is chosen as git and crontab do.
+func TestEditorCommand(t *testing.T) {
+	testCases := []struct {
+		visual, editor string
+		expected       string
+	}{
+		{"code --wait", "nano", "code --wait"},
+		{"", "nano", "nano"},
+		{"", "", "vi"},
+		{" ", "nano", "nano"},
+		{"", " ", "vi"},
+	}
+	for _, tc := range testCases {
+		t.Setenv("VISUAL", tc.visual)
+		t.Setenv("EDITOR", tc.editor)
+		assert.Equal(t, tc.expected, editorCommand())
+	}
+}
+
+// TestConfigCommand tests the 'config' subcommands, which set, get, unset, list, edit and locate the config values.
+func TestConfigCommand(t *testing.T) {
+	// Backup the original functions to allow restoration later.
+	originalGetConfigFunc := GetConfigFunc
+	originalUpdateConfigFunc := UpdateConfigFunc
+	originalRemoveConfigFunc := RemoveConfigFunc
+	originalRunEditorFunc := runEditorFunc
+	defer func() {
+		GetConfigFunc = originalGetConfigFunc
+		UpdateConfigFunc = originalUpdateConfigFunc
+		RemoveConfigFunc = originalRemoveConfigFunc
+		runEditorFunc = originalRunEditorFunc
+	}()
+
+	// Setup an in-memory configuration map for testing purposes.
+	testConfig := map[string]string{"genai_model": "gemini-2.5-flash"}
+	GetConfigFunc = func(key string) string {
+		return testConfig[key]
+	}
+	UpdateConfigFunc = func(key, value string) error {
+		testConfig[key] = value
+		return nil
+	}
+	RemoveConfigFunc = func(key string) error {
+		delete(testConfig, key)
+		return nil
+	}
+	// Keep the config file written by 'config edit' out of the real home directory.
+	t.Setenv("HOME", t.TempDir())
+
+	t.Run("set", func(t *testing.T) {
+		testCases := []struct {
+			name     string
+			key      string
+			value    string
+			expected error // Expected error, nil when the value is saved.
+		}{
+			{name: "temperature", key: "temperature", value: "0.8"},
+			{name: "temperature_out_of_range", key: "temperature", value: "1.5", expected: ErrInvalidInput},
+			{name: "words", key: "words", value: "80"},
+			{name: "words_not_a_number", key: "words", value: "many", expected: ErrInvalidInput},
+			{name: "gemini_model", key: "genai_model", value: "gemini-2.5-pro"},
+			{name: "unknown_gemini_model", key: "genai_model", value: "gpt-4o", expected: ErrInvalidInput},
+			{name: "provider", key: "provider", value: "ollama"},
+			{name: "unknown_provider", key: "provider", value: "watson", expected: ErrInvalidInput},
+			{name: "base_url", key: "base_url", value: "ftp://example.com", expected: ErrInvalidInput},
+			{name: "retry_max_wait", key: "retry_max_wait", value: "1m"},
+			{name: "unknown_key", key: "colour", value: "blue", expected: ErrInvalidInput},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				previous, wasSet := testConfig[tc.key]
+				output, err := executeCommand(t, rootCmd, "config", "set", tc.key, tc.value)
+				if tc.expected != nil {
+					assert.ErrorIs(t, err, tc.expected)
+					assert.Equal(t, previous, testConfig[tc.key], "invalid values must not be saved")
+					return
+				}
+				require.NoError(t, err)
+				assert.Contains(t, output, tc.key+" set to: "+tc.value)
+				assert.Equal(t, tc.value, testConfig[tc.key])
+
+				// Restore the previous value for the next test cases.
+				if wasSet {
+					testConfig[tc.key] = previous
+				} else {
+					delete(testConfig, tc.key)
+				}
+			})
+		}
+	})
+
+	// Test that any model ID is accepted once the provider isn't Gemini.
+	t.Run("set_model_other_provider", func(t *testing.T) {
+		testConfig["provider"] = "openai"
+		defer delete(testConfig, "provider")
+
+		_, err := executeCommand(t, rootCmd, "config", "set", "genai_model", "gpt-4o")
+		require.NoError(t, err)
+		assert.Equal(t, "gpt-4o", testConfig["genai_model"])
+		testConfig["genai_model"] = "gemini-2.5-flash"
+	})
+
+	t.Run("get_unset_list", func(t *testing.T) {
+		testConfig["language"] = "german"
+
+		output, err := executeCommand(t, rootCmd, "config", "get", "language")
+		require.NoError(t, err)
+		assert.Equal(t, "german\n", output)
+
+		// Keys that aren't set print their default value.
+		output, err = executeCommand(t, rootCmd, "config", "get", "words")
+		require.NoError(t, err)
+		assert.Equal(t, "150\n", output)
+
+		output, err = executeCommand(t, rootCmd, "config", "list")
+		require.NoError(t, err)
+		assert.Contains(t, output, "genai_model = gemini-2.5-flash\n")
+		assert.Contains(t, output, "language = german\n")
+		assert.Contains(t, output, "temperature = 0.5\n")
+
+		output, err = executeCommand(t, rootCmd, "config", "unset", "language")
+		require.NoError(t, err)
+		assert.Contains(t, output, "language unset, using the default: english")
+		assert.NotContains(t, testConfig, "language")
+
+		_, err = executeCommand(t, rootCmd, "config", "get", "colour")
+		assert.ErrorIs(t, err, ErrInvalidInput)
+	})
+
+	t.Run("edit_and_path", func(t *testing.T) {
+		t.Setenv("VISUAL", "")
+		t.Setenv("EDITOR", "nano -w")
+
+		var editor, file string
+		runEditorFunc = func(e, f string) error {
+			editor, file = e, f
+			testConfig["temperature"] = "2"
+			return nil
+		}
+
+		// An invalid value written in the editor is reported once it exits.
+		_, err := executeCommand(t, rootCmd, "config", "edit")
+		assert.ErrorIs(t, err, ErrConfig)
+		assert.Contains(t, err.Error(), "temperature")
+		assert.Equal(t, "nano -w", editor)
+		delete(testConfig, "temperature")
+
+		output, err := executeCommand(t, rootCmd, "config", "path")
+		require.NoError(t, err)
+		assert.Equal(t, file+"\n", output)
+		assert.Equal(t, filepath.Join(".gencli", "config.yaml"), filepath.Join(filepath.Base(filepath.Dir(file)), filepath.Base(file)))
+	})
+
+	// Test that the config values become the defaults of the search flags, and that flags still take precedence.
+	t.Run("flag_defaults", func(t *testing.T) {
+		provider := &fakeProvider{chunks: []string{"answer"}}
+		useFakeProvider(t, provider)
+		testConfig["words"] = "40"
+		testConfig["language"] = "french"
+		// Flags given by earlier tests are still marked as changed, which would hide the config values.
+		resetFlags := func() {
+			for _, name := range []string{"words", "language"} {
+				searchCmd.Flags().Lookup(name).Changed = false
+			}
+		}
+		resetFlags()
+		defer func() {
+			delete(testConfig, "words")
+			delete(testConfig, "language")
+			numWords, outputLanguage = defaultWords, defaultLanguage
+			resetFlags()
+		}()
+
+		_, err := executeCommand(t, rootCmd, "search", "hello", "--stream=false")
+		require.NoError(t, err)
+		assert.Equal(t, "hello in 40 words in french language", contentText(provider.contents[0]))
+
+		_, err = executeCommand(t, rootCmd, "search", "hello", "--stream=false", "--language", "hindi")
+		require.NoError(t, err)
+		assert.Equal(t, "hello in 40 words in hindi language", contentText(provider.contents[0]))
+
+		testConfig["words"] = "lots"
+		resetFlags()
+		_, err = executeCommand(t, rootCmd, "search", "hello", "--stream=false")
+		assert.ErrorIs(t, err, ErrConfig)
+	})
+}
+
+// TestProfileCommand tests the 'profile' subcommands, which create, select, use, list and delete config profiles.
+func TestProfileCommand(t *testing.T) {
+	// Profiles are files, so this test uses the real config functions with a temporary home directory.
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("GENCLI_PROFILE", "")
+	originalSurveyAskOne := surveyAskOne
+	defer func() {
+		surveyAskOne = originalSurveyAskOne
+		profileName, deleteProfileYes = "", false
+		newProfileProvider, newProfileModel, newProfileKeyEnv, newProfileBaseURL = "", "", "", ""
+		// Load the config of the other tests' home directory again.
+		require.NoError(t, SetDefaultConfig())
+	}()
+
+	t.Run("create", func(t *testing.T) {
+		output, err := executeCommand(t, rootCmd, "profile", "create", "work", "--provider", "openai", "--model", "gpt-4o", "--api-key-env", "WORK_KEY")
+		require.NoError(t, err)
+		assert.Contains(t, output, "Profile work created")
+		data, err := os.ReadFile(filepath.Join(home, ".gencli", "profiles", "work.yaml"))
+		require.NoError(t, err)
+		assert.Contains(t, string(data), "genai_model: gpt-4o")
+		assert.Contains(t, string(data), "provider: openai")
+		newProfileProvider, newProfileModel, newProfileKeyEnv = "", "", ""
+
+		testCases := []struct {
+			name string
+			args []string
+		}{
+			{name: "already_exists", args: []string{"profile", "create", "work"}},
+			{name: "reserved_name", args: []string{"profile", "create", "default"}},
+			{name: "invalid_name", args: []string{"profile", "create", "../work"}},
+			// Without --provider the profile uses Gemini, which doesn't offer this model.
+			{name: "unknown_gemini_model", args: []string{"profile", "create", "ci", "--model", "gpt-4o"}},
+		}
+		for _, tc := range testCases {
+			_, err := executeCommand(t, rootCmd, tc.args...)
+			assert.ErrorIs(t, err, ErrInvalidInput, tc.name)
+		}
+		newProfileModel = ""
+	})
+
+	t.Run("select", func(t *testing.T) {
+		// Values set with --profile are written to the profile, and the config file keeps its own.
+		_, err := executeCommand(t, rootCmd, "--profile", "work", "config", "set", "temperature", "0.2")
+		require.NoError(t, err)
+		output, err := executeCommand(t, rootCmd, "--profile", "work", "config", "get", "temperature")
+		require.NoError(t, err)
+		assert.Equal(t, "0.2\n", output)
+
+		profileName = ""
+		rootCmd.PersistentFlags().Lookup("profile").Changed = false
+		output, err = executeCommand(t, rootCmd, "config", "get", "temperature")
+		require.NoError(t, err)
+		assert.Equal(t, "0.5\n", output)
+
+		// GENCLI_PROFILE selects the profile, and the values it doesn't set come from the config file.
+		t.Setenv("GENCLI_PROFILE", "work")
+		output, err = executeCommand(t, rootCmd, "config", "list")
+		require.NoError(t, err)
+		assert.Contains(t, output, "temperature = 0.2\n")
+		assert.Contains(t, output, "genai_model = gpt-4o\n")
+		assert.Contains(t, output, "language = english\n")
+
+		t.Setenv("GENCLI_PROFILE", "missing")
+		_, err = executeCommand(t, rootCmd, "config", "list")
+		assert.ErrorIs(t, err, ErrConfig)
+		t.Setenv("GENCLI_PROFILE", "")
+	})
+
+	t.Run("use_and_list", func(t *testing.T) {
+		_, err := executeCommand(t, rootCmd, "profile", "create", "personal")
+		require.NoError(t, err)
+
+		output, err := executeCommand(t, rootCmd, "profile", "use", "work")
+		require.NoError(t, err)
+		assert.Contains(t, output, "Now using profile: work")
+
+		output, err = executeCommand(t, rootCmd, "profile", "list")
+		require.NoError(t, err)
+		assert.Equal(t, "  default\n  personal\n* work\n", output)
+
+		output, err = executeCommand(t, rootCmd, "config", "get", "genai_model")
+		require.NoError(t, err)
+		assert.Equal(t, "gpt-4o\n", output)
+
+		_, err = executeCommand(t, rootCmd, "profile", "use", "nope")
+		assert.ErrorIs(t, err, ErrInvalidInput)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		// Declining the confirmation keeps the profile.
+		surveyAskOne = func(p survey.Prompt, response interface{}, opts ...survey.AskOpt) error {
+			*response.(*bool) = false
+			return nil
+		}
+		output, err := executeCommand(t, rootCmd, "profile", "delete", "work")
+		require.NoError(t, err)
+		assert.Contains(t, output, "Profile not deleted")
+
+		// Deleting the active profile goes back to the default one.
+		output, err = executeCommand(t, rootCmd, "profile", "delete", "work", "--yes")
+		require.NoError(t, err)
+		assert.Contains(t, output, "Profile deleted: work")
+		output, err = executeCommand(t, rootCmd, "profile", "list")
+		require.NoError(t, err)
+		assert.Equal(t, "* default\n  personal\n", output)
+
+		_, err = executeCommand(t, rootCmd, "profile", "delete", "default", "--yes")
+		assert.ErrorIs(t, err, ErrInvalidInput)
+	})
+}
+
+// TestPersonaCommand tests 'gencli persona' and the --system, --system-file and --persona flags, which send a system
+// instruction with the request.
+func TestPersonaCommand(t *testing.T) {
+	// Personas are files, so this test uses a temporary home directory.
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	originalGetConfigFunc := GetConfigFunc
+	originalSurveyAskOne := surveyAskOne
+	defer func() {
+		GetConfigFunc = originalGetConfigFunc
+		surveyAskOne = originalSurveyAskOne
+		generation, deletePersonaYes, streamOutput = generationOptions{}, false, false
+		rootCmd.SetIn(nil)
+	}()
+	config := map[string]string{}
+	GetConfigFunc = func(key string) string { return config[key] }
+	provider := &fakeProvider{chunks: []string{"answer"}}
+	useFakeProvider(t, provider)
+
+	t.Run("create", func(t *testing.T) {
+		output, err := executeCommand(t, rootCmd, "persona", "create", "go-reviewer", "You are a senior Go reviewer.\nPoint out bugs first.")
+		require.NoError(t, err)
+		assert.Contains(t, output, "Persona go-reviewer created")
+		data, err := os.ReadFile(filepath.Join(home, ".gencli", "personas", "go-reviewer.md"))
+		require.NoError(t, err)
+		assert.Equal(t, "You are a senior Go reviewer.\nPoint out bugs first.\n", string(data))
+
+		// The instruction can be piped, and the editor is opened without one.
+		rootCmd.SetIn(strings.NewReader("You are a terse sysadmin.\n"))
+		_, err = executeCommand(t, rootCmd, "persona", "create", "sysadmin")
+		require.NoError(t, err)
+		rootCmd.SetIn(strings.NewReader(""))
+		originalRunEditorFunc := runEditorFunc
+		defer func() { runEditorFunc = originalRunEditorFunc }()
+		runEditorFunc = func(editor, file string) error { return os.WriteFile(file, []byte("You are a patient teacher."), 0644) }
+		_, err = executeCommand(t, rootCmd, "persona", "create", "teacher")
+		require.NoError(t, err)
+
+		// A persona left empty in the editor isn't created.
+		runEditorFunc = func(editor, file string) error { return nil }
+		_, err = executeCommand(t, rootCmd, "persona", "create", "empty")
+		assert.ErrorIs(t, err, ErrInvalidInput)
+		assert.NoFileExists(t, filepath.Join(home, ".gencli", "personas", "empty.md"))
+		rootCmd.SetIn(nil)
+
+		for _, args := range [][]string{
+			{"persona", "create", "go-reviewer", "again"},
+			{"persona", "create", "../reviewer", "x"},
+		} {
+			_, err := executeCommand(t, rootCmd, args...)
+			assert.ErrorIs(t, err, ErrInvalidInput, args)
+		}
+	})
+
+	t.Run("list_and_show", func(t *testing.T) {
+		output, err := executeCommand(t, rootCmd, "persona", "list")
+		require.NoError(t, err)
+		assert.Equal(t, "go-reviewer  You are a senior Go reviewer.\nsysadmin     You are a terse sysadmin.\nteacher      You are a patient teacher.\n", output)
+
+		output, err = executeCommand(t, rootCmd, "persona", "show", "go-reviewer")
+		require.NoError(t, err)
+		assert.Equal(t, "You are a senior Go reviewer.\nPoint out bugs first.\n", output)
+
+		_, err = executeCommand(t, rootCmd, "persona", "show", "missing")
+		assert.ErrorIs(t, err, ErrInvalidInput)
+	})
+
+	t.Run("system_instruction", func(t *testing.T) {
+		systemFile := filepath.Join(t.TempDir(), "system.md")
+		require.NoError(t, os.WriteFile(systemFile, []byte("Answer in haiku.\n"), 0644))
+
+		testCases := []struct {
+			name string
+			args []string
+			want string
+		}{
+			{name: "system", args: []string{"--system", "Be brief."}, want: "Be brief."},
+			{name: "system_file", args: []string{"--system-file", systemFile}, want: "Answer in haiku."},
+			{name: "persona", args: []string{"--persona", "sysadmin"}, want: "You are a terse sysadmin."},
+		}
+		for _, tc := range testCases {
+			_, err := executeCommand(t, rootCmd, append([]string{"search", "q", "--stream=false"}, tc.args...)...)
+			require.NoError(t, err, tc.name)
+			require.NotNil(t, provider.config.SystemInstruction, tc.name)
+			assert.Equal(t, tc.want, contentText(provider.config.SystemInstruction), tc.name)
+			generation = generationOptions{}
+		}
+
+		// Without any of them, no system instruction is sent.
+		_, err := executeCommand(t, rootCmd, "search", "q", "--stream=false")
+		require.NoError(t, err)
+		assert.Nil(t, provider.config.SystemInstruction)
+
+		// The chat keeps its own instruction after the persona's.
+		rootCmd.SetIn(strings.NewReader("hello\n"))
+		_, err = executeCommand(t, rootCmd, "chat", "--persona", "teacher")
+		require.NoError(t, err)
+		rootCmd.SetIn(nil)
+		assert.Equal(t, "You are a patient teacher.\n\nAlways respond in english language.", contentText(provider.config.SystemInstruction))
+		generation = generationOptions{}
+
+		for _, args := range [][]string{
+			{"--system", "Be brief.", "--persona", "sysadmin"},
+			{"--persona", "missing"},
+			{"--system-file", filepath.Join(t.TempDir(), "missing.md")},
+		} {
+			_, err := executeCommand(t, rootCmd, append([]string{"search", "q", "--stream=false"}, args...)...)
+			assert.ErrorIs(t, err, ErrInvalidInput, args)
+			generation = generationOptions{}
+		}
+	})
+
+	t.Run("config", func(t *testing.T) {
+		// The persona of the config is used unless an instruction is given.
+		config["persona"] = "go-reviewer"
+		defer delete(config, "persona")
+		_, err := executeCommand(t, rootCmd, "search", "q", "--stream=false")
+		require.NoError(t, err)
+		assert.Equal(t, "You are a senior Go reviewer.\nPoint out bugs first.", contentText(provider.config.SystemInstruction))
+
+		_, err = executeCommand(t, rootCmd, "search", "q", "--stream=false", "--system", "Be brief.")
+		require.NoError(t, err)
+		assert.Equal(t, "Be brief.", contentText(provider.config.SystemInstruction))
+		generation = generationOptions{}
+
+		// The system key replaces the persona key.
+		config["system"] = "Answer in French."
+		_, err = executeCommand(t, rootCmd, "search", "q", "--stream=false")
+		require.NoError(t, err)
+		assert.Equal(t, "Answer in French.", contentText(provider.config.SystemInstruction))
+		delete(config, "system")
+
+		config["persona"] = "missing"
+		_, err = executeCommand(t, rootCmd, "search", "q", "--stream=false")
+		assert.ErrorIs(t, err, ErrConfig)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		surveyAskOne = func(p survey.Prompt, response interface{}, opts ...survey.AskOpt) error {
+			*response.(*bool) = false
+			return nil
+		}
+		output, err := executeCommand(t, rootCmd, "persona", "delete", "teacher")
+		require.NoError(t, err)
+		assert.Contains(t, output, "Persona not deleted")
+
+		output, err = executeCommand(t, rootCmd, "persona", "delete", "teacher", "--yes")
+		require.NoError(t, err)
+		assert.Contains(t, output, "Persona deleted: teacher")
+		assert.NoFileExists(t, filepath.Join(home, ".gencli", "personas", "teacher.md"))
+
+		_, err = executeCommand(t, rootCmd, "persona", "delete", "teacher", "--yes")
+		assert.ErrorIs(t, err, ErrInvalidInput)
+	})
+}
+
+// TestPromptCommand tests 'gencli prompt', which runs text/template prompts stored in ~/.gencli/prompts and in the
+// project's .gencli/prompts.
+func TestPromptCommand(t *testing.T) {
+	// Templates are files, so this test uses a temporary home directory and project.
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	project := t.TempDir()
+	t.Chdir(project)
+	originalGetConfigFunc := GetConfigFunc
+	originalRunEditorFunc := runEditorFunc
+	defer func() {
+		GetConfigFunc = originalGetConfigFunc
+		runEditorFunc = originalRunEditorFunc
+		generation, newPromptProject, promptTemperature, streamPrompt = generationOptions{}, false, defaultTemperature, false
+		promptRunCmd.Flags().Lookup("temperature").Changed = false
+		rootCmd.SetIn(nil)
+	}()
+	config := map[string]string{"genai_model": "gemini-2.5-flash"}
+	GetConfigFunc = func(key string) string { return config[key] }
+	provider := &fakeProvider{chunks: []string{"answer"}}
+	useFakeProvider(t, provider)
+
+	writeTemplate := func(dir, name, content string) {
+		t.Helper()
+		require.NoError(t, os.MkdirAll(dir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name+".md"), []byte(content), 0644))
+	}
+	userDir := filepath.Join(home, ".gencli", "prompts")
+	projectDir := filepath.Join(project, ".gencli", "prompts")
+	writeTemplate(userDir, "release-notes", "---\ndescription: Write release notes\nvars: [version, changes]\nmodel: gemini-2.5-pro\ntemperature: 0.2\n---\nWrite the notes of {{.version}} for {{or .audience \"developers\"}}:\n{{.changes}}\n")
+	writeTemplate(userDir, "review", "---\ndescription: Review a change\n---\nReview this.")
+	writeTemplate(projectDir, "review", "---\ndescription: Review a change of this project\n---\nReview this change:\n\n{{.input}}\n")
+	writeTemplate(projectDir, "moons", "---\ndescription: List moons\nvars: [planet]\nschema:\n  type: array\n  items:\n    type: object\n    required: [name]\n    additionalProperties: false\n    properties:\n      name: {type: string}\n---\nList the largest moons of {{.planet}}.")
+	writeTemplate(userDir, "broken", "---\ntemprature: 0.2\n---\nTypo.")
+
+	t.Run("run", func(t *testing.T) {
+		output, err := executeCommand(t, rootCmd, "prompt", "run", "release-notes", "--var", "version=v1.4.0", "--var", "changes=Faster builds", "--render", "markdown")
+		require.NoError(t, err)
+		assert.Equal(t, "answer\n", output)
+		assert.Equal(t, "Write the notes of v1.4.0 for developers:\nFaster builds", provider.contents[0].Parts[0].Text)
+		// The template's model and temperature replace the configured ones.
+		assert.Equal(t, "gemini-2.5-pro", provider.model)
+		assert.Equal(t, float32(0.2), *provider.config.Temperature)
+
+		// --temperature replaces the template's, and optional variables can be given too.
+		_, err = executeCommand(t, rootCmd, "prompt", "run", "release-notes", "--var", "version=v2", "--var", "changes=None", "--var", "audience=users", "--temperature", "0.9")
+		require.NoError(t, err)
+		assert.Equal(t, "Write the notes of v2 for users:\nNone", provider.contents[0].Parts[0].Text)
+		assert.Equal(t, float32(0.9), *provider.config.Temperature)
+		promptTemperature = defaultTemperature
+		promptRunCmd.Flags().Lookup("temperature").Changed = false
+
+		// Anything piped is {{.input}}, and the project's template hides the user's.
+		rootCmd.SetIn(strings.NewReader("diff --git a/main.go b/main.go"))
+		_, err = executeCommand(t, rootCmd, "prompt", "run", "review")
+		require.NoError(t, err)
+		rootCmd.SetIn(nil)
+		assert.Equal(t, "Review this change:\n\ndiff --git a/main.go b/main.go", provider.contents[0].Parts[0].Text)
+		assert.Equal(t, "gemini-2.5-flash", provider.model)
+	})
+
+	t.Run("schema", func(t *testing.T) {
+		// A response that doesn't match the schema is asked for again.
+		provider.replies = []string{`[{"title": "Io"}]`, `[{"name": "Ganymede"}]`}
+		output, err := executeCommand(t, rootCmd, "prompt", "run", "moons", "--var", "planet=Jupiter")
+		require.NoError(t, err)
+		assert.Equal(t, "[{\"name\": \"Ganymede\"}]\n", output)
+		assert.Equal(t, "application/json", provider.config.ResponseMIMEType)
+		// Inline schemas keep the case of their keywords.
+		assert.Contains(t, provider.config.ResponseJsonSchema, "items")
+		assert.Contains(t, provider.config.ResponseJsonSchema.(map[string]any)["items"], "additionalProperties")
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		provider.contents = nil
+		testCases := []struct {
+			name string
+			args []string
+		}{
+			{name: "missing_vars", args: []string{"prompt", "run", "release-notes", "--var", "version=v1"}},
+			{name: "invalid_var", args: []string{"prompt", "run", "review", "--var", "no-equals"}},
+			{name: "unknown_prompt", args: []string{"prompt", "run", "missing"}},
+			{name: "unknown_front_matter_key", args: []string{"prompt", "run", "broken"}},
+			{name: "invalid_name", args: []string{"prompt", "run", "../review"}},
+		}
+		for _, tc := range testCases {
+			_, err := executeCommand(t, rootCmd, tc.args...)
+			assert.ErrorIs(t, err, ErrInvalidInput, tc.name)
+		}
+		// Nothing is sent before the variables are checked.
+		assert.Nil(t, provider.contents)
+	})
+
+	t.Run("list_and_show", func(t *testing.T) {
+		output, err := executeCommand(t, rootCmd, "prompt", "list")
+		require.NoError(t, err)
+		assert.Equal(t, "broken         user     (invalid, see 'gencli prompt run broken')\n"+
+			"moons          project  List moons\n"+
+			"release-notes  user     Write release notes\n"+
+			"review         project  Review a change of this project\n", output)
+
+		output, err = executeCommand(t, rootCmd, "prompt", "show", "review")
+		require.NoError(t, err)
+		assert.Equal(t, "---\ndescription: Review a change of this project\n---\nReview this change:\n\n{{.input}}\n", output)
+	})
+
+	t.Run("new_and_edit", func(t *testing.T) {
+		var edited []string
+		runEditorFunc = func(editor, file string) error {
+			edited = append(edited, file)
+			return nil
+		}
+		output, err := executeCommand(t, rootCmd, "prompt", "new", "explain")
+		require.NoError(t, err)
+		assert.Contains(t, output, "Prompt explain created")
+		assert.Equal(t, []string{filepath.Join(userDir, "explain.md")}, edited)
+
+		// The example template works as is.
+		rootCmd.SetIn(strings.NewReader("fmt.Println(1)"))
+		_, err = executeCommand(t, rootCmd, "prompt", "run", "explain", "--var", "language=Go")
+		require.NoError(t, err)
+		rootCmd.SetIn(nil)
+		assert.Equal(t, "Explain what the following Go code does, then point out anything surprising in it.\n\nfmt.Println(1)", provider.contents[0].Parts[0].Text)
+
+		_, err = executeCommand(t, rootCmd, "prompt", "new", "explain", "--project")
+		require.NoError(t, err)
+		assert.FileExists(t, filepath.Join(projectDir, "explain.md"))
+		newPromptProject = false
+		_, err = executeCommand(t, rootCmd, "prompt", "new", "explain")
+		assert.ErrorIs(t, err, ErrInvalidInput)
+
+		// A template broken in the editor is reported.
+		runEditorFunc = func(editor, file string) error { return os.WriteFile(file, []byte("{{.unclosed"), 0644) }
+		_, err = executeCommand(t, rootCmd, "prompt", "edit", "explain")
+		assert.ErrorIs(t, err, ErrInvalidInput)
+	})
+}
+
+// TestConfigPrecedence tests that the config values are taken from the flags, environment, project file, profile and
+// user's config file, in that order.
+func TestConfigPrecedence(t *testing.T) {
+	// The layers are files and environment variables, so this test uses the real config functions.
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("GENCLI_PROFILE", "")
+	// Flags given by earlier tests are still marked as changed, which would hide the config values.
+	resetFlags := func() {
+		numWords, outputLanguage, temperature = defaultWords, defaultLanguage, defaultTemperature
+		for _, name := range []string{"words", "language", "temperature"} {
+			searchCmd.Flags().Lookup(name).Changed = false
+		}
+	}
+	resetFlags()
+	defer func() {
+		profileName, showOrigin = "", false
+		resetFlags()
+		require.NoError(t, SetDefaultConfig())
+	}()
+
+	// The project file is found from a subdirectory of the project.
+	project := t.TempDir()
+	subdir := filepath.Join(project, "src", "app")
+	require.NoError(t, os.MkdirAll(subdir, 0755))
+	t.Chdir(subdir)
+
+	userFile := filepath.Join(home, ".gencli", "config.yaml")
+	require.NoError(t, saveResponseToFile(userFile, "genai_model: gemini-2.5-pro\nlanguage: french\nwords: \"40\"\ntemperature: 0.7\n"))
+	require.NoError(t, saveResponseToFile(filepath.Join(home, ".gencli", "profiles", "work.yaml"), "words: \"60\"\noutput: work.txt\n"))
+	projectFile := filepath.Join(project, ".gencli.yaml")
+	require.NoError(t, saveResponseToFile(projectFile, "language: german\nwords: \"80\"\nsystem: You review the payments service.\n"))
+	t.Setenv("GENCLI_TEMPERATURE", "0.9")
+
+	output, err := executeCommand(t, rootCmd, "--profile", "work", "config", "list", "--show-origin")
+	require.NoError(t, err)
+	profileFile := filepath.Join(home, ".gencli", "profiles", "work.yaml")
+	assert.Contains(t, output, "user:"+userFile+"\tgenai_model = gemini-2.5-pro\n")
+	assert.Contains(t, output, "project:"+projectFile+"\tlanguage = german\n")
+	assert.Contains(t, output, "project:"+projectFile+"\twords = 80\n")
+	assert.Contains(t, output, "profile:"+profileFile+"\toutput = work.txt\n")
+	assert.Contains(t, output, "env:GENCLI_TEMPERATURE\ttemperature = 0.9\n")
+	assert.Contains(t, output, "default\tmax_retries = 3\n")
+	profileName = ""
+	rootCmd.PersistentFlags().Lookup("profile").Changed = false
+
+	// The command flags take the effective values as their defaults, and take precedence when given.
+	provider := &fakeProvider{chunks: []string{"answer"}}
+	useFakeProvider(t, provider)
+	_, err = executeCommand(t, rootCmd, "search", "hello", "--stream=false")
+	require.NoError(t, err)
+	assert.Equal(t, "hello in 80 words in german language", contentText(provider.contents[0]))
+	assert.InDelta(t, 0.9, *provider.config.Temperature, 0.0001)
+	// A project pins its own system instruction.
+	assert.Equal(t, "You review the payments service.", contentText(provider.config.SystemInstruction))
+
+	// image batch takes the configured language too, like the other commands.
+	var pngData bytes.Buffer
+	require.NoError(t, png.Encode(&pngData, image.NewGray(image.Rect(0, 0, 4, 4))))
+	images := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(images, "a.png"), pngData.Bytes(), 0644))
+	_, err = executeCommand(t, rootCmd, "image", "batch", images, "--prompt", "Write alt text")
+	require.NoError(t, err)
+	assert.Equal(t, "Write alt text in german language", provider.contents[0].Parts[1].Text)
+
+	t.Setenv("GENCLI_LANGUAGE", "hindi")
+	_, err = executeCommand(t, rootCmd, "search", "hello", "--stream=false", "--words", "20")
+	require.NoError(t, err)
+	assert.Equal(t, "hello in 20 words in hindi language", contentText(provider.contents[0]))
+}
+
+// TestProjectConfigRestrictions tests that a project file, or the environment, can't send the requests and the API
+// key to another host.
+func TestProjectConfigRestrictions(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("GENCLI_PROFILE", "")
+	defer func() { require.NoError(t, SetDefaultConfig()) }()
+
+	// requests records the API key received by every server.
+	requests := map[string][]string{}
+	newServer := func(name, answer string) *httptest.Server {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests[name] = append(requests[name], r.Header.Get("x-goog-api-key")+r.Header.Get("Authorization"))
+			_, _ = io.WriteString(w, answer)
+		}))
+		t.Cleanup(server.Close)
+		return server
+	}
+	gemini := newServer("gemini", `{"candidates":[{"content":{"role":"model","parts":[{"text":"Hello from gemini"}]},"finishReason":"STOP"}]}`)
+	other := newServer("other", `{"choices":[{"message":{"role":"assistant","content":"Hello from other"},"finish_reason":"stop"}]}`)
+
+	// The user's own config sends the Gemini requests to a local server.
+	project := t.TempDir()
+	t.Chdir(project)
+	require.NoError(t, saveResponseToFile(filepath.Join(home, ".gencli", "config.yaml"), "genai_model: gemini-2.5-flash\nbase_url: "+gemini.URL+"\n"))
+	projectFile := filepath.Join(project, ".gencli.yaml")
+	require.NoError(t, saveResponseToFile(projectFile, "provider: openai\nbase_url: "+other.URL+"/v1\napi_key_env: GOOGLE_API_KEY\ncurrent_profile: evil\nlanguage: german\n"))
+
+	t.Run("project", func(t *testing.T) {
+		output, err := executeCommand(t, rootCmd, "search", "hello", "--stream=false")
+		require.NoError(t, err)
+		assert.Contains(t, output, "Hello from gemini")
+		assert.Equal(t, []string{"test-key"}, requests["gemini"])
+		assert.Empty(t, requests["other"])
+
+		// The allowed keys are still read from the project file.
+		output, err = executeCommand(t, rootCmd, "config", "list", "--show-origin")
+		require.NoError(t, err)
+		assert.Contains(t, output, "project:"+projectFile+"\tlanguage = german\n")
+		assert.Contains(t, output, "default\tprovider = gemini\n")
+		assert.NotContains(t, output, other.URL)
+		outputLanguage = defaultLanguage
+		searchCmd.Flags().Lookup("language").Changed = false
+	})
+
+	t.Run("warned_once", func(t *testing.T) {
+		originalStderr := os.Stderr
+		defer func() { os.Stderr = originalStderr }()
+		stderr, err := os.CreateTemp(t.TempDir(), "stderr")
+		require.NoError(t, err)
+		defer stderr.Close()
+		os.Stderr = stderr
+		t.Setenv("GENCLI_API_KEY_ENV", "GOOGLE_API_KEY")
+		delete(configWarnings, "GENCLI_API_KEY_ENV is ignored, api_key_env can only be set in your config file or a profile")
+
+		// The layers are read again when the config changes, which doesn't repeat the warnings.
+		_, err = executeCommand(t, rootCmd, "config", "set", "words", "90")
+		require.NoError(t, err)
+		require.NoError(t, SetDefaultConfig())
+		warnings, err := os.ReadFile(stderr.Name())
+		require.NoError(t, err)
+		assert.Equal(t, 1, strings.Count(string(warnings), "GENCLI_API_KEY_ENV is ignored"))
+	})
+
+	t.Run("env", func(t *testing.T) {
+		require.NoError(t, os.Remove(projectFile))
+		t.Setenv("GENCLI_PROVIDER", "openai")
+		t.Setenv("GENCLI_BASE_URL", other.URL+"/v1")
+		t.Setenv("GENCLI_API_KEY_ENV", "GOOGLE_API_KEY")
+		requests = map[string][]string{}
+		output, err := executeCommand(t, rootCmd, "search", "hello", "--stream=false")
+		require.NoError(t, err)
+		assert.Contains(t, output, "Hello from gemini")
+		assert.Empty(t, requests["other"])
+	})
+}
+
+// TestErrorHandling tests how the CLI handles invalid commands.
+// It verifies that an appropriate error message is shown when an unknown command is used.
+func TestErrorHandling(t *testing.T) {
+	t.Run("invalid_command", func(t *testing.T) {
+		// Attempt to execute a command that doesn't exist.
+		_, err := executeCommand(t, rootCmd, "invalid-command")
+		// Verify that an error is returned.
+		assert.Error(t, err)
+		// Check that the error message mentions "unknown command".
+		assert.Contains(t, err.Error(), "unknown command")
+		assert.ErrorIs(t, err, ErrInvalidInput)
+	})
+
+	t.Run("input_errors", func(t *testing.T) {
+		testCases := []struct {
+			name string
+			args []string
+		}{
+			{name: "unknown_flag", args: []string{"search", "query", "--no-such-flag"}},
+			{name: "missing_argument", args: []string{"search"}},
+			{name: "missing_image_path", args: []string{"image", "query", "--path", ""}},
+			{name: "invalid_words", args: []string{"search", "query", "--words", "many", "--stream=false"}},
+			{name: "invalid_error_format", args: []string{"version", "--error-format", "xml"}},
+		}
+		defer func() { numWords, errorFormat = "150", "text" }()
+
+		for _, tc := range testCases {
+			_, err := executeCommand(t, rootCmd, tc.args...)
+			assert.ErrorIs(t, err, ErrInvalidInput, tc.name)
+		}
+	})
+
+	t.Run("provider_errors", func(t *testing.T) {
+		provider := &fakeProvider{}
+		useFakeProvider(t, provider)
+
+		testCases := []struct {
+			name     string
+			err      error
+			resp     *genai.GenerateContentResponse
+			expected error
+			exitCode int
+		}{
+			{name: "gemini_unauthorized", err: genai.APIError{Code: 403, Message: "denied"}, expected: ErrAuth, exitCode: ExitAuth},
+			{name: "gemini_invalid_key", err: genai.APIError{Code: 400, Details: []map[string]any{{"reason": "API_KEY_INVALID"}}}, expected: ErrAuth, exitCode: ExitAuth},
+			{name: "gemini_quota", err: genai.APIError{Code: 429, Status: "RESOURCE_EXHAUSTED"}, expected: ErrRateLimited, exitCode: ExitRateLimited},
+			{name: "gemini_unavailable", err: genai.APIError{Code: 503}, expected: ErrAPI, exitCode: ExitAPI},
+			{name: "http_rate_limited", err: &statusError{Provider: "openai", Code: 429}, expected: ErrRateLimited, exitCode: ExitRateLimited},
+			{name: "network", err: errors.New("connection refused"), expected: ErrAPI, exitCode: ExitAPI},
+			{
+				name:     "prompt_blocked",
+				resp:     &genai.GenerateContentResponse{PromptFeedback: &genai.GenerateContentResponsePromptFeedback{BlockReason: genai.BlockedReasonSafety}},
+				expected: ErrSafetyBlocked,
+				exitCode: ExitSafetyBlocked,
+			},
+			{
+				name:     "response_blocked",
+				resp:     &genai.GenerateContentResponse{Candidates: []*genai.Candidate{{FinishReason: genai.FinishReasonSafety}}},
+				expected: ErrSafetyBlocked,
+				exitCode: ExitSafetyBlocked,
+			},
+		}
+
+		for _, tc := range testCases {
+			provider.err = tc.err
+			var err error
+			if tc.resp != nil {
+				err = checkBlocked(tc.resp)
+			} else {
+				_, err = executeCommand(t, rootCmd, "search", "query", "--stream=false")
+			}
+			assert.ErrorIs(t, err, tc.expected, tc.name)
+			code, _ := exitCode(err)
+			assert.Equal(t, tc.exitCode, code, tc.name)
+		}
+	})
+
+	t.Run("error_format", func(t *testing.T) {
+		err := fmt.Errorf("%w: quota exceeded", ErrRateLimited)
+
+		var text bytes.Buffer
+		printError(&text, err, "text")
+		assert.Equal(t, "Error: rate limited: quota exceeded\n", text.String())
+
+		var jsonOutput bytes.Buffer
+		printError(&jsonOutput, err, "json")
+		var decoded map[string]map[string]any
+		require.NoError(t, json.Unmarshal(jsonOutput.Bytes(), &decoded))
+		assert.Equal(t, "rate_limited", decoded["error"]["code"])
+		assert.Equal(t, float64(ExitRateLimited), decoded["error"]["exit_code"])
+		assert.Equal(t, "rate limited: quota exceeded", decoded["error"]["message"])
+
+		code, name := exitCode(errors.New("disk full"))
+		assert.Equal(t, ExitError, code)
+		assert.Equal(t, "error", name)
 	})
 }
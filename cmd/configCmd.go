@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var showOrigin bool
+
+var configCmd = &cobra.Command{
+	Use:     "config",
+	Example: "gencli config set temperature 0.8\ngencli config get genai_model\ngencli config list",
+	Short:   "Read and change the gencli configuration",
+	Long:    "Read and change the values stored in the gencli config file. Every value is checked before it is saved, and the language, temperature, words and output values become the defaults of the matching flags.",
+	Args:    unknownCommand,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return cmd.Help()
+	},
+}
+
+var configGetCmd = &cobra.Command{
+	Use:     "get [key]",
+	Example: "gencli config get temperature",
+	Short:   "Print the value of a config key",
+	Long:    "Print the value of a config key, or its default value when it isn't set.",
+	Args:    validArgs(cobra.ExactArgs(1)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key, err := findConfigKey(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Println(configValue(key))
+		return nil
+	},
+}
+
+var configSetCmd = &cobra.Command{
+	Use:     "set [key] [value]",
+	Example: "gencli config set language french",
+	Short:   "Change the value of a config key",
+	Long:    "Change the value of a config key. Unknown keys and invalid values, such as an unknown model or a temperature out of range, are rejected.",
+	Args:    validArgs(cobra.ExactArgs(2)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key, err := findConfigKey(args[0])
+		if err != nil {
+			return err
+		}
+		if err := key.validate(args[1]); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidInput, err)
+		}
+		if err := UpdateConfigFunc(key.name, args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("%s set to: %s\n", key.name, args[1])
+		return nil
+	},
+}
+
+var configUnsetCmd = &cobra.Command{
+	Use:     "unset [key]",
+	Example: "gencli config unset temperature",
+	Short:   "Remove a config key so that its default value is used",
+	Args:    validArgs(cobra.ExactArgs(1)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key, err := findConfigKey(args[0])
+		if err != nil {
+			return err
+		}
+		if err := RemoveConfigFunc(key.name); err != nil {
+			return err
+		}
+		if key.defaultValue == "" {
+			fmt.Printf("%s unset\n", key.name)
+		} else {
+			fmt.Printf("%s unset, using the default: %s\n", key.name, key.defaultValue)
+		}
+		return nil
+	},
+}
+
+var configListCmd = &cobra.Command{
+	Use:     "list",
+	Example: "gencli config list --show-origin",
+	Short:   "Print every config key with its value",
+	Long:    "Print every config key with its effective value. With --show-origin, every value is preceded by where it comes from: an environment variable (env), the project's .gencli.yaml (project), the active profile (profile), your config file (user) or the built-in default (default).",
+	Args:    validArgs(cobra.NoArgs),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		for _, key := range configKeys {
+			if !showOrigin {
+				fmt.Printf("%s = %s\n", key.name, configValue(key))
+				continue
+			}
+
+			value, origin := configOrigin(key.name)
+			if origin == "" {
+				value, origin = key.defaultValue, "default"
+			} else if origin == "env" {
+				origin = "env:" + envPrefix + strings.ToUpper(key.name)
+			}
+			fmt.Printf("%s\t%s = %s\n", origin, key.name, value)
+		}
+		return nil
+	},
+}
+
+var configEditCmd = &cobra.Command{
+	Use:   "edit",
+	Short: "Open the config file in your editor",
+	Long:  "Open the config file, or the active profile's file, in the editor set by $VISUAL or $EDITOR, or vi when neither is set. The file is checked once the editor exits.",
+	Args:  validArgs(cobra.NoArgs),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file, err := activeConfigFile()
+		if err != nil {
+			return err
+		}
+
+		editor := editorCommand()
+		if err := runEditorFunc(editor, file); err != nil {
+			return fmt.Errorf("running %s: %w", editor, err)
+		}
+
+		if err := SetDefaultConfig(); err != nil {
+			return err
+		}
+		return validateConfig()
+	},
+}
+
+var configPathCmd = &cobra.Command{
+	Use:   "path",
+	Short: "Print the path of the config file, or of the active profile's file",
+	Args:  validArgs(cobra.NoArgs),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file, err := activeConfigFile()
+		if err != nil {
+			return err
+		}
+		fmt.Println(file)
+		return nil
+	},
+}
+
+func init() {
+	configListCmd.Flags().BoolVar(&showOrigin, "show-origin", false, "Show where every value comes from")
+	configCmd.AddCommand(configGetCmd)
+	configCmd.AddCommand(configSetCmd)
+	configCmd.AddCommand(configUnsetCmd)
+	configCmd.AddCommand(configListCmd)
+	configCmd.AddCommand(configEditCmd)
+	configCmd.AddCommand(configPathCmd)
+	rootCmd.AddCommand(configCmd)
+}
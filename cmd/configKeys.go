@@ -0,0 +1,337 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// configKey is a key that can be stored in the config file.
+type configKey struct {
+	name         string
+	description  string
+	defaultValue string
+	// flag is the name of the flag whose default value is taken from this key, if any.
+	flag string
+	// commands restricts flag to these commands. When empty, every command with the flag uses the key.
+	commands []string
+	// project reports whether the key can be set in a project's .gencli.yaml. A cloned repository can pin the model
+	// and how the responses are generated, but not where the requests and the API key are sent.
+	project  bool
+	validate func(value string) error
+}
+
+// configKeys lists the keys understood by gencli, in the order they are shown by 'gencli config list'.
+var configKeys = []configKey{
+	{
+		name:         "genai_model",
+		project:      true,
+		description:  "Model used to generate responses",
+		defaultValue: defaultModel,
+		validate:     validateModel,
+	},
+	{
+		name:         "provider",
+		description:  "GenAI backend: " + strings.Join(supportedProviders, ", "),
+		defaultValue: providerGemini,
+		validate: func(value string) error {
+			if !slices.Contains(supportedProviders, value) {
+				return fmt.Errorf("unknown provider %q, supported providers are: %s", value, strings.Join(supportedProviders, ", "))
+			}
+			return nil
+		},
+	},
+	{
+		name:        "base_url",
+		description: "URL of the provider's API",
+		validate: func(value string) error {
+			u, err := url.Parse(value)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("%q is not an http or https URL", value)
+			}
+			return nil
+		},
+	},
+	{
+		name:        "api_key_env",
+		description: "Environment variable holding the API key",
+		validate:    validateNotEmpty,
+	},
+	{
+		name:         "language",
+		project:      true,
+		description:  "Default output language",
+		defaultValue: defaultLanguage,
+		flag:         "language",
+		validate:     validateNotEmpty,
+	},
+	{
+		name:         "temperature",
+		project:      true,
+		description:  "Default response creativity (0.0-1.0)",
+		defaultValue: strconv.FormatFloat(float64(defaultTemperature), 'f', -1, 32),
+		flag:         "temperature",
+		validate: func(value string) error {
+			t, err := strconv.ParseFloat(value, 32)
+			if err != nil || t < 0 || t > 1 {
+				return fmt.Errorf("temperature must be a number between 0.0 and 1.0, got %q", value)
+			}
+			return nil
+		},
+	},
+	{
+		name:         "words",
+		project:      true,
+		description:  "Default number of words in a search response",
+		defaultValue: defaultWords,
+		flag:         "words",
+		commands:     []string{"search"},
+		validate: func(value string) error {
+			if n, err := strconv.Atoi(value); err != nil || n <= 0 {
+				return fmt.Errorf("words must be a positive number, got %q", value)
+			}
+			return nil
+		},
+	},
+	{
+		name:         "output",
+		description:  "Default file that search and image responses are saved to",
+		defaultValue: defaultOutputFile,
+		flag:         "output",
+		commands:     []string{"search", "image"},
+		validate:     validateNotEmpty,
+	},
+	{
+		name:         "delete_uploads",
+		description:  "Delete the attachments uploaded with the Gemini Files API once they have been used",
+		defaultValue: "false",
+		flag:         "delete-uploads",
+		validate:     validateBool,
+	},
+	{
+		name:         "strip_metadata",
+		description:  "Remove the metadata, such as the GPS location, of the images sent with the image commands or attached",
+		defaultValue: "true",
+		flag:         "strip-metadata",
+		commands:     []string{"search", "chat", "image", "batch", "detect", "extract"},
+		validate:     validateBool,
+	},
+	{
+		name:        "top_p",
+		project:     true,
+		description: "Default top-p of the responses (0.0-1.0), left to the model when not set",
+		flag:        "top-p",
+		validate: func(value string) error {
+			p, err := strconv.ParseFloat(value, 32)
+			if err != nil || p < 0 || p > 1 {
+				return fmt.Errorf("top_p must be a number between 0.0 and 1.0, got %q", value)
+			}
+			return nil
+		},
+	},
+	{
+		name:        "top_k",
+		project:     true,
+		description: "Default top-k of the responses, left to the model when not set",
+		flag:        "top-k",
+		validate:    validatePositiveInt32,
+	},
+	{
+		name:        "max_output_tokens",
+		project:     true,
+		description: "Default longest response, in tokens, left to the model when not set",
+		flag:        "max-output-tokens",
+		validate:    validatePositiveInt32,
+	},
+	{
+		name:        "stop",
+		project:     true,
+		description: "Default stop sequences of the responses, separated by commas",
+		flag:        "stop",
+		validate:    validateNotEmpty,
+	},
+	{
+		name:        "seed",
+		project:     true,
+		description: "Default seed of the sampling, random when not set",
+		flag:        "seed",
+		validate: func(value string) error {
+			if _, err := strconv.ParseInt(value, 10, 32); err != nil {
+				return fmt.Errorf("seed must be a whole number, got %q", value)
+			}
+			return nil
+		},
+	},
+	{
+		name:         "candidates",
+		project:      true,
+		description:  "Default number of responses generated by search and image",
+		defaultValue: "1",
+		flag:         "candidates",
+		commands:     []string{"search", "image"},
+		validate:     validatePositiveInt32,
+	},
+	{
+		name:        "persona",
+		project:     true,
+		description: "Persona whose system instruction is used when none is given with --system, --system-file or --persona",
+		validate: func(value string) error {
+			if !profileNamePattern.MatchString(value) {
+				return fmt.Errorf("invalid persona name %q, use only letters, digits, '-' and '_'", value)
+			}
+			return nil
+		},
+	},
+	{
+		name:        "system",
+		project:     true,
+		description: "System instruction used when none is given with --system, --system-file or --persona, in place of the persona key",
+		validate:    validateNotEmpty,
+	},
+	{
+		name:         "no_stdin",
+		description:  "Don't read the input piped to stdin, for scripts whose stdin is never closed",
+		defaultValue: "false",
+		flag:         "no-stdin",
+		validate:     validateBool,
+	},
+	{
+		name:         "max_retries",
+		description:  "Number of times a request is retried after a transient API error",
+		defaultValue: strconv.Itoa(defaultMaxRetries),
+		flag:         "max-retries",
+		validate: func(value string) error {
+			if n, err := strconv.Atoi(value); err != nil || n < 0 {
+				return fmt.Errorf("max_retries must be zero or a positive number, got %q", value)
+			}
+			return nil
+		},
+	},
+	{
+		name:         "retry_max_wait",
+		description:  "Longest wait between two retries",
+		defaultValue: defaultRetryMaxWait.String(),
+		flag:         "retry-max-wait",
+		validate: func(value string) error {
+			if d, err := time.ParseDuration(value); err != nil || d < 0 {
+				return fmt.Errorf("retry_max_wait must be a duration such as 30s, got %q", value)
+			}
+			return nil
+		},
+	},
+}
+
+// findConfigKey returns the known key called name, or an ErrInvalidInput error listing the known keys.
+func findConfigKey(name string) (configKey, error) {
+	for _, key := range configKeys {
+		if key.name == name {
+			return key, nil
+		}
+	}
+
+	names := make([]string, 0, len(configKeys))
+	for _, key := range configKeys {
+		names = append(names, key.name)
+	}
+	return configKey{}, fmt.Errorf("%w: unknown config key %q, known keys are: %s", ErrInvalidInput, name, strings.Join(names, ", "))
+}
+
+// configValue returns the value of key from the config, or its default value when it isn't set.
+func configValue(key configKey) string {
+	if value := GetConfigFunc(key.name); value != "" {
+		return value
+	}
+	return key.defaultValue
+}
+
+// validateConfig checks every known key set in the config and returns an ErrConfig error listing the invalid ones.
+func validateConfig() error {
+	var problems []string
+	for _, key := range configKeys {
+		if value := GetConfigFunc(key.name); value != "" {
+			if err := key.validate(value); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", key.name, err))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: invalid config values:\n  %s", ErrConfig, strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// applyConfigDefaults replaces the default value of cmd's flags with the value of their config key, unless the flag
+// was given on the command line.
+func applyConfigDefaults(cmd *cobra.Command) error {
+	for _, key := range configKeys {
+		if key.flag == "" || (len(key.commands) > 0 && !slices.Contains(key.commands, cmd.Name())) {
+			continue
+		}
+		flag := cmd.Flags().Lookup(key.flag)
+		value := GetConfigFunc(key.name)
+		if flag == nil || flag.Changed || value == "" {
+			continue
+		}
+
+		if err := key.validate(value); err != nil {
+			return fmt.Errorf("%w: %s in config file: %w", ErrConfig, key.name, err)
+		}
+		if err := setFlagDefault(flag, value); err != nil {
+			return fmt.Errorf("%w: %s in config file: %w", ErrConfig, key.name, err)
+		}
+	}
+	return nil
+}
+
+func setFlagDefault(flag *pflag.Flag, value string) error {
+	if err := flag.Value.Set(value); err != nil {
+		return err
+	}
+	flag.DefValue = flag.Value.String()
+	return nil
+}
+
+func validateNotEmpty(value string) error {
+	if strings.TrimSpace(value) == "" {
+		return errors.New("value can't be empty")
+	}
+	return nil
+}
+
+func validateBool(value string) error {
+	if _, err := strconv.ParseBool(value); err != nil {
+		return fmt.Errorf("value must be true or false, got %q", value)
+	}
+	return nil
+}
+
+func validatePositiveInt32(value string) error {
+	if n, err := strconv.ParseInt(value, 10, 32); err != nil || n < 1 {
+		return fmt.Errorf("value must be a positive number, got %q", value)
+	}
+	return nil
+}
+
+// validateModel rejects models that the Gemini API doesn't offer when Gemini is the configured provider.
+func validateModel(value string) error {
+	return validateModelFor(GetConfigFunc("provider"), value)
+}
+
+// validateModelFor rejects models that the Gemini API doesn't offer when provider is Gemini. Other providers accept
+// any model ID.
+func validateModelFor(provider, value string) error {
+	if err := validateNotEmpty(value); err != nil {
+		return err
+	}
+	if (provider == "" || provider == providerGemini) && !isGeminiModel(value) {
+		return fmt.Errorf("unknown Gemini model %q, run 'gencli model' to choose from the supported ones", value)
+	}
+	return nil
+}
@@ -1,16 +1,68 @@
 package cmd
 
 import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
 	"github.com/AlecAivazis/survey/v2"
+	"golang.org/x/term"
 )
 
 // The following function variables allow us to override their default implementations during testing.
 // This is useful for simulating user interactions and configuration behavior without relying on external
 // dependencies or actual user input.
 //   - surveyAskOne: References survey.AskOne, which can be replaced with a mock function to simulate user responses.
-//   - GetConfigFunc and UpdateConfigFunc: Reference the actual GetConfig and UpdateConfig functions,
+//   - GetConfigFunc, UpdateConfigFunc and RemoveConfigFunc: Reference the actual GetConfig, UpdateConfig and RemoveConfig functions,
 //     allowing tests to substitute them with in-memory versions or mocks.
+//   - isTerminal: Reports whether a file is an interactive terminal, which decides defaults such as streaming.
+//   - openTerminalFunc: Opens the terminal, to read chat messages when stdin has been piped.
+//   - runEditorFunc: Opens a file in the user's editor and waits for it to exit.
+//   - sleepFunc: Waits between retries of a failed request, so that tests don't have to wait for real.
+//   - geminiBaseURL: Sends the Gemini requests to a local server instead of the Gemini API. Otherwise the Gemini
+//     base_url is only taken from the user's config file or profile, never from a project file or the environment,
+//     because the Gemini API key is sent along with the requests.
 var surveyAskOne = survey.AskOne
 
 var GetConfigFunc = GetConfig
 var UpdateConfigFunc = UpdateConfig
+var RemoveConfigFunc = RemoveConfig
+
+var runEditorFunc = func(editor, file string) error {
+	// The editor may include arguments, such as "code --wait".
+	fields := strings.Fields(editor)
+	if len(fields) == 0 {
+		fields = []string{"vi"}
+	}
+	cmd := exec.Command(fields[0], append(fields[1:], file)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+var geminiBaseURL = ""
+
+var isTerminal = func(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
+
+var sleepFunc = func(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+var openTerminalFunc = func() (io.ReadCloser, error) {
+	if runtime.GOOS == "windows" {
+		return os.Open("CONIN$")
+	}
+	return os.Open("/dev/tty")
+}
@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/genai"
+)
+
+// The errors below describe why a command failed. Commands wrap the underlying error with one of them, for
+// example fmt.Errorf("%w: %w", ErrAuth, err), so that Execute can exit with the matching code.
+var (
+	ErrInvalidInput  = errors.New("invalid input")
+	ErrConfig        = errors.New("configuration error")
+	ErrAuth          = errors.New("authentication failed")
+	ErrRateLimited   = errors.New("rate limited")
+	ErrSafetyBlocked = errors.New("blocked by safety filters")
+	ErrAPI           = errors.New("API request failed")
+)
+
+// Exit codes returned by gencli. They are part of the CLI's interface, so existing values must not change.
+const (
+	ExitOK            = 0 // The command succeeded.
+	ExitError         = 1 // An unexpected error, such as failing to write the output file.
+	ExitInvalidInput  = 2 // Bad arguments or flags, an unknown command or an unreadable input file.
+	ExitConfig        = 3 // The config file can't be read or written, or holds an invalid value.
+	ExitAuth          = 4 // The API key is missing or was rejected.
+	ExitRateLimited   = 5 // The provider's rate limit or quota was exceeded.
+	ExitSafetyBlocked = 6 // The prompt or the response was blocked by the provider's safety filters.
+	ExitAPI           = 7 // Any other failure while talking to the provider.
+)
+
+// errorKinds maps every typed error to its exit code and the code used in JSON error output.
+var errorKinds = []struct {
+	err      error
+	exitCode int
+	name     string
+}{
+	{ErrInvalidInput, ExitInvalidInput, "invalid_input"},
+	{ErrConfig, ExitConfig, "config"},
+	{ErrAuth, ExitAuth, "auth"},
+	{ErrRateLimited, ExitRateLimited, "rate_limited"},
+	{ErrSafetyBlocked, ExitSafetyBlocked, "safety_blocked"},
+	{ErrAPI, ExitAPI, "api"},
+}
+
+// exitCode returns the exit code for err and the name of its kind.
+func exitCode(err error) (int, string) {
+	for _, kind := range errorKinds {
+		if errors.Is(err, kind.err) {
+			return kind.exitCode, kind.name
+		}
+	}
+	return ExitError, "error"
+}
+
+// printError writes err to w, either as a single "Error: ..." line or, with the json format, as a JSON object
+// that scripts can parse.
+func printError(w io.Writer, err error, format string) {
+	code, name := exitCode(err)
+	if format != "json" {
+		fmt.Fprintln(w, "Error:", err)
+		return
+	}
+
+	data, _ := json.Marshal(map[string]any{
+		"error": map[string]any{
+			"code":      name,
+			"exit_code": code,
+			"message":   err.Error(),
+		},
+	})
+	fmt.Fprintln(w, string(data))
+}
+
+// invalidInput wraps err so that it is reported as ErrInvalidInput.
+func invalidInput(err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", ErrInvalidInput, err)
+}
+
+// validArgs wraps an argument validator so that its failures are reported as ErrInvalidInput.
+func validArgs(fn cobra.PositionalArgs) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		return invalidInput(fn(cmd, args))
+	}
+}
+
+// unknownCommand rejects arguments given to a command that only groups subcommands, suggesting the closest ones.
+func unknownCommand(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+
+	message := fmt.Sprintf("unknown command %q for %q", args[0], cmd.CommandPath())
+	if cmd.SuggestionsMinimumDistance <= 0 {
+		cmd.SuggestionsMinimumDistance = 2
+	}
+	if suggestions := cmd.SuggestionsFor(args[0]); len(suggestions) > 0 {
+		message += "\n\nDid you mean this?\n\t" + strings.Join(suggestions, "\n\t")
+	}
+	return invalidInput(errors.New(message))
+}
+
+// classifyError wraps an error returned by a provider with the typed error matching its HTTP status.
+func classifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var apiErr genai.APIError
+	var statusErr *statusError
+	var code int
+	switch {
+	case errors.As(err, &apiErr):
+		code = apiErr.Code
+		// Gemini answers 400 rather than 401 when the API key itself is invalid.
+		if isInvalidAPIKey(apiErr) {
+			code = http.StatusUnauthorized
+		}
+	case errors.As(err, &statusErr):
+		code = statusErr.Code
+	default:
+		return fmt.Errorf("%w: %w", ErrAPI, err)
+	}
+
+	switch code {
+	case http.StatusBadRequest, http.StatusNotFound, http.StatusRequestEntityTooLarge:
+		return fmt.Errorf("%w: %w", ErrInvalidInput, err)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("%w: %w", ErrAuth, err)
+	case http.StatusTooManyRequests:
+		return fmt.Errorf("%w: %w", ErrRateLimited, err)
+	default:
+		return fmt.Errorf("%w: %w", ErrAPI, err)
+	}
+}
+
+func isInvalidAPIKey(err genai.APIError) bool {
+	for _, detail := range err.Details {
+		if detail["reason"] == "API_KEY_INVALID" {
+			return true
+		}
+	}
+	return false
+}
+
+// checkBlocked returns ErrSafetyBlocked when the prompt or the response was blocked by the safety filters.
+func checkBlocked(resp *genai.GenerateContentResponse) error {
+	if resp == nil {
+		return nil
+	}
+	if resp.PromptFeedback != nil && resp.PromptFeedback.BlockReason != "" {
+		return fmt.Errorf("%w: the prompt was blocked (%s)", ErrSafetyBlocked, resp.PromptFeedback.BlockReason)
+	}
+	for _, candidate := range resp.Candidates {
+		switch candidate.FinishReason {
+		case genai.FinishReasonSafety, genai.FinishReasonProhibitedContent, genai.FinishReasonBlocklist, genai.FinishReasonSPII, genai.FinishReasonImageSafety, genai.FinishReasonImageProhibitedContent:
+			return fmt.Errorf("%w: the response was blocked (%s)", ErrSafetyBlocked, candidate.FinishReason)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// EXIF tags read by gencli.
+const (
+	exifOrientationTag  = 0x0112
+	exifMakeTag         = 0x010F
+	exifModelTag        = 0x0110
+	exifDateTimeTag     = 0x0132
+	exifArtistTag       = 0x013B
+	exifSubIFDTag       = 0x8769
+	exifGPSTag          = 0x8825
+	exifDateOriginalTag = 0x9003
+	exifDateDigitalTag  = 0x9004
+	exifOwnerTag        = 0xA430
+	exifBodySerialTag   = 0xA431
+	exifLensSerialTag   = 0xA435
+	exifCameraSerialTag = 0xC62F
+)
+
+// jpegSegment is a metadata segment of a JPEG, such as APP1 holding EXIF data.
+type jpegSegment struct {
+	marker byte
+	// raw holds the whole segment, from its marker to its end.
+	raw []byte
+	// payload holds the segment without its marker and length.
+	payload []byte
+}
+
+// jpegSegments returns the segments of a JPEG that come before its image data, and the offset where the image data
+// starts. ok is false when data isn't a well-formed JPEG.
+func jpegSegments(data []byte) (segments []jpegSegment, scan int, ok bool) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, 0, false
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return nil, 0, false
+		}
+		marker := data[i+1]
+		if marker == 0xFF {
+			// Markers can be preceded by any number of 0xFF fill bytes.
+			i++
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 {
+			// The metadata segments all come before the image data.
+			return segments, i, true
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return nil, 0, false
+		}
+		segments = append(segments, jpegSegment{marker: marker, raw: data[i : i+2+length], payload: data[i+4 : i+2+length]})
+		i += 2 + length
+	}
+	return nil, 0, false
+}
+
+// jpegExif returns the TIFF data held by the EXIF segment of a JPEG, or nil when it has none.
+func jpegExif(data []byte) []byte {
+	segments, _, _ := jpegSegments(data)
+	for _, segment := range segments {
+		if tiff, ok := exifPayload(segment); ok {
+			return tiff
+		}
+	}
+	return nil
+}
+
+// exifPayload returns the TIFF data of segment when it is an EXIF segment.
+func exifPayload(segment jpegSegment) ([]byte, bool) {
+	if segment.marker != 0xE1 {
+		return nil, false
+	}
+	return bytes.CutPrefix(segment.payload, []byte("Exif\x00\x00"))
+}
+
+// exifIFD returns the entries of the IFD found at offset in the TIFF data of an EXIF segment, mapped to their 4-byte
+// value field. It returns nil when the data is invalid.
+func exifIFD(tiff []byte, offset int) map[uint16][]byte {
+	order := tiffByteOrder(tiff)
+	if order == nil || offset < 8 || offset+2 > len(tiff) {
+		return nil
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	entries := make(map[uint16][]byte, count)
+	for i := range count {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		entries[order.Uint16(tiff[entry:])] = tiff[entry+8 : entry+12]
+	}
+	return entries
+}
+
+// exifIFD0 returns the entries of the first IFD of the TIFF data of an EXIF segment, which describes the image.
+func exifIFD0(tiff []byte) map[uint16][]byte {
+	order := tiffByteOrder(tiff)
+	if order == nil {
+		return nil
+	}
+	return exifIFD(tiff, int(order.Uint32(tiff[4:])))
+}
+
+// tiffByteOrder returns the byte order of TIFF data, or nil when it isn't TIFF data.
+func tiffByteOrder(tiff []byte) binary.ByteOrder {
+	if len(tiff) < 8 {
+		return nil
+	}
+	switch string(tiff[:2]) {
+	case "II":
+		return binary.LittleEndian
+	case "MM":
+		return binary.BigEndian
+	}
+	return nil
+}
+
+// exifOrientation returns the orientation of a JPEG, from 1 (upright) to 8, as stored in its EXIF data. It returns
+// 1 when the orientation is missing or invalid.
+func exifOrientation(data []byte) int {
+	tiff := jpegExif(data)
+	value, ok := exifIFD0(tiff)[exifOrientationTag]
+	if !ok {
+		return 1
+	}
+	orientation := int(tiffByteOrder(tiff).Uint16(value))
+	if orientation < 1 || orientation > 8 {
+		return 1
+	}
+	return orientation
+}
+
+// orientationExifSegment returns an APP1 segment whose EXIF data only holds orientation, which keeps a JPEG upright
+// once the rest of its metadata has been removed.
+func orientationExifSegment(orientation int) []byte {
+	// A big-endian TIFF header, followed by an IFD with a single SHORT entry and no next IFD.
+	payload := []byte("Exif\x00\x00MM\x00\x2a\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00")
+	payload[25] = byte(orientation)
+	return append([]byte{0xFF, 0xE1, 0x00, byte(len(payload) + 2)}, payload...)
+}
+
+// describeExif lists the kinds of personal information found in the TIFF data of an EXIF segment, such as the GPS
+// location or the camera's serial number.
+func describeExif(tiff []byte) []string {
+	ifd0 := exifIFD0(tiff)
+	tags := map[uint16]bool{}
+	for tag := range ifd0 {
+		tags[tag] = true
+	}
+	if value, ok := ifd0[exifSubIFDTag]; ok {
+		for tag := range exifIFD(tiff, int(tiffByteOrder(tiff).Uint32(value))) {
+			tags[tag] = true
+		}
+	}
+
+	kinds := []struct {
+		name string
+		tags []uint16
+	}{
+		{"GPS location", []uint16{exifGPSTag}},
+		{"camera make and model", []uint16{exifMakeTag, exifModelTag}},
+		{"serial numbers", []uint16{exifBodySerialTag, exifLensSerialTag, exifCameraSerialTag}},
+		{"owner name", []uint16{exifOwnerTag, exifArtistTag}},
+		{"dates", []uint16{exifDateTimeTag, exifDateOriginalTag, exifDateDigitalTag}},
+	}
+	var found []string
+	for _, kind := range kinds {
+		for _, tag := range kind.tags {
+			if tags[tag] {
+				found = append(found, kind.name)
+				break
+			}
+		}
+	}
+	return found
+}
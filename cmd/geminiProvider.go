@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+// fileProcessingPollInterval is how often an uploaded file is checked until Gemini has processed it.
+var fileProcessingPollInterval = 2 * time.Second
+
+// geminiProvider sends requests to the Google Gemini API.
+type geminiProvider struct {
+	client *genai.Client
+}
+
+func newGeminiProvider(ctx context.Context) (Provider, error) {
+	keyEnv := apiKeyEnv(providerGemini)
+	apiKey := os.Getenv(keyEnv)
+	if apiKey == "" {
+		return nil, fmt.Errorf("%w: please set the %s environment variable. Check the https://github.com/Pradumnasaraf/gencli README for more information", ErrAuth, keyEnv)
+	}
+
+	baseURL := geminiBaseURL
+	if baseURL == "" {
+		// The API key is sent to the proxy, so it is only read from the files written by the user.
+		baseURL = userConfigValue("base_url")
+	}
+	client, err := genai.NewClient(ctx, &genai.ClientConfig{
+		APIKey:      apiKey,
+		Backend:     genai.BackendGeminiAPI,
+		HTTPOptions: genai.HTTPOptions{BaseURL: baseURL},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrConfig, err)
+	}
+	return &geminiProvider{client: client}, nil
+}
+
+func (p *geminiProvider) GenerateContent(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error) {
+	return p.client.Models.GenerateContent(ctx, model, contents, config)
+}
+
+func (p *geminiProvider) GenerateContentStream(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) iter.Seq2[*genai.GenerateContentResponse, error] {
+	return p.client.Models.GenerateContentStream(ctx, model, contents, config)
+}
+
+func (p *geminiProvider) CountTokens(ctx context.Context, model string, contents []*genai.Content) (int32, error) {
+	resp, err := p.client.Models.CountTokens(ctx, model, contents, nil)
+	if err != nil {
+		return 0, err
+	}
+	return resp.TotalTokens, nil
+}
+
+func (p *geminiProvider) ListModels(ctx context.Context) ([]string, error) {
+	var models []string
+	for model, err := range p.client.Models.All(ctx) {
+		if err != nil {
+			return nil, err
+		}
+		for _, action := range model.SupportedActions {
+			if action == "generateContent" {
+				models = append(models, strings.TrimPrefix(model.Name, "models/"))
+				break
+			}
+		}
+	}
+	return models, nil
+}
+
+// ModelLimits returns the output token limit reported by the Models API for model. The topK it reports is the
+// model's default rather than a maximum, and it doesn't report how many candidates or stop sequences a model accepts.
+func (p *geminiProvider) ModelLimits(ctx context.Context, model string) (modelLimits, error) {
+	m, err := p.client.Models.Get(ctx, model, nil)
+	if err != nil {
+		return modelLimits{}, err
+	}
+	return modelLimits{maxOutputTokens: m.OutputTokenLimit}, nil
+}
+
+// UploadFile uploads a file with the Files API and waits until Gemini has processed it, which takes a while for
+// videos.
+func (p *geminiProvider) UploadFile(ctx context.Context, path string, mimeType string) (*genai.File, error) {
+	file, err := p.client.Files.UploadFromPath(ctx, path, &genai.UploadFileConfig{
+		MIMEType:    mimeType,
+		DisplayName: filepath.Base(path),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for file.State == genai.FileStateProcessing {
+		if err := sleepFunc(ctx, fileProcessingPollInterval); err != nil {
+			return nil, err
+		}
+		if file, err = p.client.Files.Get(ctx, file.Name, nil); err != nil {
+			return nil, err
+		}
+	}
+	if file.State == genai.FileStateFailed {
+		message := "unknown error"
+		if file.Error != nil {
+			message = file.Error.Message
+		}
+		return nil, fmt.Errorf("processing %s failed: %s", filepath.Base(path), message)
+	}
+	return file, nil
+}
+
+func (p *geminiProvider) DeleteFile(ctx context.Context, name string) error {
+	_, err := p.client.Files.Delete(ctx, name, nil)
+	return err
+}
@@ -0,0 +1,267 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/genai"
+)
+
+// generation holds the generation options shared by the commands that generate content. Options that aren't given,
+// on the command line or in the config, are left to the model.
+var generation generationOptions
+
+// candidateCount is the --candidates flag of search and image, the only commands that print several responses.
+var candidateCount int
+
+// generationOptions holds the --top-p, --top-k, --max-output-tokens, --stop and --seed flags, and the system
+// instruction flags.
+type generationOptions struct {
+	topP            optionalFlag[float32]
+	topK            optionalFlag[int32]
+	maxOutputTokens optionalFlag[int32]
+	stop            []string
+	seed            optionalFlag[int32]
+	system          string
+	systemFile      string
+	persona         string
+	// instruction is the system instruction read from one of system, systemFile and persona, or from the config,
+	// by validateGeneration.
+	instruction string
+}
+
+// addGenerationFlags adds the generation options to cmd.
+func addGenerationFlags(cmd *cobra.Command) {
+	cmd.Flags().Var(&generation.topP, "top-p", "Only sample from the most likely tokens whose probabilities add up to this value (0.0-1.0)")
+	cmd.Flags().Var(&generation.topK, "top-k", "Only sample from this many of the most likely tokens")
+	cmd.Flags().Var(&generation.maxOutputTokens, "max-output-tokens", "Longest response, in tokens")
+	cmd.Flags().StringSliceVar(&generation.stop, "stop", nil, "Stop the response before this text, repeatable or separated by commas")
+	cmd.Flags().Var(&generation.seed, "seed", "Seed of the sampling, so that the same request gives the same response as far as possible")
+	cmd.Flags().StringVar(&generation.system, "system", "", "System instruction, telling the model who it is and how to answer")
+	cmd.Flags().StringVar(&generation.systemFile, "system-file", "", "File holding the system instruction")
+	cmd.Flags().StringVar(&generation.persona, "persona", "", "Persona whose system instruction is used, see 'gencli persona list'")
+}
+
+// addCandidatesFlag adds --candidates to cmd.
+func addCandidatesFlag(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&candidateCount, "candidates", 1, "Number of responses to generate and print")
+}
+
+// validateGeneration checks the generation options and candidates, the number of responses asked for, against the
+// limits of model, and reads the system instruction. Features missing from the provider, and values above the limits
+// of the model, are rejected when they are given as flags of cmd. When they come from the config, a warning is
+// printed and they are ignored instead, so that changing the provider or the model doesn't break every command.
+func validateGeneration(cmd *cobra.Command, provider, model string, candidates int) error {
+	instruction, err := systemInstruction()
+	if err != nil {
+		return err
+	}
+	generation.instruction = instruction
+
+	if generation.topP.set && (generation.topP.value < 0 || generation.topP.value > 1) {
+		return invalidInput(fmt.Errorf("--top-p must be between 0.0 and 1.0, got %v", generation.topP.value))
+	}
+	for _, option := range []struct {
+		name  string
+		value optionalFlag[int32]
+	}{{"top-k", generation.topK}, {"max-output-tokens", generation.maxOutputTokens}} {
+		if option.value.set && option.value.value < 1 {
+			return invalidInput(fmt.Errorf("--%s must be at least 1, got %d", option.name, option.value.value))
+		}
+	}
+	if slices.Contains(generation.stop, "") {
+		return invalidInput(errors.New("--stop can't be empty"))
+	}
+	if candidates < 1 {
+		return invalidInput(fmt.Errorf("--candidates must be at least 1, got %d", candidates))
+	}
+
+	// unsupported rejects the value of flag, or ignores it when it comes from the config. Only search and image take
+	// more than one candidate, from their --candidates flag, so candidateCount is the value to reset.
+	unsupported := func(flag string, err error) error {
+		if cmd.Flags().Changed(flag) {
+			return invalidInput(err)
+		}
+		fmt.Fprintf(os.Stderr, "Warning: %v, %s in the config is ignored\n", err, strings.ReplaceAll(flag, "-", "_"))
+		switch flag {
+		case "top-k":
+			generation.topK = optionalFlag[int32]{}
+		case "max-output-tokens":
+			generation.maxOutputTokens = optionalFlag[int32]{}
+		case "stop":
+			generation.stop = nil
+		case "candidates":
+			candidateCount = 1
+		}
+		return nil
+	}
+
+	switch provider {
+	case providerOpenAI:
+		if generation.topK.set {
+			return unsupported("top-k", errors.New("--top-k isn't supported by the openai provider"))
+		}
+		return nil
+	case providerOllama:
+		if candidates > 1 {
+			return unsupported("candidates", errors.New("--candidates isn't supported by the ollama provider"))
+		}
+		return nil
+	}
+
+	// The limits are only looked up when an option they apply to is given.
+	if !generation.topK.set && !generation.maxOutputTokens.set && len(generation.stop) == 0 && candidates == 1 {
+		return nil
+	}
+	limits := geminiModelLimits(cmd.Context(), model)
+	if generation.topK.set && limits.maxTopK > 0 && generation.topK.value > limits.maxTopK {
+		if err := unsupported("top-k", fmt.Errorf("--top-k can be at most %d with %s, got %d", limits.maxTopK, model, generation.topK.value)); err != nil {
+			return err
+		}
+	}
+	if generation.maxOutputTokens.set && limits.maxOutputTokens > 0 && generation.maxOutputTokens.value > limits.maxOutputTokens {
+		if err := unsupported("max-output-tokens", fmt.Errorf("--max-output-tokens can be at most %d with %s, got %d", limits.maxOutputTokens, model, generation.maxOutputTokens.value)); err != nil {
+			return err
+		}
+	}
+	if limits.maxStopSequences > 0 && len(generation.stop) > limits.maxStopSequences {
+		if err := unsupported("stop", fmt.Errorf("--stop can be given at most %d times with %s, got %d", limits.maxStopSequences, model, len(generation.stop))); err != nil {
+			return err
+		}
+	}
+	if limits.maxCandidates > 0 && int32(candidates) > limits.maxCandidates {
+		return unsupported("candidates", fmt.Errorf("--candidates can be at most %d with %s, got %d", limits.maxCandidates, model, candidates))
+	}
+	return nil
+}
+
+// modelLimitsTimeout is how long the Models API is given to report the limits of a model, which are only a check
+// made before the request.
+var modelLimitsTimeout = 5 * time.Second
+
+// geminiModelLimits returns the limits of model, as reported by the Models API when the provider can describe its
+// models, and as listed in geminiModels for the limits it doesn't report or when it can't be reached. The limits that
+// aren't known are zero.
+func geminiModelLimits(ctx context.Context, model string) modelLimits {
+	var limits modelLimits
+	if i := slices.IndexFunc(geminiModels, func(m geminiModel) bool { return m.id == model }); i >= 0 {
+		limits = geminiModels[i].limits
+	}
+
+	provider, err := newProviderFunc(ctx)
+	if err != nil {
+		return limits
+	}
+	describer, ok := provider.(modelDescriber)
+	if !ok {
+		return limits
+	}
+	ctx, cancel := context.WithTimeout(ctx, modelLimitsTimeout)
+	defer cancel()
+	reported, err := describer.ModelLimits(ctx, model)
+	if err != nil {
+		return limits
+	}
+	if reported.maxOutputTokens > 0 {
+		limits.maxOutputTokens = reported.maxOutputTokens
+	}
+	return limits
+}
+
+// apply sets the generation options that were given on config, creating it when nil.
+func (o *generationOptions) apply(config *genai.GenerateContentConfig) *genai.GenerateContentConfig {
+	if config == nil {
+		config = &genai.GenerateContentConfig{}
+	}
+	if o.topP.set {
+		config.TopP = genai.Ptr(o.topP.value)
+	}
+	if o.topK.set {
+		config.TopK = genai.Ptr(float32(o.topK.value))
+	}
+	if o.maxOutputTokens.set {
+		config.MaxOutputTokens = o.maxOutputTokens.value
+	}
+	config.StopSequences = o.stop
+	if o.seed.set {
+		config.Seed = genai.Ptr(o.seed.value)
+	}
+	// The command's own instruction, such as the output language, comes after the user's and isn't overridden.
+	if o.instruction != "" {
+		if config.SystemInstruction == nil {
+			config.SystemInstruction = genai.NewContentFromText(o.instruction, genai.RoleUser)
+		} else {
+			config.SystemInstruction.Parts = append([]*genai.Part{genai.NewPartFromText(o.instruction + "\n\n")}, config.SystemInstruction.Parts...)
+		}
+	}
+	return config
+}
+
+// candidatesText returns the text of every candidate of resp, each under a "Candidate N" heading when there are
+// several.
+func candidatesText(resp *genai.GenerateContentResponse) string {
+	if len(resp.Candidates) <= 1 {
+		return resp.Text()
+	}
+	sections := make([]string, 0, len(resp.Candidates))
+	for i, candidate := range resp.Candidates {
+		var text strings.Builder
+		if candidate.Content != nil {
+			for _, part := range candidate.Content.Parts {
+				if !part.Thought {
+					text.WriteString(part.Text)
+				}
+			}
+		}
+		sections = append(sections, fmt.Sprintf("### Candidate %d\n\n%s", i+1, strings.TrimSpace(text.String())))
+	}
+	return strings.Join(sections, "\n\n")
+}
+
+// optionalFlag is a number flag that records whether it was given, so that the model's own default is used
+// otherwise. It is also set by the config, see applyConfigDefaults.
+type optionalFlag[T int32 | float32] struct {
+	value T
+	set   bool
+}
+
+func (f *optionalFlag[T]) Set(s string) error {
+	var value T
+	switch any(value).(type) {
+	case float32:
+		n, err := strconv.ParseFloat(s, 32)
+		if err != nil {
+			return errors.New("must be a number")
+		}
+		value = T(n)
+	default:
+		n, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			return errors.New("must be a whole number")
+		}
+		value = T(n)
+	}
+	f.value, f.set = value, true
+	return nil
+}
+
+func (f *optionalFlag[T]) String() string {
+	if !f.set {
+		return ""
+	}
+	return fmt.Sprint(f.value)
+}
+
+func (f *optionalFlag[T]) Type() string {
+	if _, ok := any(f.value).(float32); ok {
+		return "float"
+	}
+	return "int"
+}
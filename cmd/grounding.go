@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"google.golang.org/genai"
+)
+
+// googleSearchTool lets Gemini search the web and ground its response on the results.
+var googleSearchTool = &genai.Tool{GoogleSearch: &genai.GoogleSearch{}}
+
+// citeSources returns the text of a grounded response with citation markers, such as [1] or [1][3], after every
+// claim supported by a web page, and the numbered list of those pages. sources is empty when the model didn't
+// search the web.
+func citeSources(resp *genai.GenerateContentResponse) (text, sources string) {
+	text = resp.Text()
+	if len(resp.Candidates) == 0 || resp.Candidates[0].GroundingMetadata == nil || resp.Candidates[0].Content == nil {
+		return text, ""
+	}
+	metadata := resp.Candidates[0].GroundingMetadata
+
+	// Sources are numbered in the order of the grounding chunks, a page found twice keeping its first number.
+	numbers := map[int]int{}
+	var list strings.Builder
+	var uris []string
+	for i, chunk := range metadata.GroundingChunks {
+		if chunk == nil || chunk.Web == nil || chunk.Web.URI == "" {
+			continue
+		}
+		if n := slices.Index(uris, chunk.Web.URI); n >= 0 {
+			numbers[i] = n + 1
+			continue
+		}
+		uris = append(uris, chunk.Web.URI)
+		numbers[i] = len(uris)
+		title := chunk.Web.Title
+		if title == "" {
+			title = chunk.Web.Domain
+		}
+		if title == "" {
+			fmt.Fprintf(&list, "%d. %s\n", len(uris), chunk.Web.URI)
+		} else {
+			fmt.Fprintf(&list, "%d. %s - %s\n", len(uris), title, chunk.Web.URI)
+		}
+	}
+	if len(uris) == 0 {
+		return text, ""
+	}
+
+	// Segments are measured in bytes from the start of their part, and text joins the text parts like
+	// GenerateContentResponse.Text does.
+	starts := map[int]int{}
+	ends := map[int]int{}
+	offset := 0
+	for i, part := range resp.Candidates[0].Content.Parts {
+		if part.Text == "" || part.Thought {
+			continue
+		}
+		starts[i] = offset
+		offset += len(part.Text)
+		ends[i] = offset
+	}
+
+	citations := map[int][]int{}
+	for _, support := range metadata.GroundingSupports {
+		if support == nil || support.Segment == nil {
+			continue
+		}
+		start, ok := starts[int(support.Segment.PartIndex)]
+		if !ok {
+			continue
+		}
+		pos := citationPosition(text, min(start+int(support.Segment.EndIndex), ends[int(support.Segment.PartIndex)]))
+		for _, chunk := range support.GroundingChunkIndices {
+			if n, ok := numbers[int(chunk)]; ok && !slices.Contains(citations[pos], n) {
+				citations[pos] = append(citations[pos], n)
+			}
+		}
+	}
+
+	positions := make([]int, 0, len(citations))
+	for pos := range citations {
+		positions = append(positions, pos)
+	}
+	// Markers are inserted from the end, so that the positions before them stay valid.
+	slices.Sort(positions)
+	for _, pos := range slices.Backward(positions) {
+		slices.Sort(citations[pos])
+		var marker strings.Builder
+		for _, n := range citations[pos] {
+			fmt.Fprintf(&marker, "[%d]", n)
+		}
+		text = text[:pos] + marker.String() + text[pos:]
+	}
+
+	return text, "\n\nSources:\n" + strings.TrimSuffix(list.String(), "\n")
+}
+
+// citationPosition moves the end of a cited segment past the Markdown emphasis closing it, so that a marker never
+// splits "**bold**" and the emphasis is still rendered, and onto the start of a character.
+func citationPosition(text string, pos int) int {
+	for pos < len(text) && !utf8.RuneStart(text[pos]) {
+		pos++
+	}
+	for pos < len(text) && (text[pos] == '*' || text[pos] == '_') {
+		pos++
+	}
+	return pos
+}
@@ -1,8 +1,12 @@
 package cmd
 
 import (
-	"log"
+	"fmt"
+	"maps"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/spf13/viper"
 )
@@ -14,64 +18,299 @@ var (
 	defaultModel   string = "gemini-2.5-pro"
 )
 
-func SetDefaultConfig() {
-	homeDir := getHomeDir()
+// Default values of the flags that can also be set in the config file, see configKeys.
+var (
+	defaultLanguage    string  = "english"
+	defaultTemperature float32 = 0.5
+	defaultWords       string  = "150"
+	defaultOutputFile  string  = "output.txt"
+)
+
+var (
+	projectConfigFile string = ".gencli.yaml"
+	envPrefix         string = "GENCLI_"
+)
 
-	if _, err := os.Stat(homeDir + "/" + configFileDir); os.IsNotExist(err) {
-		if err := os.Mkdir(homeDir+"/"+configFileDir, 0755); err != nil {
-			log.Fatalf("Error creating config directory: %v", err)
+// configLayer holds the values read from one place, such as a config file or the environment.
+type configLayer struct {
+	// origin describes where the values come from, for example "project:/src/app/.gencli.yaml".
+	origin string
+	values map[string]string
+}
+
+// configLayers holds the loaded values, from the highest precedence to the lowest: GENCLI_* environment
+// variables, the project's .gencli.yaml, the active profile and the user's config file. Flags take precedence over
+// all of them, see applyConfigDefaults, and the default values of configKeys are used when no layer has a key.
+var configLayers []configLayer
+
+// SetDefaultConfig creates the config file with the default model if it doesn't exist yet, and loads it together
+// with the other layers described by configLayers.
+func SetDefaultConfig() error {
+	configFile, err := configFilePath()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(configFile); os.IsNotExist(err) {
+		if err := writeConfigFile(configFile, func(settings map[string]any) { settings["genai_model"] = defaultModel }); err != nil {
+			return err
 		}
+	}
+	values, err := readConfigFile(configFile)
+	if err != nil {
+		return err
+	}
+	user := configLayer{origin: "user:" + configFile, values: values}
+	env := envConfigLayer()
+	// The environment and the user's file are loaded first, because they may select the profile.
+	configLayers = []configLayer{env, user}
 
-		configFilePath := homeDir + "/" + configFileDir
-		viper.SetConfigName(configFileName)
-		viper.SetConfigType(configFileType)
-		viper.AddConfigPath(configFilePath)
+	layers := []configLayer{env}
+	if file := findProjectConfig(); file != "" {
+		values, err := readConfigFile(file)
+		if err != nil {
+			return err
+		}
+		for _, key := range slices.Sorted(maps.Keys(values)) {
+			if i := slices.IndexFunc(configKeys, func(k configKey) bool { return k.name == key }); i < 0 || !configKeys[i].project {
+				warnConfigOnce(fmt.Sprintf("%s in %s is ignored, a project file can only set %s", key, file, strings.Join(projectConfigKeys(), ", ")))
+				delete(values, key)
+			}
+		}
+		layers = append(layers, configLayer{origin: "project:" + file, values: values})
+	}
 
-		viper.Set("genai_model", defaultModel)
-		if err := viper.WriteConfigAs(configFilePath + "/" + configFileName + "." + configFileType); err != nil {
-			log.Fatalf("Error writing config file: %v", err)
+	if profile := activeProfile(); profile != "" {
+		profileFile, err := profileFilePath(profile)
+		if err != nil {
+			return err
 		}
-		return
+		if _, err := os.Stat(profileFile); err != nil {
+			return fmt.Errorf("%w: %w %q, create it with 'gencli profile create %s'", ErrConfig, errUnknownProfile, profile, profile)
+		}
+		values, err := readConfigFile(profileFile)
+		if err != nil {
+			return err
+		}
+		layers = append(layers, configLayer{origin: "profile:" + profileFile, values: values})
 	}
+
+	configLayers = append(layers, user)
+	return nil
 }
 
-func UpdateConfig(key string, value string) {
-	homeDir := getHomeDir()
+// UpdateConfig sets key in the file of the active profile, or in the config file when no profile is active.
+func UpdateConfig(key string, value string) error {
+	file, err := activeConfigFile()
+	if err != nil {
+		return err
+	}
+	if err := writeConfigFile(file, func(settings map[string]any) { settings[key] = value }); err != nil {
+		return err
+	}
+	return SetDefaultConfig()
+}
+
+// RemoveConfig deletes key from the file of the active profile, or from the config file when no profile is active.
+func RemoveConfig(key string) error {
+	file, err := activeConfigFile()
+	if err != nil {
+		return err
+	}
+	if err := writeConfigFile(file, func(settings map[string]any) { delete(settings, key) }); err != nil {
+		return err
+	}
+	return SetDefaultConfig()
+}
 
-	configFilePath := homeDir + "/" + configFileDir
-	viper.Set(key, value)
+// writeConfigFile reads the settings of a single config file, lets update change them and writes them back. A new
+// Viper instance is used so that the values merged from other files aren't copied into it.
+func writeConfigFile(file string, update func(settings map[string]any)) error {
+	settings := map[string]any{}
+	if _, err := os.Stat(file); err == nil {
+		current := viper.New()
+		current.SetConfigFile(file)
+		current.SetConfigType(configFileType)
+		if err := current.ReadInConfig(); err != nil {
+			return fmt.Errorf("%w: reading %s: %w", ErrConfig, file, err)
+		}
+		settings = current.AllSettings()
+	}
+	update(settings)
+
+	v := viper.New()
+	v.SetConfigType(configFileType)
+	for key, value := range settings {
+		v.Set(key, value)
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return fmt.Errorf("%w: creating config directory: %w", ErrConfig, err)
+	}
+	if err := v.WriteConfigAs(file); err != nil {
+		return fmt.Errorf("%w: writing config file: %w", ErrConfig, err)
+	}
+	return nil
+}
+
+// configFilePath returns the path of the config file, ~/.gencli/config.yaml.
+func configFilePath() (string, error) {
+	homeDir, err := getHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, configFileDir, configFileName+"."+configFileType), nil
+}
 
-	if err := viper.WriteConfigAs(configFilePath + "/" + configFileName + "." + configFileType); err != nil {
-		log.Fatalf("Error writing config file: %v", err)
+// activeConfigFile returns the file that config changes are written to: the file of the active profile, or the
+// config file when no profile is active.
+func activeConfigFile() (string, error) {
+	if profile := activeProfile(); profile != "" {
+		return profileFilePath(profile)
 	}
+	return configFilePath()
 }
 
+// GetConfig returns the value of key from the first layer of configLayers that sets it.
 func GetConfig(key string) string {
-	// Ensure Viper has the correct config file set
-	if viper.ConfigFileUsed() == "" {
-		homeDir := getHomeDir()
-		configFilePath := homeDir + "/" + configFileDir + "/" + configFileName + "." + configFileType
-		viper.SetConfigFile(configFilePath)
-		viper.SetConfigType(configFileType)
+	value, _ := configOrigin(key)
+	return value
+}
 
-		if err := viper.ReadInConfig(); err != nil {
-			log.Fatalf("Error reading config file: %v", err)
+// configOrigin returns the value of key and the origin of the layer it was read from, or two empty strings when no
+// layer sets it.
+func configOrigin(key string) (string, string) {
+	for _, layer := range configLayers {
+		if value, ok := layer.values[key]; ok {
+			return value, layer.origin
 		}
 	}
+	return "", ""
+}
 
-	return viper.GetString(key)
+// userConfigValue returns the value of key from the active profile or the user's config file only, ignoring the
+// environment and the project file.
+func userConfigValue(key string) string {
+	for _, layer := range configLayers {
+		if !strings.HasPrefix(layer.origin, "user:") && !strings.HasPrefix(layer.origin, "profile:") {
+			continue
+		}
+		if value, ok := layer.values[key]; ok {
+			return value
+		}
+	}
+	return ""
 }
 
-func getHomeDir() string {
-	homeDir, err := os.UserHomeDir()
+// envConfigLayer returns the values set with GENCLI_* environment variables. GENCLI_TEMPERATURE sets temperature,
+// GENCLI_GENAI_MODEL sets genai_model, and so on. The keys of userOnlyConfigKeys are ignored.
+func envConfigLayer() configLayer {
+	values := map[string]string{}
+	for _, env := range os.Environ() {
+		name, value, _ := strings.Cut(env, "=")
+		key, ok := strings.CutPrefix(name, envPrefix)
+		if !ok || key == "" || value == "" {
+			continue
+		}
+		if key = strings.ToLower(key); slices.Contains(userOnlyConfigKeys, key) {
+			warnConfigOnce(fmt.Sprintf("%s is ignored, %s can only be set in your config file or a profile", name, key))
+			continue
+		}
+		values[key] = value
+	}
+	return configLayer{origin: "env", values: values}
+}
+
+// configWarnings holds the config warnings already printed, since the layers are read again whenever the config
+// changes.
+var configWarnings = map[string]bool{}
+
+// warnConfigOnce prints a warning about the config layers, unless it was already printed by this process.
+func warnConfigOnce(warning string) {
+	if configWarnings[warning] {
+		return
+	}
+	configWarnings[warning] = true
+	fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+}
+
+// userOnlyConfigKeys decide where the requests, and the API key with them, are sent. They are only read from the
+// user's config file and profiles, never from the environment or a project file.
+var userOnlyConfigKeys = []string{"provider", "base_url", "api_key_env", "current_profile"}
+
+// projectConfigKeys returns the keys that a project's .gencli.yaml can set.
+func projectConfigKeys() []string {
+	var names []string
+	for _, key := range configKeys {
+		if key.project {
+			names = append(names, key.name)
+		}
+	}
+	return names
+}
+
+// findProjectConfig returns the path of the .gencli.yaml file in the current directory or the closest of its
+// parents, or an empty string when there is none.
+func findProjectConfig() string {
+	dir, err := os.Getwd()
 	if err != nil {
-		log.Fatalf("Unable to get user home directory to create config file: %v", err)
+		return ""
+	}
+	for {
+		file := filepath.Join(dir, projectConfigFile)
+		if info, err := os.Stat(file); err == nil && !info.IsDir() {
+			return file
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// readConfigFile returns the values stored in a single config file.
+func readConfigFile(file string) (map[string]string, error) {
+	v := viper.New()
+	v.SetConfigFile(file)
+	v.SetConfigType(configFileType)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("%w: reading %s: %w", ErrConfig, file, err)
+	}
+
+	values := map[string]string{}
+	for _, key := range v.AllKeys() {
+		values[key] = v.GetString(key)
 	}
-	return homeDir
+	return values, nil
 }
 
-func CheckNilError(err error) {
+// saveResponseToFile writes a response to file, creating its directory if needed.
+func saveResponseToFile(file string, content string) error {
+	// Create directory if it doesn't exist
+	dir := filepath.Dir(file)
+	if dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(file, []byte(content), 0644)
+}
+
+func getHomeDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
 	if err != nil {
-		log.Fatal(err)
+		return "", fmt.Errorf("%w: unable to get user home directory to create config file: %w", ErrConfig, err)
+	}
+	return homeDir, nil
+}
+
+// editorCommand returns the editor chosen by the user with VISUAL or EDITOR, or vi when neither is set to a
+// non-blank value.
+func editorCommand() string {
+	if editor := strings.TrimSpace(os.Getenv("VISUAL")); editor != "" {
+		return editor
+	}
+	if editor := strings.TrimSpace(os.Getenv("EDITOR")); editor != "" {
+		return editor
 	}
+	return "vi"
 }
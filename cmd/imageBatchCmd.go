@@ -0,0 +1,401 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/genai"
+)
+
+const defaultBatchConcurrency = 4
+
+// batchOutputFormats lists the values of --output-format: a JSON object per line, CSV rows, or a text file next to
+// every image.
+var batchOutputFormats = []string{"jsonl", "csv", "txt"}
+
+// batchImageExtensions lists the extensions of the files captioned by 'image batch'.
+var batchImageExtensions = []string{".png", ".jpg", ".jpeg", ".webp", ".heic", ".heif", ".gif"}
+
+var (
+	batchPrompt       string
+	batchConcurrency  int
+	batchOutputFormat string
+	batchOutputFile   string
+	batchLanguage     string
+)
+
+// batchResult is a line of the JSONL output, and a row of the CSV output.
+type batchResult struct {
+	File    string `json:"file"`
+	Caption string `json:"caption"`
+}
+
+var imageBatchCmd = &cobra.Command{
+	Use:     "batch [directory] --prompt [prompt] --concurrency [workers] --output-format [jsonl|csv|txt]",
+	Example: "gencli image batch products --prompt 'Write alt text for this product image'\ngencli image batch products --prompt 'Describe this image' --output-format txt --concurrency 8",
+	Short:   "Ask the same question about every image in a directory",
+	Long:    "Ask the same question, such as a request for alt text, about every image found in a directory and its subdirectories, with several requests running at once. The answers are saved to a JSONL or CSV file, captions.jsonl or captions.csv in the directory by default, or to a .txt file next to every image, such as photo.jpg.txt for photo.jpg. A run can be resumed: the images that already have an answer are skipped. An image that fails is reported and the others are still processed.",
+	Args:    validArgs(cobra.ExactArgs(1)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir := args[0]
+		if strings.TrimSpace(batchPrompt) == "" {
+			return invalidInput(errors.New(`required flag "prompt" not set`))
+		}
+		if batchConcurrency < 1 {
+			return invalidInput(fmt.Errorf("--concurrency must be at least 1, got %d", batchConcurrency))
+		}
+		if !slices.Contains(batchOutputFormats, batchOutputFormat) {
+			return invalidInput(fmt.Errorf("unknown output format %q, supported formats are: %s", batchOutputFormat, strings.Join(batchOutputFormats, ", ")))
+		}
+		if err := validateImageOptions(); err != nil {
+			return err
+		}
+		if err := validateGeneration(cmd, GetConfigFunc("provider"), GetConfigFunc("genai_model"), 1); err != nil {
+			return err
+		}
+
+		files, err := findBatchImages(dir)
+		if err != nil {
+			return err
+		}
+		out, err := openBatchOutput(dir, batchOutputFormat, batchOutputFile)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+
+		var pending []string
+		for _, file := range files {
+			if !out.done(file) {
+				pending = append(pending, file)
+			}
+		}
+		skipped := len(files) - len(pending)
+		if len(pending) == 0 {
+			fmt.Printf("All %d images already have an answer in %s\n", len(files), out.location())
+			return nil
+		}
+
+		ctx := context.Background()
+		provider, err := newProviderFunc(ctx)
+		if err != nil {
+			return err
+		}
+
+		failed, firstErr := runImageBatch(ctx, provider, dir, pending, batchConcurrency, out)
+		fmt.Printf("Answered %d images, skipped %d already answered, %d failed. Output: %s\n", len(pending)-failed, skipped, failed, out.location())
+		if failed > 0 {
+			return fmt.Errorf("%d of %d images failed, run the command again to retry them. The first error was: %w", failed, len(pending), firstErr)
+		}
+		return nil
+	},
+}
+
+// runImageBatch asks the question about every file with concurrency requests at a time, and saves the answers to
+// out as they arrive, so that an interrupted run keeps its progress. Failures are printed and don't stop the other
+// requests. It returns the number of failures and the first of them.
+func runImageBatch(ctx context.Context, provider Provider, dir string, files []string, concurrency int, out *batchOutput) (int, error) {
+	jobs := make(chan string)
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		failed   int
+		firstErr error
+		finished int
+	)
+	for range min(concurrency, len(files)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range jobs {
+				caption, err := captionImage(ctx, provider, file)
+				if err == nil {
+					err = out.save(file, caption)
+				}
+
+				mu.Lock()
+				finished++
+				name := relativePath(dir, file)
+				if err != nil {
+					failed++
+					if firstErr == nil {
+						firstErr = err
+					}
+					fmt.Fprintf(os.Stderr, "[%d/%d] %s: Error: %v\n", finished, len(files), name, err)
+				} else {
+					fmt.Fprintf(os.Stderr, "[%d/%d] %s\n", finished, len(files), name)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, file := range files {
+		jobs <- file
+	}
+	close(jobs)
+	wg.Wait()
+	return failed, firstErr
+}
+
+// captionImage asks the batch question about a single image, prepared like the images of the image command.
+func captionImage(ctx context.Context, provider Provider, file string) (string, error) {
+	images, err := readImages([]string{file}, "", io.Discard)
+	if err != nil {
+		return "", err
+	}
+	parts := []*genai.Part{
+		genai.NewPartFromBytes(images[0].data, images[0].mimeType),
+		genai.NewPartFromText(batchPrompt + " in " + batchLanguage + " language"),
+	}
+	resp, err := generateContent(ctx, provider, GetConfigFunc("genai_model"), []*genai.Content{genai.NewContentFromParts(parts, genai.RoleUser)}, generation.apply(nil))
+	if err != nil {
+		return "", err
+	}
+	caption := strings.TrimSpace(resp.Text())
+	if caption == "" {
+		return "", fmt.Errorf("%w: empty response", ErrAPI)
+	}
+	return caption, nil
+}
+
+// findBatchImages returns the images found in dir and its subdirectories, sorted, recognised by their extension.
+func findBatchImages(dir string) ([]string, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, invalidInput(err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%w: %s is not a directory, use 'gencli image' for a single image", ErrInvalidInput, dir)
+	}
+
+	var files []string
+	err = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() && slices.Contains(batchImageExtensions, strings.ToLower(filepath.Ext(path))) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, invalidInput(err)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%w: no images found in %s, the supported extensions are: %s", ErrInvalidInput, dir, strings.Join(batchImageExtensions, ", "))
+	}
+	return files, nil
+}
+
+// batchOutput saves the answers of a batch. It is safe for concurrent use.
+type batchOutput struct {
+	format string
+	dir    string
+	// file is the JSONL or CSV file the answers are appended to, nil for txt.
+	file *os.File
+	csv  *csv.Writer
+	// answered holds the paths, relative to dir, of the images answered by previous runs.
+	answered map[string]bool
+	mu       sync.Mutex
+}
+
+// openBatchOutput opens the output of a batch over dir, and reads the answers it already holds so that they can be
+// skipped. path is the JSONL or CSV file, captions.jsonl or captions.csv in dir when empty.
+func openBatchOutput(dir, format, path string) (*batchOutput, error) {
+	out := &batchOutput{format: format, dir: dir, answered: map[string]bool{}}
+	if format == "txt" {
+		return out, nil
+	}
+	if path == "" {
+		path = filepath.Join(dir, "captions."+format)
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, invalidInput(err)
+	}
+	valid, err := out.readAnswered(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%w: reading %s: %w", ErrInvalidInput, path, err)
+	}
+	end, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if valid < end {
+		fmt.Fprintf(os.Stderr, "Warning: the last line of %s is incomplete, it is removed and its image is answered again\n", path)
+		if err := file.Truncate(valid); err != nil {
+			file.Close()
+			return nil, err
+		}
+		end = valid
+	}
+	// The answers are appended after the last line, which may have been left without its newline.
+	if end > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, end-1); err != nil {
+			file.Close()
+			return nil, err
+		}
+		if last[0] != '\n' {
+			end++
+			if _, err := file.WriteAt([]byte("\n"), end-1); err != nil {
+				file.Close()
+				return nil, err
+			}
+		}
+	}
+	if _, err := file.Seek(end, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	out.file = file
+
+	if format == "csv" {
+		out.csv = csv.NewWriter(file)
+		if info, err := file.Stat(); err == nil && info.Size() == 0 {
+			if err := out.writeCSV([]string{"file", "caption"}); err != nil {
+				file.Close()
+				return nil, err
+			}
+		}
+	}
+	return out, nil
+}
+
+// readAnswered reads the images answered by previous runs from r, and returns the length of the answers it could
+// read. The last line of a JSONL file can be left incomplete by a run that was killed while writing it, so it isn't
+// counted rather than failing, and its image is answered again. A line that can't be decoded anywhere else is an
+// error.
+func (o *batchOutput) readAnswered(r io.Reader) (valid int64, err error) {
+	if o.format == "csv" {
+		reader := csv.NewReader(r)
+		rows, err := reader.ReadAll()
+		if err != nil {
+			return 0, err
+		}
+		for i, row := range rows {
+			if i > 0 && len(row) == 2 {
+				o.answered[row[0]] = true
+			}
+		}
+		return reader.InputOffset(), nil
+	}
+
+	reader := bufio.NewReader(r)
+	var corrupt error
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			if corrupt != nil {
+				return 0, corrupt
+			}
+			var result batchResult
+			if corrupt = json.Unmarshal(line, &result); corrupt == nil {
+				o.answered[result.File] = true
+			}
+		}
+		if corrupt == nil {
+			valid += int64(len(line))
+		}
+		if err == io.EOF {
+			return valid, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// done reports whether file was answered by a previous run.
+func (o *batchOutput) done(file string) bool {
+	if o.format == "txt" {
+		_, err := os.Stat(sidecarPath(file))
+		return err == nil
+	}
+	return o.answered[relativePath(o.dir, file)]
+}
+
+func (o *batchOutput) save(file, caption string) error {
+	if o.format == "txt" {
+		return os.WriteFile(sidecarPath(file), []byte(caption+"\n"), 0644)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	result := batchResult{File: relativePath(o.dir, file), Caption: caption}
+	if o.format == "csv" {
+		return o.writeCSV([]string{result.File, result.Caption})
+	}
+	line, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	_, err = o.file.Write(append(line, '\n'))
+	return err
+}
+
+func (o *batchOutput) writeCSV(row []string) error {
+	if err := o.csv.Write(row); err != nil {
+		return err
+	}
+	o.csv.Flush()
+	return o.csv.Error()
+}
+
+// location describes where the answers are saved.
+func (o *batchOutput) location() string {
+	if o.file == nil {
+		return "a .txt file next to every image"
+	}
+	return o.file.Name()
+}
+
+func (o *batchOutput) Close() error {
+	if o.file == nil {
+		return nil
+	}
+	return o.file.Close()
+}
+
+// sidecarPath returns the path of the .txt file holding the answer about an image, photo.jpg.txt for photo.jpg. The
+// extension is kept so that photo.jpg and photo.png don't share a file, and so that an unrelated photo.txt isn't
+// mistaken for an answer.
+func sidecarPath(file string) string {
+	return file + ".txt"
+}
+
+// relativePath returns the path of file relative to dir, with forward slashes so that outputs are portable.
+func relativePath(dir, file string) string {
+	rel, err := filepath.Rel(dir, file)
+	if err != nil {
+		return filepath.ToSlash(file)
+	}
+	return filepath.ToSlash(rel)
+}
+
+func init() {
+	imageBatchCmd.Flags().StringVar(&batchPrompt, "prompt", "", "Question asked about every image")
+	imageBatchCmd.Flags().IntVarP(&batchConcurrency, "concurrency", "c", defaultBatchConcurrency, "Number of requests running at once")
+	imageBatchCmd.Flags().StringVar(&batchOutputFormat, "output-format", "jsonl", "Output format: "+strings.Join(batchOutputFormats, ", "))
+	imageBatchCmd.Flags().StringVarP(&batchOutputFile, "output", "o", "", "Output file for the jsonl and csv formats (default captions.jsonl or captions.csv in the directory)")
+	imageBatchCmd.Flags().StringVarP(&batchLanguage, "language", "l", defaultLanguage, "Enter the language for the output")
+	addImageFlags(imageBatchCmd)
+	addGenerationFlags(imageBatchCmd)
+	imageCmd.AddCommand(imageBatchCmd)
+}
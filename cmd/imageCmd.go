@@ -1,10 +1,14 @@
 package cmd
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -12,77 +16,315 @@ import (
 )
 
 var (
-	imageFilePath      string
+	imageFilePaths     []string
 	imageFileFormat    string
 	respOutputLanguage string
 	saveResponse       bool
 	saveResponseFile   string
 	modelTemp          float32
+	streamResponse     bool
+	imageMaxDimension  int
+	imageQuality       int
+	imageVerbose       bool
+	imageStripMetadata bool
+	// imageInput holds what was piped to stdin: the image itself with --path -, or context for the question.
+	imageInput []byte
 )
 
+// maxImagesSize is the largest total size of the images sent with a question, because they are sent inline.
+var maxImagesSize int64 = 20 << 20
+
+// imageFile is an image sent with the question.
+type imageFile struct {
+	name     string
+	data     []byte
+	mimeType string
+}
+
 var imageCmd = &cobra.Command{
-	Use:     "image [your question] --path [image path] --format [image format] --language [output language] --temperature [creativity] --save --output [output file]",
-	Example: "gencli image 'What this image is about?' --path cat.png --format png",
+	Use:     "image [your question] --path [image path]... --format [image format] --language [output language] --temperature [creativity] --save --output [output file]",
+	Example: "gencli image 'What this image is about?' --path cat.png\ngencli image 'What changed between these screenshots?' --path before.png --path after.png\ngencli image 'Which diagram matches the spec?' --path 'diagrams/*.png'\ncurl -s https://example.com/cat.png | gencli image 'What is this?' --path - --format png",
 	Short:   "Know details about an image (Please put your question in quotes)",
-	Long:    "Ask a question about an image and get a response. You need to provide the path of the image and the format of the image. The supported formats are jpg, jpeg, png, and gif.",
-	Args:    cobra.MinimumNArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
-		res := getApiResponseImageFunc(args)
-		if saveResponse {
-			// Create directory if it doesn't exist
-			dir := filepath.Dir(saveResponseFile)
-			if dir != "." {
-				err := os.MkdirAll(dir, 0755)
-				CheckNilError(err)
+	Long:    "Ask a question about an image and get a response. You need to provide the path of the image. Repeat --path, or give it a quoted glob, to ask about several images at once: they are labelled \"Image 1: before.png\", \"Image 2: after.png\" and so on, so that the answer can refer to them. The format of every image is detected from its content, and checked against --format when given. The supported formats are png, jpeg (or jpg), webp, heic, heif and gif. Their metadata, such as the GPS location and camera serial number found in the EXIF data of photos, is removed unless --strip-metadata=false is given. Large images, such as phone photos, can be shrunk before they are sent with --max-dimension and --quality, and --verbose prints the metadata removed and how much the images were reduced. Use --path - to read the image from stdin, otherwise anything piped to stdin is sent as context for the question.",
+	Args:    validArgs(cobra.MinimumNArgs(1)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(imageFilePaths) == 0 {
+			return invalidInput(errors.New(`required flag "path" not set`))
+		}
+		stdinPaths := 0
+		for _, path := range imageFilePaths {
+			if path == "-" {
+				stdinPaths++
+			}
+		}
+		fromStdin := stdinPaths > 0
+		if stdinPaths > 1 {
+			return invalidInput(errors.New("--path - can only be given once, stdin holds a single image"))
+		}
+		if err := validateImageOptions(); err != nil {
+			return err
+		}
+		if err := validateRenderMode(); err != nil {
+			return err
+		}
+		if err := validateGeneration(cmd, GetConfigFunc("provider"), GetConfigFunc("genai_model"), candidateCount); err != nil {
+			return err
+		}
+		if imageFileFormat != "" {
+			if _, err := normalizeImageFormat(imageFileFormat); err != nil {
+				return err
 			}
+		}
 
-			err := os.WriteFile(saveResponseFile, []byte(res), 0644)
-			CheckNilError(err)
-			fmt.Printf("Response saved to: %s\n", saveResponseFile)
+		// With --path - the image is read from stdin, otherwise anything piped is sent as context for the question.
+		limit, what := maxPipedTextSize, "input"
+		if fromStdin {
+			if noStdin {
+				return errPathStdinWithNoStdin
+			}
+			limit, what = maxPipedImageSize, "image"
+		}
+		input, err := readPipedInput(cmd.InOrStdin(), limit, what)
+		if err != nil {
+			return err
+		}
+		imageInput = input
+		if fromStdin && len(imageInput) == 0 {
+			return invalidInput(errors.New("--path - reads the image from stdin, but nothing was piped"))
+		}
+
+		// Several responses would be mixed up, so they aren't streamed.
+		streamed := streamResponse && candidateCount <= 1
+		var res string
+		if streamed {
+			res, err = streamApiResponseImageFunc(args, os.Stdout)
 		} else {
-			fmt.Println(res)
+			res, err = getApiResponseImageFunc(args)
+		}
+		if err != nil {
+			return err
 		}
+
+		if saveResponse {
+			if err := saveResponseToFile(saveResponseFile, renderResponse(res, fileRenderMode(renderMode))); err != nil {
+				return err
+			}
+			fmt.Printf("Response saved to: %s\n", saveResponseFile)
+		} else if !streamed {
+			fmt.Println(renderResponse(res, renderMode))
+		}
+		return nil
 	},
 }
 
 // This function is used to get the response from the GenAI API, and was created to allow for testing.
 var getApiResponseImageFunc = imageFunc
 
-func imageFunc(args []string) string {
-	userArgs := strings.Join(args[0:], " ")
+func imageFunc(args []string) (string, error) {
+	ctx := context.Background()
+	provider, contents, err := newImageRequest(ctx, args)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := generateContent(ctx, provider, GetConfigFunc("genai_model"), contents, imageConfig())
+	if err != nil {
+		return "", err
+	}
+
+	return candidatesText(resp), nil
+}
 
+// This function is used to stream the response from the GenAI API, and was created to allow for testing.
+var streamApiResponseImageFunc = streamImageFunc
+
+// streamImageFunc prints the response to w, rendered with --render, while it is being generated and returns the
+// complete Markdown.
+func streamImageFunc(args []string, w io.Writer) (string, error) {
 	ctx := context.Background()
-	client, err := genai.NewClient(ctx, &genai.ClientConfig{
-		APIKey:  os.Getenv("GOOGLE_API_KEY"),
-		Backend: genai.BackendGeminiAPI,
-	})
-	CheckNilError(err)
+	provider, contents, err := newImageRequest(ctx, args)
+	if err != nil {
+		return "", err
+	}
+
+	var full strings.Builder
+	stream := newMarkdownStream(w, renderMode)
+	for resp, err := range generateContentStream(ctx, provider, GetConfigFunc("genai_model"), contents, imageConfig()) {
+		if err != nil {
+			return "", err
+		}
+		full.WriteString(resp.Text())
+		if err := stream.Write(resp.Text()); err != nil {
+			return "", err
+		}
+	}
+	if err := stream.Flush(); err != nil {
+		return "", err
+	}
+
+	return full.String(), nil
+}
+
+// imageConfig returns the config of an image question, from --temperature, --candidates and the generation options.
+func imageConfig() *genai.GenerateContentConfig {
+	config := generation.apply(&genai.GenerateContentConfig{Temperature: genai.Ptr(modelTemp)})
+	if candidateCount > 1 {
+		config.CandidateCount = int32(candidateCount)
+	}
+	return config
+}
 
-	currentGenaiModel := GetConfigFunc("genai_model")
+func newImageRequest(ctx context.Context, args []string) (Provider, []*genai.Content, error) {
+	userArgs := strings.Join(args[0:], " ")
 
-	imgData, err := os.ReadFile(imageFilePath)
-	CheckNilError(err)
+	log := io.Discard
+	if imageVerbose {
+		log = os.Stderr
+	}
+	images, err := readImages(imageFilePaths, imageFileFormat, log)
+	if err != nil {
+		return nil, nil, err
+	}
+	input := ""
+	if !slices.Contains(imageFilePaths, "-") {
+		input = string(imageInput)
+	}
 
-	// Supports image + text input
-	parts := []*genai.Part{
-		genai.NewPartFromBytes(imgData, "image/"+imageFileFormat),
-		genai.NewPartFromText(userArgs + " in " + respOutputLanguage + " language"),
+	provider, err := newProviderFunc(ctx)
+	if err != nil {
+		return nil, nil, err
 	}
+
+	// Supports image + text input. Several images are labelled so that the answer can refer to them.
+	var parts []*genai.Part
+	for i, img := range images {
+		if len(images) > 1 {
+			parts = append(parts, genai.NewPartFromText(fmt.Sprintf("Image %d: %s", i+1, img.name)))
+		}
+		parts = append(parts, genai.NewPartFromBytes(img.data, img.mimeType))
+	}
+	if input != "" {
+		parts = append(parts, genai.NewPartFromText(input+"\n\n"))
+	}
+	parts = append(parts, genai.NewPartFromText(userArgs+" in "+respOutputLanguage+" language"))
 	contents := []*genai.Content{genai.NewContentFromParts(parts, genai.RoleUser)}
 
-	resp, err := client.Models.GenerateContent(ctx, currentGenaiModel, contents, nil)
-	CheckNilError(err)
+	return provider, contents, nil
+}
 
-	return resp.Text()
+// errPathStdinWithNoStdin is returned when --path - and --no-stdin are both given.
+var errPathStdinWithNoStdin = invalidInput(errors.New("--path - reads the image from stdin, it can't be used with --no-stdin"))
+
+// readStdinImage reads the image piped to stdin into imageInput, for the subcommands given --path -.
+func readStdinImage(cmd *cobra.Command) error {
+	if noStdin {
+		return errPathStdinWithNoStdin
+	}
+	input, err := readPipedInput(cmd.InOrStdin(), maxPipedImageSize, "image")
+	if err != nil {
+		return err
+	}
+	if len(input) == 0 {
+		return invalidInput(errors.New("--path - reads the image from stdin, but nothing was piped"))
+	}
+	imageInput = input
+	return nil
+}
+
+// addImageFlags adds the flags that decide how the images are prepared to cmd, see validateImageOptions.
+func addImageFlags(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&imageMaxDimension, "max-dimension", 0, "Shrink the images so that their longest side is at most this many pixels")
+	cmd.Flags().IntVar(&imageQuality, "quality", 0, "Re-encode the images as JPEG with this quality (1-100)")
+	cmd.Flags().BoolVar(&imageStripMetadata, "strip-metadata", true, "Remove the metadata of JPEG and PNG images, such as their GPS location, before sending them")
+}
+
+// validateImageOptions checks the values of the flags that decide how the images are prepared.
+func validateImageOptions() error {
+	if imageMaxDimension < 0 {
+		return invalidInput(fmt.Errorf("--max-dimension must be a positive number of pixels, got %d", imageMaxDimension))
+	}
+	if imageQuality < 0 || imageQuality > 100 {
+		return invalidInput(fmt.Errorf("--quality must be between 1 and 100, got %d", imageQuality))
+	}
+	return nil
+}
+
+// readImages reads the images given with --path, in order, expanding globs, detects their MIME type, removes their
+// metadata and shrinks them as asked by --max-dimension and --quality, reporting what was done to log. The image piped to stdin is used for -.
+func readImages(paths []string, format string, log io.Writer) ([]imageFile, error) {
+	var files []string
+	for _, path := range paths {
+		if path == "-" || !strings.ContainsAny(path, "*?[") {
+			files = append(files, path)
+			continue
+		}
+		matches, err := filepath.Glob(path)
+		if err != nil {
+			return nil, invalidInput(fmt.Errorf("invalid glob %q: %w", path, err))
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("%w: no image matches %s", ErrInvalidInput, path)
+		}
+		files = append(files, matches...)
+	}
+
+	images := make([]imageFile, 0, len(files))
+	var total int64
+	for _, file := range files {
+		img := imageFile{name: "stdin", data: imageInput}
+		if file != "-" {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return nil, invalidInput(err)
+			}
+			img.name, img.data = filepath.Base(file), data
+		}
+
+		mimeType, err := imageMIMEType(img.data, format)
+		if err != nil {
+			return nil, fmt.Errorf("%w (%s)", err, file)
+		}
+		img.mimeType = mimeType
+
+		stripped := false
+		if imageStripMetadata {
+			var removed []string
+			if img, removed, stripped = stripImageMetadata(img); stripped && len(removed) > 0 {
+				fmt.Fprintf(log, "%s: removed %s\n", img.name, strings.Join(removed, ", "))
+			} else if stripped {
+				fmt.Fprintf(log, "%s: no metadata found\n", img.name)
+			}
+		}
+
+		original := img
+		if img, err = prepareImage(img, imageMaxDimension, imageQuality, log); err != nil {
+			return nil, err
+		}
+		// Re-encoded images hold no metadata, so only the images sent as they are can still hold some.
+		if imageStripMetadata && !stripped && bytes.Equal(img.data, original.data) {
+			fmt.Fprintf(log, "Warning: metadata can't be removed from %s images, %s is sent with its metadata\n", strings.TrimPrefix(img.mimeType, "image/"), img.name)
+		}
+
+		total += int64(len(img.data))
+		if total > maxImagesSize {
+			return nil, fmt.Errorf("%w: the images are larger than the %s a request can hold", ErrInvalidInput, formatSize(maxImagesSize))
+		}
+		images = append(images, img)
+	}
+	return images, nil
 }
 
 func init() {
-	imageCmd.Flags().StringVarP(&imageFilePath, "path", "p", "", "Enter the image path")
-	imageCmd.Flags().StringVarP(&imageFileFormat, "format", "f", "jpeg", "Enter the image format (jpeg, png, etc.)")
-	imageCmd.Flags().StringVarP(&respOutputLanguage, "language", "l", "english", "Enter the language for the output")
-	imageCmd.Flags().Float32VarP(&modelTemp, "temperature", "t", 0.5, "Response creativity (0.0-1.0)")
+	imageCmd.Flags().StringArrayVarP(&imageFilePaths, "path", "p", nil, "Enter the image path or a quoted glob, repeat it to ask about several images, or - to read the image from stdin")
+	imageCmd.Flags().StringVarP(&imageFileFormat, "format", "f", "", "Image format, detected from the image when not given ("+strings.Join(imageFormats, ", ")+")")
+	imageCmd.Flags().StringVarP(&respOutputLanguage, "language", "l", defaultLanguage, "Enter the language for the output")
+	imageCmd.Flags().Float32VarP(&modelTemp, "temperature", "t", defaultTemperature, "Response creativity (0.0-1.0)")
 	imageCmd.Flags().BoolVarP(&saveResponse, "save", "s", false, "Save the output to a file")
-	imageCmd.Flags().StringVarP(&saveResponseFile, "output", "o", "output.txt", "Output file name")
-	errPathF := imageCmd.MarkFlagRequired("path")
-	CheckNilError(errPathF)
+	imageCmd.Flags().StringVarP(&saveResponseFile, "output", "o", defaultOutputFile, "Output file name")
+	addImageFlags(imageCmd)
+	imageCmd.Flags().BoolVarP(&imageVerbose, "verbose", "v", false, "Print the metadata removed from every image, and its original and sent size")
+	addGenerationFlags(imageCmd)
+	addCandidatesFlag(imageCmd)
+	addRenderFlags(imageCmd)
+	imageCmd.Flags().BoolVar(&streamResponse, "stream", isTerminal(os.Stdout), "Print the response while it is being generated, enabled by default on a terminal")
 }
@@ -0,0 +1,332 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	"google.golang.org/genai"
+)
+
+// detectOutputFormats lists the values of 'image detect --output-format'.
+var detectOutputFormats = []string{"table", "json"}
+
+// detectionSchema is the JSON schema of the response to 'image detect'. Gemini models are trained to return boxes
+// as [ymin, xmin, ymax, xmax], normalized to 0-1000.
+var detectionSchema = map[string]any{
+	"type": "array",
+	"items": map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"label": map[string]any{"type": "string"},
+			"box_2d": map[string]any{
+				"type":     "array",
+				"items":    map[string]any{"type": "integer"},
+				"minItems": 4,
+				"maxItems": 4,
+			},
+		},
+		"required": []string{"label", "box_2d"},
+	},
+}
+
+// boxColors are the colors of the boxes drawn by --annotate, used in turn.
+var boxColors = []color.RGBA{
+	{R: 0xE6, G: 0x19, B: 0x4B, A: 0xFF},
+	{R: 0x3C, G: 0xB4, B: 0x4B, A: 0xFF},
+	{R: 0x43, G: 0x63, B: 0xD8, A: 0xFF},
+	{R: 0xF5, G: 0x82, B: 0x31, A: 0xFF},
+	{R: 0x91, G: 0x1E, B: 0xB4, A: 0xFF},
+	{R: 0x46, G: 0xF0, B: 0xF0, A: 0xFF},
+}
+
+var (
+	detectImagePath    string
+	detectOutputFormat string
+	detectAnnotateFile string
+)
+
+// detection is an object found by 'image detect'.
+type detection struct {
+	Label string `json:"label"`
+	// Box is [ymin, xmin, ymax, xmax], normalized to 0-1000.
+	Box [4]int `json:"box_2d"`
+	// Pixels is the box in the pixels of the image, when its size is known.
+	Pixels *pixelBox `json:"pixels,omitempty"`
+}
+
+type pixelBox struct {
+	XMin int `json:"x_min"`
+	YMin int `json:"y_min"`
+	XMax int `json:"x_max"`
+	YMax int `json:"y_max"`
+}
+
+var imageDetectCmd = &cobra.Command{
+	Use:     "detect [what to find] --path [image path] --output-format [table|json] --annotate [output PNG]",
+	Example: "gencli image detect 'find all license plates' -p car.jpg\ngencli image detect 'people and bicycles' -p street.png --output-format json --annotate street-boxes.png",
+	Short:   "Find objects in an image and print their bounding boxes",
+	Long:    "Find the objects described by your question in an image, and print their labels and bounding boxes as a table or as JSON. The boxes are given in the pixels of the image, and as returned by the model: [ymin, xmin, ymax, xmax] normalized to 0-1000. With --annotate, a copy of the image with the boxes and labels drawn on is saved as a PNG. This needs a model that can detect objects, such as Gemini 2.0 and later.",
+	Args:    validArgs(cobra.MinimumNArgs(1)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if detectImagePath == "" {
+			return invalidInput(errors.New(`required flag "path" not set`))
+		}
+		if !slices.Contains(detectOutputFormats, detectOutputFormat) {
+			return invalidInput(fmt.Errorf("unknown output format %q, supported formats are: %s", detectOutputFormat, strings.Join(detectOutputFormats, ", ")))
+		}
+		if imageFileFormat != "" {
+			if _, err := normalizeImageFormat(imageFileFormat); err != nil {
+				return err
+			}
+		}
+		if err := validateImageOptions(); err != nil {
+			return err
+		}
+		if err := validateGeneration(cmd, GetConfigFunc("provider"), GetConfigFunc("genai_model"), 1); err != nil {
+			return err
+		}
+
+		var original []byte
+		if detectImagePath == "-" {
+			if err := readStdinImage(cmd); err != nil {
+				return err
+			}
+			original = imageInput
+		} else {
+			data, err := os.ReadFile(detectImagePath)
+			if err != nil {
+				return invalidInput(err)
+			}
+			original = data
+		}
+
+		// The image is decoded first, so that an image that can't be annotated, such as HEIC, fails before the request.
+		img, decodeErr := decodeOriented(original)
+		if detectAnnotateFile != "" && decodeErr != nil {
+			return fmt.Errorf("%w: the image can't be annotated: %w", ErrInvalidInput, decodeErr)
+		}
+
+		detections, err := detectObjectsFunc(strings.Join(args, " "))
+		if err != nil {
+			return err
+		}
+
+		// The boxes are normalized, so they apply to the original image even when a smaller one was sent.
+		if img != nil {
+			bounds := img.Bounds()
+			for i := range detections {
+				detections[i].Pixels = toPixels(detections[i].Box, bounds.Dx(), bounds.Dy())
+			}
+		}
+
+		if detectOutputFormat == "json" {
+			output, err := json.MarshalIndent(detections, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(output))
+		} else {
+			printDetections(os.Stdout, detections)
+		}
+
+		if detectAnnotateFile != "" {
+			if err := saveAnnotatedImage(detectAnnotateFile, img, detections); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Annotated image saved to: %s\n", detectAnnotateFile)
+		}
+		return nil
+	},
+}
+
+// This function is used to get the boxes from the GenAI API, and was created to allow for testing.
+var detectObjectsFunc = detectObjects
+
+// detectObjects asks the model for the boxes of the objects described by query, in the image given with --path.
+func detectObjects(query string) ([]detection, error) {
+	ctx := context.Background()
+	images, err := readImages([]string{detectImagePath}, imageFileFormat, io.Discard)
+	if err != nil {
+		return nil, err
+	}
+	img, err := uprightImage(images[0])
+	if err != nil {
+		return nil, err
+	}
+	provider, err := newProviderFunc(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := []*genai.Part{
+		genai.NewPartFromBytes(img.data, img.mimeType),
+		genai.NewPartFromText("Detect the following in the image: " + query + ". Return every match with a short label and its box_2d, as [ymin, xmin, ymax, xmax] normalized to 0-1000. Return an empty list when nothing matches."),
+	}
+	config := generation.apply(&genai.GenerateContentConfig{ResponseMIMEType: "application/json", ResponseJsonSchema: detectionSchema})
+	resp, err := generateContent(ctx, provider, GetConfigFunc("genai_model"), []*genai.Content{genai.NewContentFromParts(parts, genai.RoleUser)}, config)
+	if err != nil {
+		return nil, err
+	}
+	return parseDetections(resp.Text())
+}
+
+// parseDetections decodes the boxes returned by the model. Coordinates outside of 0-1000 are clamped and empty boxes
+// are dropped, because models sometimes return them.
+func parseDetections(text string) ([]detection, error) {
+	var detections []detection
+	if err := json.Unmarshal([]byte(stripCodeFence(text)), &detections); err != nil {
+		return nil, fmt.Errorf("%w: the model didn't return bounding boxes, try a model that can detect objects: %w", ErrAPI, err)
+	}
+
+	valid := make([]detection, 0, len(detections))
+	for _, d := range detections {
+		for i := range d.Box {
+			d.Box[i] = min(max(d.Box[i], 0), 1000)
+		}
+		if d.Box[0] < d.Box[2] && d.Box[1] < d.Box[3] {
+			valid = append(valid, d)
+		}
+	}
+	return valid, nil
+}
+
+// stripCodeFence removes the ```json fence that some models wrap JSON responses in.
+func stripCodeFence(text string) string {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "```") {
+		return text
+	}
+	_, text, _ = strings.Cut(text, "\n")
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(text), "```"))
+}
+
+// toPixels converts a normalized box into the pixels of an image of the given size.
+func toPixels(box [4]int, width, height int) *pixelBox {
+	return &pixelBox{
+		YMin: box[0] * height / 1000,
+		XMin: box[1] * width / 1000,
+		YMax: box[2] * height / 1000,
+		XMax: box[3] * width / 1000,
+	}
+}
+
+func printDetections(w io.Writer, detections []detection) {
+	if len(detections) == 0 {
+		fmt.Fprintln(w, "Nothing found")
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "LABEL\tX_MIN\tY_MIN\tX_MAX\tY_MAX\tBOX_2D")
+	for _, d := range detections {
+		box := fmt.Sprintf("[%d, %d, %d, %d]", d.Box[0], d.Box[1], d.Box[2], d.Box[3])
+		if d.Pixels == nil {
+			fmt.Fprintf(tw, "%s\t-\t-\t-\t-\t%s\n", d.Label, box)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%s\n", d.Label, d.Pixels.XMin, d.Pixels.YMin, d.Pixels.XMax, d.Pixels.YMax, box)
+	}
+	tw.Flush()
+}
+
+// decodeOriented decodes an image and turns it upright according to its EXIF orientation.
+func decodeOriented(data []byte) (image.Image, error) {
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if format == "jpeg" {
+		img = orientImage(img, exifOrientation(data))
+	}
+	return img, nil
+}
+
+// uprightImage re-encodes a JPEG whose EXIF orientation says it must be rotated or flipped, because the boxes are
+// mapped onto the upright image and the model may not apply the orientation itself. Other images are returned as is.
+func uprightImage(img imageFile) (imageFile, error) {
+	if orientation := exifOrientation(img.data); img.mimeType != "image/jpeg" || orientation < 2 || orientation > 8 {
+		return img, nil
+	}
+	upright, err := decodeOriented(img.data)
+	if err != nil {
+		return img, invalidInput(fmt.Errorf("decoding %s: %w", img.name, err))
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, upright, &jpeg.Options{Quality: defaultImageQuality}); err != nil {
+		return img, fmt.Errorf("encoding %s: %w", img.name, err)
+	}
+	img.data = buf.Bytes()
+	return img, nil
+}
+
+// saveAnnotatedImage saves a copy of img as a PNG, with the boxes and labels of detections drawn on.
+func saveAnnotatedImage(path string, img image.Image, detections []detection) error {
+	bounds := img.Bounds()
+	canvas := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(canvas, canvas.Bounds(), img, bounds.Min, draw.Src)
+
+	// The lines get thicker on large images, so that they stay visible.
+	thickness := max(2, min(bounds.Dx(), bounds.Dy())/250)
+	face := basicfont.Face7x13
+	for i, d := range detections {
+		c := image.NewUniform(boxColors[i%len(boxColors)])
+		box := image.Rect(d.Pixels.XMin, d.Pixels.YMin, d.Pixels.XMax, d.Pixels.YMax)
+		for _, side := range []image.Rectangle{
+			image.Rect(box.Min.X, box.Min.Y, box.Max.X, box.Min.Y+thickness),
+			image.Rect(box.Min.X, box.Max.Y-thickness, box.Max.X, box.Max.Y),
+			image.Rect(box.Min.X, box.Min.Y, box.Min.X+thickness, box.Max.Y),
+			image.Rect(box.Max.X-thickness, box.Min.Y, box.Max.X, box.Max.Y),
+		} {
+			draw.Draw(canvas, side.Intersect(canvas.Bounds()), c, image.Point{}, draw.Src)
+		}
+
+		// The label is written in white on a background of the box's color, above the box when there is room.
+		width := font.MeasureString(face, d.Label).Ceil() + 4
+		height := face.Height + 2
+		top := box.Min.Y - height
+		if top < 0 {
+			top = box.Min.Y
+		}
+		background := image.Rect(box.Min.X, top, box.Min.X+width, top+height)
+		draw.Draw(canvas, background.Intersect(canvas.Bounds()), c, image.Point{}, draw.Src)
+		drawer := &font.Drawer{
+			Dst:  canvas,
+			Src:  image.White,
+			Face: face,
+			Dot:  fixed.P(box.Min.X+2, top+face.Ascent+1),
+		}
+		drawer.DrawString(d.Label)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, canvas); err != nil {
+		return err
+	}
+	return saveResponseToFile(path, buf.String())
+}
+
+func init() {
+	imageDetectCmd.Flags().StringVarP(&detectImagePath, "path", "p", "", "Enter the image path, or - to read the image from stdin")
+	imageDetectCmd.Flags().StringVarP(&imageFileFormat, "format", "f", "", "Image format, detected from the image when not given ("+strings.Join(imageFormats, ", ")+")")
+	imageDetectCmd.Flags().StringVar(&detectOutputFormat, "output-format", "table", "Output format: "+strings.Join(detectOutputFormats, ", "))
+	imageDetectCmd.Flags().StringVar(&detectAnnotateFile, "annotate", "", "Save a PNG copy of the image with the boxes and labels drawn on")
+	addImageFlags(imageDetectCmd)
+	addGenerationFlags(imageDetectCmd)
+	imageCmd.AddCommand(imageDetectCmd)
+}
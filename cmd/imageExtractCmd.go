@@ -0,0 +1,276 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/genai"
+)
+
+// extractOutputFormats lists the values of 'image extract --output-format'.
+var extractOutputFormats = []string{"markdown", "csv", "json"}
+
+const defaultExtractInstructions = "Extract the table, receipt or form in this image"
+
+// tableSchema is the JSON Schema used by 'image extract' when no --schema is given: a table with named columns.
+const tableSchema = `{
+	"type": "object",
+	"properties": {
+		"columns": {"type": "array", "items": {"type": "string"}},
+		"rows": {"type": "array", "items": {"type": "array", "items": {"type": "string"}}}
+	},
+	"required": ["columns", "rows"]
+}`
+
+var (
+	extractImagePath    string
+	extractSchemaFile   string
+	extractOutputFormat string
+)
+
+var imageExtractCmd = &cobra.Command{
+	Use:     "extract [what to extract] --path [image path] --schema [JSON Schema file] --output-format [markdown|csv|json]",
+	Example: "gencli image extract -p whiteboard.jpg --output-format csv > whiteboard.csv\ngencli image extract 'the items and the total' -p receipt.jpg --schema receipt.schema.json --output-format json",
+	Short:   "Turn the table, receipt or form in an image into structured data",
+	Long:    "Read the table, receipt or form in an image and print its data as a Markdown table, CSV or JSON. By default the data is extracted as a table. Give --schema a JSON Schema file to choose the fields yourself: the model is asked for a response matching it, and the response is checked against it before it is printed, the model being asked again when it doesn't match. Arrays of objects become one row per object, and other objects a row per field.",
+	Args:    validArgs(cobra.ArbitraryArgs),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if extractImagePath == "" {
+			return invalidInput(errors.New(`required flag "path" not set`))
+		}
+		if !slices.Contains(extractOutputFormats, extractOutputFormat) {
+			return invalidInput(fmt.Errorf("unknown output format %q, supported formats are: %s", extractOutputFormat, strings.Join(extractOutputFormats, ", ")))
+		}
+		if imageFileFormat != "" {
+			if _, err := normalizeImageFormat(imageFileFormat); err != nil {
+				return err
+			}
+		}
+		if err := validateImageOptions(); err != nil {
+			return err
+		}
+		if err := validateGeneration(cmd, GetConfigFunc("provider"), GetConfigFunc("genai_model"), 1); err != nil {
+			return err
+		}
+
+		schema, err := compileJSONSchema("table.json", []byte(tableSchema))
+		if err != nil {
+			return err
+		}
+		if extractSchemaFile != "" {
+			if schema, err = loadJSONSchema(extractSchemaFile); err != nil {
+				return err
+			}
+		}
+		if extractImagePath == "-" {
+			if err := readStdinImage(cmd); err != nil {
+				return err
+			}
+		}
+
+		instructions := strings.Join(args, " ")
+		if instructions == "" {
+			instructions = defaultExtractInstructions
+		}
+		text, err := extractDataFunc(instructions, schema)
+		if err != nil {
+			return err
+		}
+		return printExtractedData(os.Stdout, text, extractOutputFormat)
+	},
+}
+
+// This function is used to get the data from the GenAI API, and was created to allow for testing.
+var extractDataFunc = extractData
+
+// extractData asks the model for the data of the image given with --path, as JSON matching schema, and returns it
+// once it matches.
+func extractData(instructions string, schema *jsonSchema) (string, error) {
+	ctx := context.Background()
+	images, err := readImages([]string{extractImagePath}, imageFileFormat, io.Discard)
+	if err != nil {
+		return "", err
+	}
+	provider, err := newProviderFunc(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	prompt := instructions + ". Transcribe the text and numbers exactly as they appear in the image."
+	if extractSchemaFile == "" {
+		prompt += " Return the data as a table: the column names in columns, and the cells of every row in rows."
+	}
+	parts := []*genai.Part{genai.NewPartFromBytes(images[0].data, images[0].mimeType), genai.NewPartFromText(prompt)}
+	return generateJSON(ctx, provider, GetConfigFunc("genai_model"), []*genai.Content{genai.NewContentFromParts(parts, genai.RoleUser)}, generation.apply(nil), schema)
+}
+
+// printExtractedData prints the JSON text as a Markdown table, CSV or indented JSON.
+func printExtractedData(w io.Writer, text, format string) error {
+	if format == "json" {
+		var out bytes.Buffer
+		if err := json.Indent(&out, []byte(text), "", "  "); err != nil {
+			return fmt.Errorf("%w: the response isn't valid JSON: %w", ErrAPI, err)
+		}
+		_, err := fmt.Fprintln(w, out.String())
+		return err
+	}
+
+	columns, rows, err := tabulate([]byte(text))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrAPI, err)
+	}
+	if format == "csv" {
+		writer := csv.NewWriter(w)
+		if err := writer.Write(columns); err != nil {
+			return err
+		}
+		if err := writer.WriteAll(rows); err != nil {
+			return err
+		}
+		return writer.Error()
+	}
+
+	escape := func(cell string) string {
+		return strings.NewReplacer("|", `\|`, "\r\n", "<br>", "\n", "<br>").Replace(cell)
+	}
+	writeRow := func(cells []string) {
+		escaped := make([]string, len(columns))
+		for i := range columns {
+			if i < len(cells) {
+				escaped[i] = escape(cells[i])
+			}
+		}
+		fmt.Fprintf(w, "| %s |\n", strings.Join(escaped, " | "))
+	}
+	writeRow(columns)
+	fmt.Fprintf(w, "|%s\n", strings.Repeat(" --- |", len(columns)))
+	for _, row := range rows {
+		writeRow(row)
+	}
+	return nil
+}
+
+// tabulate turns JSON data into a table. The {columns, rows} object of the default schema is used as is, arrays of
+// objects become a row per object with a column per field, and other objects a row per field. Nested values are
+// kept as JSON.
+func tabulate(data []byte) (columns []string, rows [][]string, err error) {
+	var table struct {
+		Columns []string `json:"columns"`
+		Rows    [][]any  `json:"rows"`
+	}
+	if err := json.Unmarshal(data, &table); err == nil && table.Columns != nil {
+		for _, row := range table.Rows {
+			rows = append(rows, formatCells(row))
+		}
+		return table.Columns, rows, nil
+	}
+
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err == nil {
+		for _, item := range items {
+			keys, values, err := orderedFields(item)
+			if err != nil {
+				// Arrays of values become a single column.
+				columns = []string{"value"}
+				rows = append(rows, formatCells([]any{json.RawMessage(item)}))
+				continue
+			}
+			for _, key := range keys {
+				if !slices.Contains(columns, key) {
+					columns = append(columns, key)
+				}
+			}
+			row := make([]any, len(columns))
+			for i, column := range columns {
+				row[i] = values[column]
+			}
+			rows = append(rows, formatCells(row))
+		}
+		// The objects read first may lack fields found later.
+		for i, row := range rows {
+			rows[i] = append(row, make([]string, max(len(columns)-len(row), 0))...)
+		}
+		return columns, rows, nil
+	}
+
+	keys, values, err := orderedFields(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("the response can't be turned into a table: %w", err)
+	}
+	for _, key := range keys {
+		rows = append(rows, []string{key, formatCells([]any{values[key]})[0]})
+	}
+	return []string{"field", "value"}, rows, nil
+}
+
+// orderedFields decodes a JSON object, returning its keys in the order they appear, which a map loses.
+func orderedFields(data []byte) ([]string, map[string]json.RawMessage, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return nil, nil, errors.New("not a JSON object")
+	}
+	var keys []string
+	values := map[string]json.RawMessage{}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		key := token.(string)
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, nil, err
+		}
+		if _, ok := values[key]; !ok {
+			keys = append(keys, key)
+		}
+		values[key] = value
+	}
+	return keys, values, nil
+}
+
+// formatCells formats JSON values as table cells: strings without their quotes, null as an empty cell, and other
+// values as compact JSON.
+func formatCells(values []any) []string {
+	cells := make([]string, len(values))
+	for i, value := range values {
+		raw, ok := value.(json.RawMessage)
+		if !ok {
+			raw, _ = json.Marshal(value)
+		}
+		var text string
+		switch {
+		case json.Unmarshal(raw, &text) == nil:
+			cells[i] = text
+		case raw == nil || string(raw) == "null":
+			cells[i] = ""
+		default:
+			var compact bytes.Buffer
+			if json.Compact(&compact, raw) == nil {
+				cells[i] = compact.String()
+			} else {
+				cells[i] = string(raw)
+			}
+		}
+	}
+	return cells
+}
+
+func init() {
+	imageExtractCmd.Flags().StringVarP(&extractImagePath, "path", "p", "", "Enter the image path, or - to read the image from stdin")
+	imageExtractCmd.Flags().StringVarP(&imageFileFormat, "format", "f", "", "Image format, detected from the image when not given ("+strings.Join(imageFormats, ", ")+")")
+	imageExtractCmd.Flags().StringVar(&extractSchemaFile, "schema", "", "JSON Schema file describing the fields to extract, a table by default")
+	imageExtractCmd.Flags().StringVar(&extractOutputFormat, "output-format", "markdown", "Output format: "+strings.Join(extractOutputFormats, ", "))
+	addImageFlags(imageExtractCmd)
+	addGenerationFlags(imageExtractCmd)
+	imageCmd.AddCommand(imageExtractCmd)
+}
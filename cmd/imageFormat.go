@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// imageFormats lists the image formats Gemini understands, by the name given to --format, in the order they are shown
+// in error messages.
+var imageFormats = []string{"png", "jpeg", "webp", "heic", "heif", "gif"}
+
+// heifBrands maps the brands found in the header of HEIF files to their format. HEIC files are HEIF files holding
+// HEVC images.
+var heifBrands = map[string]string{
+	"heic": "heic",
+	"heix": "heic",
+	"heim": "heic",
+	"heis": "heic",
+	"hevc": "heic",
+	"hevx": "heic",
+	"mif1": "heif",
+	"msf1": "heif",
+	"heif": "heif",
+}
+
+// normalizeImageFormat turns the value of --format into one of imageFormats, accepting common spellings such as jpg,
+// PNG or image/webp. Unsupported formats are rejected with the list of the supported ones.
+func normalizeImageFormat(format string) (string, error) {
+	name := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(format)), "image/")
+	name = strings.TrimPrefix(name, ".")
+	if name == "jpg" {
+		name = "jpeg"
+	}
+	if !slices.Contains(imageFormats, name) {
+		return "", fmt.Errorf("%w: unsupported image format %q, supported formats are: %s", ErrInvalidInput, format, strings.Join(imageFormats, ", "))
+	}
+	return name, nil
+}
+
+// detectImageFormat returns the format of the image in data, found from its first bytes, or an empty string when it
+// isn't one of imageFormats. The second value is the sniffed MIME type, to tell users what the data looks like.
+func detectImageFormat(data []byte) (string, string) {
+	// http.DetectContentType doesn't know HEIF, whose files start with an ftyp box naming their brand.
+	if len(data) >= 12 && bytes.Equal(data[4:8], []byte("ftyp")) {
+		if format, ok := heifBrands[string(data[8:12])]; ok {
+			return format, "image/" + format
+		}
+	}
+
+	// Nor TIFF, which isn't supported but must be reported as an image rather than as unknown data.
+	if bytes.HasPrefix(data, []byte("II*\x00")) || bytes.HasPrefix(data, []byte("MM\x00*")) {
+		return "", "image/tiff"
+	}
+
+	sniffed, _, _ := strings.Cut(http.DetectContentType(data), ";")
+	if format, ok := strings.CutPrefix(sniffed, "image/"); ok && slices.Contains(imageFormats, format) {
+		return format, sniffed
+	}
+	return "", sniffed
+}
+
+// imageMIMEType returns the MIME type of the image in data. The format is detected from the data and, when format is
+// given, checked against it, so that a PNG isn't sent as a JPEG. format is only trusted for HEIC and HEIF when the data
+// isn't recognised at all, as can happen with unusual HEIF files; anything else, such as a BMP or a text file, is
+// rejected instead of being sent with a wrong MIME type.
+func imageMIMEType(data []byte, format string) (string, error) {
+	detected, sniffed := detectImageFormat(data)
+	if format == "" {
+		if detected == "" {
+			return "", fmt.Errorf("%w: the image format can't be detected (the data looks like %s), supported formats are: %s", ErrInvalidInput, sniffed, strings.Join(imageFormats, ", "))
+		}
+		return "image/" + detected, nil
+	}
+
+	format, err := normalizeImageFormat(format)
+	if err != nil {
+		return "", err
+	}
+	if detected == "" {
+		if sniffed != "application/octet-stream" || (format != "heic" && format != "heif") {
+			return "", fmt.Errorf("%w: --format is %s but the data looks like %s, supported formats are: %s", ErrInvalidInput, format, sniffed, strings.Join(imageFormats, ", "))
+		}
+		return "image/" + format, nil
+	}
+	if detected != format {
+		return "", fmt.Errorf("%w: --format is %s but the image is a %s, fix --format or leave it out to detect the format", ErrInvalidInput, format, detected)
+	}
+	return "image/" + format, nil
+}
@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// pngSignature starts every PNG file.
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// pngMetadataChunks maps the PNG chunks that hold metadata to what they hold. Chunks needed to display the image,
+// such as its color profile, are kept.
+var pngMetadataChunks = map[string]string{
+	"eXIf": "EXIF",
+	"tEXt": "text",
+	"zTXt": "text",
+	"iTXt": "text",
+	"tIME": "modification time",
+}
+
+// stripImageMetadata removes the metadata, such as EXIF data with the GPS location and camera serial number, of a
+// JPEG or PNG image, without re-encoding it. The orientation of a JPEG is kept, so that it stays upright. It returns
+// the stripped image and a description of what was removed. ok is false for the other formats, whose metadata can't
+// be removed.
+func stripImageMetadata(img imageFile) (stripped imageFile, removed []string, ok bool) {
+	var data []byte
+	switch img.mimeType {
+	case "image/jpeg":
+		data, removed, ok = stripJPEGMetadata(img.data)
+	case "image/png":
+		data, removed, ok = stripPNGMetadata(img.data)
+	}
+	if !ok {
+		return img, nil, false
+	}
+	img.data = data
+	return img, removed, true
+}
+
+func stripJPEGMetadata(data []byte) ([]byte, []string, bool) {
+	segments, scan, ok := jpegSegments(data)
+	if !ok {
+		return data, nil, false
+	}
+
+	var removed []string
+	kept := [][]byte{data[:2]}
+	for _, segment := range segments {
+		switch {
+		case segment.marker == 0xE1:
+			if tiff, ok := exifPayload(segment); ok {
+				removed = append(removed, describeMetadata("EXIF", describeExif(tiff)))
+				if orientation := exifOrientation(data); orientation != 1 {
+					kept = append(kept, orientationExifSegment(orientation))
+				}
+			} else if bytes.HasPrefix(segment.payload, []byte("http://ns.adobe.com/xap/1.0/")) {
+				removed = append(removed, "XMP")
+			} else {
+				removed = append(removed, "APP1 data")
+			}
+		case segment.marker == 0xE2 && bytes.HasPrefix(segment.payload, []byte("MPF\x00")):
+			// The index of the secondary images, which are removed below.
+			removed = append(removed, "MPF")
+		case segment.marker == 0xED:
+			removed = append(removed, "IPTC")
+		case segment.marker == 0xFE:
+			removed = append(removed, "comment")
+		case segment.marker >= 0xE3 && segment.marker <= 0xEF && segment.marker != 0xEE:
+			// APP0 (JFIF), APP2 (color profile) and APP14 (Adobe color transform) are needed to display the image.
+			removed = append(removed, fmt.Sprintf("APP%d data", segment.marker-0xE0))
+		default:
+			kept = append(kept, segment.raw)
+		}
+	}
+	// Anything after the image, such as the secondary images of an MPF file with their own EXIF data, is dropped.
+	end := jpegEnd(data, scan)
+	if end < len(data) {
+		removed = append(removed, "data after the end of the image")
+	}
+	if len(removed) == 0 {
+		return data, nil, true
+	}
+	kept = append(kept, data[scan:end])
+	return bytes.Join(kept, nil), compactMetadata(removed), true
+}
+
+// jpegEnd returns the offset just after the EOI marker that ends the image whose data starts at scan, or len(data)
+// when it isn't found. The segments between the scans of progressive JPEGs are skipped by their length, because they
+// can hold bytes that look like an EOI marker.
+func jpegEnd(data []byte, scan int) int {
+	for i := scan; i+1 < len(data); {
+		if data[i] != 0xFF {
+			i++
+			continue
+		}
+		switch marker := data[i+1]; {
+		case marker == 0xD9:
+			return i + 2
+		case marker == 0x00 || marker == 0xFF || (marker >= 0xD0 && marker <= 0xD7):
+			// Stuffed bytes, fill bytes and restart markers are part of the image data.
+			i++
+		default:
+			if i+4 > len(data) {
+				return len(data)
+			}
+			length := int(binary.BigEndian.Uint16(data[i+2:]))
+			if length < 2 {
+				return len(data)
+			}
+			i += 2 + length
+		}
+	}
+	return len(data)
+}
+
+func stripPNGMetadata(data []byte) ([]byte, []string, bool) {
+	if !bytes.HasPrefix(data, []byte(pngSignature)) {
+		return data, nil, false
+	}
+
+	var removed, keywords []string
+	kept := [][]byte{data[:len(pngSignature)]}
+	for i := len(pngSignature); i < len(data); {
+		if i+12 > len(data) {
+			return data, nil, false
+		}
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		if length > len(data)-i-12 {
+			return data, nil, false
+		}
+		chunkType := string(data[i+4 : i+8])
+		chunk := data[i : i+12+length]
+		i += 12 + length
+
+		kind, ok := pngMetadataChunks[chunkType]
+		if !ok {
+			kept = append(kept, chunk)
+			continue
+		}
+		if kind == "text" {
+			// Text chunks start with a keyword, such as Author or XML:com.adobe.xmp for XMP data.
+			keyword, _, _ := bytes.Cut(chunk[8:8+length], []byte{0})
+			keywords = append(keywords, string(keyword))
+		}
+		removed = append(removed, kind)
+	}
+	if len(removed) == 0 {
+		return data, nil, true
+	}
+
+	removed = compactMetadata(removed)
+	if i := slices.Index(removed, "text"); i >= 0 {
+		removed[i] = describeMetadata("text", compactMetadata(keywords))
+	}
+	return bytes.Join(kept, nil), removed, true
+}
+
+// describeMetadata formats a kind of metadata with its details, for example "EXIF (GPS location, dates)".
+func describeMetadata(kind string, details []string) string {
+	if len(details) == 0 {
+		return kind
+	}
+	return kind + " (" + strings.Join(details, ", ") + ")"
+}
+
+// compactMetadata removes the repeated descriptions, keeping their order.
+func compactMetadata(descriptions []string) []string {
+	var unique []string
+	for _, description := range descriptions {
+		if !slices.Contains(unique, description) {
+			unique = append(unique, description)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // Registers the GIF decoder used by image.DecodeConfig and image.Decode.
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // Registers the WebP decoder used by image.Decode.
+)
+
+// defaultImageQuality is the JPEG quality used when an image is re-encoded without --quality.
+const defaultImageQuality = 85
+
+// prepareImage shrinks img so that neither of its sides is longer than maxDimension, and re-encodes it, as JPEG
+// when quality is given. Nothing is done when both are 0. The image is sent as is when its format can't be decoded,
+// such as HEIC, or when re-encoding doesn't make it smaller. GIFs are sent as they are too, because re-encoding them
+// would only keep their first frame. What was done is written to log.
+func prepareImage(img imageFile, maxDimension, quality int, log io.Writer) (imageFile, error) {
+	if maxDimension == 0 && quality == 0 {
+		return img, nil
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(img.data))
+	if err != nil {
+		fmt.Fprintf(log, "%s: %s images can't be re-encoded, sent as is (%s)\n", img.name, strings.TrimPrefix(img.mimeType, "image/"), formatSize(int64(len(img.data))))
+		return img, nil
+	}
+	if format == "gif" {
+		fmt.Fprintf(log, "%s: %dx%d, gif images are sent as is to keep their animation (%s)\n", img.name, config.Width, config.Height, formatSize(int64(len(img.data))))
+		return img, nil
+	}
+	resize := maxDimension > 0 && max(config.Width, config.Height) > maxDimension
+	if !resize && quality == 0 {
+		fmt.Fprintf(log, "%s: %dx%d, sent as is (%s)\n", img.name, config.Width, config.Height, formatSize(int64(len(img.data))))
+		return img, nil
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(img.data))
+	if err != nil {
+		return img, invalidInput(fmt.Errorf("decoding %s: %w", img.name, err))
+	}
+	if format == "jpeg" {
+		// Re-encoding drops the EXIF orientation, so it is applied to the pixels instead.
+		src = orientImage(src, exifOrientation(img.data))
+	}
+	if resize {
+		src = scaleImage(src, maxDimension)
+	}
+
+	var buf bytes.Buffer
+	mimeType := "image/jpeg"
+	if quality == 0 && format == "png" {
+		// Lossless images, such as screenshots, stay lossless and keep their transparency.
+		mimeType = "image/png"
+		err = png.Encode(&buf, src)
+	} else {
+		if quality == 0 {
+			quality = defaultImageQuality
+		}
+		err = jpeg.Encode(&buf, flattenImage(src), &jpeg.Options{Quality: quality})
+	}
+	if err != nil {
+		return img, fmt.Errorf("encoding %s: %w", img.name, err)
+	}
+
+	bounds := src.Bounds()
+	if !resize && buf.Len() >= len(img.data) {
+		fmt.Fprintf(log, "%s: re-encoding doesn't make it smaller, sent as is (%s)\n", img.name, formatSize(int64(len(img.data))))
+		return img, nil
+	}
+	fmt.Fprintf(log, "%s: %dx%d %s, sent as %dx%d %s (%s)\n", img.name, config.Width, config.Height, formatSize(int64(len(img.data))),
+		bounds.Dx(), bounds.Dy(), formatSize(int64(buf.Len())), strings.TrimPrefix(mimeType, "image/"))
+	img.data, img.mimeType = buf.Bytes(), mimeType
+	return img, nil
+}
+
+// scaleImage shrinks src, keeping its aspect ratio, so that its longest side is maxDimension pixels long.
+func scaleImage(src image.Image, maxDimension int) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width >= height {
+		width, height = maxDimension, max(1, height*maxDimension/width)
+	} else {
+		width, height = max(1, width*maxDimension/height), maxDimension
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+	return dst
+}
+
+// orientImage rotates and flips src as told by an EXIF orientation, so that it is upright.
+func orientImage(src image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if orientation >= 5 {
+		// Orientations 5 to 8 swap the width and the height.
+		width, height = height, width
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range bounds.Dy() {
+		for x := range bounds.Dx() {
+			var dx, dy int
+			switch orientation {
+			case 2: // Flipped horizontally.
+				dx, dy = bounds.Dx()-1-x, y
+			case 3: // Rotated 180°.
+				dx, dy = bounds.Dx()-1-x, bounds.Dy()-1-y
+			case 4: // Flipped vertically.
+				dx, dy = x, bounds.Dy()-1-y
+			case 5: // Transposed.
+				dx, dy = y, x
+			case 6: // Rotated 90° clockwise.
+				dx, dy = bounds.Dy()-1-y, x
+			case 7: // Transversed.
+				dx, dy = bounds.Dy()-1-y, bounds.Dx()-1-x
+			case 8: // Rotated 90° counter-clockwise.
+				dx, dy = y, bounds.Dx()-1-x
+			}
+			dst.Set(dx, dy, src.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+// flattenImage draws src on a white background, because JPEG has no transparency and would turn it black.
+func flattenImage(src image.Image) image.Image {
+	if opaque, ok := src.(interface{ Opaque() bool }); ok && opaque.Opaque() {
+		return src
+	}
+	bounds := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), src, bounds.Min, draw.Over)
+	return dst
+}
@@ -0,0 +1,223 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"net/http"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+const defaultOllamaBaseURL = "http://localhost:11434"
+
+// ollamaProvider sends requests to a local Ollama server, so gencli can be used without network access.
+type ollamaProvider struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+func newOllamaProvider(baseURL string) (Provider, error) {
+	if baseURL == "" {
+		baseURL = defaultOllamaBaseURL
+	}
+	return &ollamaProvider{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: &http.Client{},
+	}, nil
+}
+
+type ollamaMessage struct {
+	Role    string   `json:"role"`
+	Content string   `json:"content"`
+	Images  []string `json:"images,omitempty"`
+}
+
+type ollamaOptions struct {
+	Temperature *float32 `json:"temperature,omitempty"`
+	TopP        *float32 `json:"top_p,omitempty"`
+	TopK        *float32 `json:"top_k,omitempty"`
+	NumPredict  int32    `json:"num_predict,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+	Seed        *int32   `json:"seed,omitempty"`
+}
+
+type ollamaRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+	Options  *ollamaOptions  `json:"options,omitempty"`
+	// Format is "json", or the JSON schema the response must match.
+	Format any `json:"format,omitempty"`
+}
+
+type ollamaResponse struct {
+	Message         ollamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	DoneReason      string        `json:"done_reason"`
+	PromptEvalCount int32         `json:"prompt_eval_count"`
+	EvalCount       int32         `json:"eval_count"`
+}
+
+func (p *ollamaProvider) GenerateContent(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error) {
+	var full strings.Builder
+	var last *genai.GenerateContentResponse
+	for resp, err := range p.chat(ctx, model, contents, config, false) {
+		if err != nil {
+			return nil, err
+		}
+		full.WriteString(resp.Text())
+		last = resp
+	}
+	if last == nil {
+		return nil, errors.New("ollama returned an empty response")
+	}
+
+	last.Candidates[0].Content = genai.NewContentFromText(full.String(), genai.RoleModel)
+	return last, nil
+}
+
+func (p *ollamaProvider) GenerateContentStream(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) iter.Seq2[*genai.GenerateContentResponse, error] {
+	return p.chat(ctx, model, contents, config, true)
+}
+
+// chat calls /api/chat. Ollama answers with one JSON object per line, which is a single line when streaming is off.
+func (p *ollamaProvider) chat(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig, stream bool) iter.Seq2[*genai.GenerateContentResponse, error] {
+	return func(yield func(*genai.GenerateContentResponse, error) bool) {
+		data, err := json.Marshal(newOllamaRequest(model, contents, config, stream))
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/api/chat", bytes.NewReader(data))
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		body, err := sendRequest(p.httpClient, req, providerOllama)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		defer body.Close()
+
+		scanner := bufio.NewScanner(body)
+		scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+
+			var chunk ollamaResponse
+			if err := json.Unmarshal(line, &chunk); err != nil {
+				yield(nil, fmt.Errorf("decoding ollama response: %w", err))
+				return
+			}
+			if !yield(chunk.toGenai(), nil) || chunk.Done {
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			yield(nil, err)
+		}
+	}
+}
+
+// CountTokens has no Ollama endpoint, so it can't be answered by this provider.
+func (p *ollamaProvider) CountTokens(ctx context.Context, model string, contents []*genai.Content) (int32, error) {
+	return 0, errors.New("token counting is not supported by the ollama provider")
+}
+
+// ListModels returns the models that have been pulled into the local Ollama server.
+func (p *ollamaProvider) ListModels(ctx context.Context) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL+"/api/tags", nil)
+	if err != nil {
+		return nil, err
+	}
+	body, err := sendRequest(p.httpClient, req, providerOllama)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var resp struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(body).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("decoding ollama models: %w", err)
+	}
+
+	models := make([]string, 0, len(resp.Models))
+	for _, model := range resp.Models {
+		models = append(models, model.Name)
+	}
+	return models, nil
+}
+
+// newOllamaRequest converts a genai request into an /api/chat request. Images are sent base64-encoded in the
+// message's "images" field.
+func newOllamaRequest(model string, contents []*genai.Content, config *genai.GenerateContentConfig, stream bool) *ollamaRequest {
+	req := &ollamaRequest{Model: model, Stream: stream}
+
+	if config != nil {
+		if config.SystemInstruction != nil {
+			req.Messages = append(req.Messages, ollamaMessage{Role: "system", Content: contentText(config.SystemInstruction)})
+		}
+		req.Options = &ollamaOptions{
+			Temperature: config.Temperature,
+			TopP:        config.TopP,
+			TopK:        config.TopK,
+			NumPredict:  config.MaxOutputTokens,
+			Stop:        config.StopSequences,
+			Seed:        config.Seed,
+		}
+		if config.ResponseJsonSchema != nil {
+			req.Format = config.ResponseJsonSchema
+		} else if config.ResponseMIMEType == "application/json" {
+			req.Format = "json"
+		}
+	}
+
+	for _, content := range contents {
+		message := ollamaMessage{Role: "user", Content: contentText(content)}
+		if content.Role == genai.RoleModel {
+			message.Role = "assistant"
+		}
+		for _, part := range content.Parts {
+			if part.InlineData != nil {
+				message.Images = append(message.Images, base64.StdEncoding.EncodeToString(part.InlineData.Data))
+			}
+		}
+		req.Messages = append(req.Messages, message)
+	}
+	return req
+}
+
+func (r *ollamaResponse) toGenai() *genai.GenerateContentResponse {
+	resp := &genai.GenerateContentResponse{
+		Candidates: []*genai.Candidate{{Content: genai.NewContentFromText(r.Message.Content, genai.RoleModel)}},
+	}
+	if r.Done {
+		resp.Candidates[0].FinishReason = genai.FinishReasonStop
+		if r.DoneReason == "length" {
+			resp.Candidates[0].FinishReason = genai.FinishReasonMaxTokens
+		}
+		resp.UsageMetadata = &genai.GenerateContentResponseUsageMetadata{
+			PromptTokenCount:     r.PromptEvalCount,
+			CandidatesTokenCount: r.EvalCount,
+			TotalTokenCount:      r.PromptEvalCount + r.EvalCount,
+		}
+	}
+	return resp
+}
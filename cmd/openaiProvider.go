@@ -0,0 +1,303 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"net/http"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+const defaultOpenAIBaseURL = "https://api.openai.com/v1"
+
+// openaiProvider sends requests to a server implementing the OpenAI chat completions API, for example
+// OpenAI itself or an internal model served behind a compatible "/v1/chat/completions" endpoint.
+type openaiProvider struct {
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+}
+
+func newOpenAIProvider(baseURL string, apiKey string) (Provider, error) {
+	if baseURL == "" {
+		baseURL = defaultOpenAIBaseURL
+	}
+	return &openaiProvider{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		apiKey:     apiKey,
+		httpClient: &http.Client{},
+	}, nil
+}
+
+type openaiMessage struct {
+	Role    string `json:"role"`
+	Content any    `json:"content"`
+}
+
+type openaiContentPart struct {
+	Type     string          `json:"type"`
+	Text     string          `json:"text,omitempty"`
+	ImageURL *openaiImageURL `json:"image_url,omitempty"`
+}
+
+type openaiImageURL struct {
+	URL string `json:"url"`
+}
+
+type openaiRequest struct {
+	Model       string          `json:"model"`
+	Messages    []openaiMessage `json:"messages"`
+	Stream      bool            `json:"stream,omitempty"`
+	Temperature *float32        `json:"temperature,omitempty"`
+	TopP        *float32        `json:"top_p,omitempty"`
+	MaxTokens   int32           `json:"max_tokens,omitempty"`
+	Stop        []string        `json:"stop,omitempty"`
+	Seed        *int32          `json:"seed,omitempty"`
+	N           int32           `json:"n,omitempty"`
+	// ResponseFormat asks for JSON, matching a schema when one is given.
+	ResponseFormat *openaiResponseFormat `json:"response_format,omitempty"`
+}
+
+type openaiResponseFormat struct {
+	Type       string            `json:"type"`
+	JSONSchema *openaiJSONSchema `json:"json_schema,omitempty"`
+}
+
+type openaiJSONSchema struct {
+	Name   string `json:"name"`
+	Schema any    `json:"schema"`
+}
+
+type openaiChoice struct {
+	Index        int32         `json:"index"`
+	Message      openaiMessage `json:"message"`
+	Delta        openaiMessage `json:"delta"`
+	FinishReason string        `json:"finish_reason"`
+}
+
+type openaiResponse struct {
+	Choices []openaiChoice `json:"choices"`
+	Usage   *struct {
+		PromptTokens     int32 `json:"prompt_tokens"`
+		CompletionTokens int32 `json:"completion_tokens"`
+		TotalTokens      int32 `json:"total_tokens"`
+	} `json:"usage"`
+}
+
+func (p *openaiProvider) GenerateContent(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error) {
+	body, err := p.post(ctx, "/chat/completions", newOpenAIRequest(model, contents, config, false))
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var resp openaiResponse
+	if err := json.NewDecoder(body).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("decoding openai response: %w", err)
+	}
+	return resp.toGenai(false), nil
+}
+
+func (p *openaiProvider) GenerateContentStream(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) iter.Seq2[*genai.GenerateContentResponse, error] {
+	return func(yield func(*genai.GenerateContentResponse, error) bool) {
+		body, err := p.post(ctx, "/chat/completions", newOpenAIRequest(model, contents, config, true))
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		defer body.Close()
+
+		// The response is a stream of server-sent events, one "data:" line per chunk.
+		scanner := bufio.NewScanner(body)
+		scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data:")
+			if !ok {
+				continue
+			}
+			data = strings.TrimSpace(data)
+			if data == "[DONE]" {
+				return
+			}
+
+			var chunk openaiResponse
+			if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+				yield(nil, fmt.Errorf("decoding openai stream chunk: %w", err))
+				return
+			}
+			if !yield(chunk.toGenai(true), nil) {
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			yield(nil, err)
+		}
+	}
+}
+
+// CountTokens isn't part of the chat completions API, so it can't be answered by this provider.
+func (p *openaiProvider) CountTokens(ctx context.Context, model string, contents []*genai.Content) (int32, error) {
+	return 0, errors.New("token counting is not supported by the openai provider")
+}
+
+func (p *openaiProvider) ListModels(ctx context.Context) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL+"/models", nil)
+	if err != nil {
+		return nil, err
+	}
+	body, err := p.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var resp struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(body).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("decoding openai models: %w", err)
+	}
+
+	models := make([]string, 0, len(resp.Data))
+	for _, model := range resp.Data {
+		models = append(models, model.ID)
+	}
+	return models, nil
+}
+
+func (p *openaiProvider) post(ctx context.Context, path string, payload any) (io.ReadCloser, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return p.do(req)
+}
+
+// do sends req and returns the response body, or a *statusError when the server doesn't answer with 2xx.
+func (p *openaiProvider) do(req *http.Request) (io.ReadCloser, error) {
+	if p.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+	return sendRequest(p.httpClient, req, providerOpenAI)
+}
+
+// newOpenAIRequest converts a genai request into a chat completions request. Images and other inline data are
+// sent as "image_url" parts holding a data URI.
+func newOpenAIRequest(model string, contents []*genai.Content, config *genai.GenerateContentConfig, stream bool) *openaiRequest {
+	req := &openaiRequest{Model: model, Stream: stream}
+
+	if config != nil {
+		if config.SystemInstruction != nil {
+			req.Messages = append(req.Messages, openaiMessage{Role: "system", Content: contentText(config.SystemInstruction)})
+		}
+		req.Temperature = config.Temperature
+		req.TopP = config.TopP
+		req.MaxTokens = config.MaxOutputTokens
+		req.Stop = config.StopSequences
+		req.Seed = config.Seed
+		req.N = config.CandidateCount
+		if config.ResponseJsonSchema != nil {
+			req.ResponseFormat = &openaiResponseFormat{Type: "json_schema", JSONSchema: &openaiJSONSchema{Name: "response", Schema: config.ResponseJsonSchema}}
+		} else if config.ResponseMIMEType == "application/json" {
+			req.ResponseFormat = &openaiResponseFormat{Type: "json_object"}
+		}
+	}
+
+	for _, content := range contents {
+		role := "user"
+		if content.Role == genai.RoleModel {
+			role = "assistant"
+		}
+		req.Messages = append(req.Messages, openaiMessage{Role: role, Content: openaiMessageContent(content)})
+	}
+	return req
+}
+
+// openaiMessageContent returns the text of content as a plain string, which every compatible server accepts,
+// and only switches to a list of typed parts when the content carries images.
+func openaiMessageContent(content *genai.Content) any {
+	hasData := false
+	for _, part := range content.Parts {
+		if part.InlineData != nil {
+			hasData = true
+		}
+	}
+	if !hasData {
+		return contentText(content)
+	}
+
+	var parts []openaiContentPart
+	for _, part := range content.Parts {
+		switch {
+		case part.InlineData != nil:
+			uri := "data:" + part.InlineData.MIMEType + ";base64," + base64.StdEncoding.EncodeToString(part.InlineData.Data)
+			parts = append(parts, openaiContentPart{Type: "image_url", ImageURL: &openaiImageURL{URL: uri}})
+		case part.Text != "":
+			parts = append(parts, openaiContentPart{Type: "text", Text: part.Text})
+		}
+	}
+	return parts
+}
+
+// contentText joins the text parts of content.
+func contentText(content *genai.Content) string {
+	var sb strings.Builder
+	for _, part := range content.Parts {
+		sb.WriteString(part.Text)
+	}
+	return sb.String()
+}
+
+func (r *openaiResponse) toGenai(stream bool) *genai.GenerateContentResponse {
+	resp := &genai.GenerateContentResponse{}
+	for _, choice := range r.Choices {
+		message := choice.Message
+		if stream {
+			message = choice.Delta
+		}
+		text, _ := message.Content.(string)
+		resp.Candidates = append(resp.Candidates, &genai.Candidate{
+			Index:        choice.Index,
+			Content:      genai.NewContentFromText(text, genai.RoleModel),
+			FinishReason: openaiFinishReason(choice.FinishReason),
+		})
+	}
+	if r.Usage != nil {
+		resp.UsageMetadata = &genai.GenerateContentResponseUsageMetadata{
+			PromptTokenCount:     r.Usage.PromptTokens,
+			CandidatesTokenCount: r.Usage.CompletionTokens,
+			TotalTokenCount:      r.Usage.TotalTokens,
+		}
+	}
+	return resp
+}
+
+func openaiFinishReason(reason string) genai.FinishReason {
+	switch reason {
+	case "":
+		return ""
+	case "stop":
+		return genai.FinishReasonStop
+	case "length":
+		return genai.FinishReasonMaxTokens
+	case "content_filter":
+		return genai.FinishReasonSafety
+	default:
+		return genai.FinishReasonOther
+	}
+}
@@ -0,0 +1,286 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/spf13/cobra"
+)
+
+var personasDir string = "personas"
+
+// personaFileType is the extension of persona files, which hold the system instruction as Markdown.
+const personaFileType = "md"
+
+var deletePersonaYes bool
+
+var personaCmd = &cobra.Command{
+	Use:     "persona",
+	Example: "gencli persona create go-reviewer 'You are a senior Go reviewer. Point out bugs, races and unidiomatic code, most important first.'\ngit diff | gencli search 'Review this change' --persona go-reviewer\ngencli config set persona terse-sysadmin",
+	Short:   "Manage named personas, reusable system instructions",
+	Long:    "Manage named personas, such as go-reviewer or terse-sysadmin. A persona is a system instruction, stored as Markdown in ~/.gencli/personas/[name].md, that tells the model who it is and how to answer. Select one with --persona on the commands that generate content, or for every command with 'gencli config set persona [name]'. A one-off instruction is given with --system or --system-file instead.",
+	Args:    unknownCommand,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return cmd.Help()
+	},
+}
+
+var personaListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the personas with the first line of their instruction",
+	Args:  validArgs(cobra.NoArgs),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		personas, err := listPersonas()
+		if err != nil {
+			return err
+		}
+		if len(personas) == 0 {
+			fmt.Println("No personas yet, create one with 'gencli persona create [name] [instruction]'")
+			return nil
+		}
+
+		width := 0
+		for _, name := range personas {
+			width = max(width, len(name))
+		}
+		for _, name := range personas {
+			instruction, err := readPersona(name)
+			if err != nil {
+				return err
+			}
+			summary, _, _ := strings.Cut(instruction, "\n")
+			fmt.Printf("%-*s  %s\n", width, name, summary)
+		}
+		return nil
+	},
+}
+
+var personaShowCmd = &cobra.Command{
+	Use:   "show [name]",
+	Short: "Print the instruction of a persona",
+	Args:  validArgs(cobra.ExactArgs(1)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		instruction, err := readPersona(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Println(instruction)
+		return nil
+	},
+}
+
+var personaCreateCmd = &cobra.Command{
+	Use:     "create [name] [instruction]",
+	Example: "gencli persona create terse-sysadmin 'You are a sysadmin. Answer with the commands to run and one line of explanation at most.'\ngencli persona create go-reviewer < go-reviewer.md\ngencli persona create teacher",
+	Short:   "Create a persona",
+	Long:    "Create a persona from the instruction given as an argument or piped to stdin. Without either, the new persona's file is opened in the editor set by $VISUAL or $EDITOR, or vi when neither is set.",
+	Args:    validArgs(cobra.RangeArgs(1, 2)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+		file, err := personaFilePath(name)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(file); err == nil {
+			return fmt.Errorf("%w: persona %q already exists", ErrInvalidInput, name)
+		}
+
+		var instruction string
+		if len(args) == 2 {
+			instruction = args[1]
+		} else {
+			input, err := readPipedInput(cmd.InOrStdin(), maxPipedTextSize, "instruction")
+			if err != nil {
+				return err
+			}
+			instruction = string(input)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return fmt.Errorf("%w: creating personas directory: %w", ErrConfig, err)
+		}
+		if strings.TrimSpace(instruction) == "" {
+			if err := editPersona(file); err != nil {
+				return err
+			}
+		} else if err := os.WriteFile(file, []byte(strings.TrimSpace(instruction)+"\n"), 0644); err != nil {
+			return fmt.Errorf("%w: writing persona %q: %w", ErrConfig, name, err)
+		}
+		fmt.Printf("Persona %s created, use it with --persona %s\n", name, name)
+		return nil
+	},
+}
+
+var personaDeleteCmd = &cobra.Command{
+	Use:   "delete [name]",
+	Short: "Delete a persona",
+	Args:  validArgs(cobra.ExactArgs(1)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+		if _, err := readPersona(name); err != nil {
+			return err
+		}
+
+		if !deletePersonaYes {
+			confirmed := false
+			prompt := &survey.Confirm{Message: fmt.Sprintf("Delete persona %s?", name)}
+			if err := surveyAskOne(prompt, &confirmed); err != nil {
+				return err
+			}
+			if !confirmed {
+				fmt.Println("Persona not deleted")
+				return nil
+			}
+		}
+
+		file, err := personaFilePath(name)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(file); err != nil {
+			return fmt.Errorf("%w: deleting persona %q: %w", ErrConfig, name, err)
+		}
+		fmt.Println("Persona deleted:", name)
+		return nil
+	},
+}
+
+// editPersona opens the new persona file in the user's editor, and removes it again when it is left empty.
+func editPersona(file string) error {
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		return fmt.Errorf("%w: writing persona: %w", ErrConfig, err)
+	}
+	editor := editorCommand()
+	if err := runEditorFunc(editor, file); err != nil {
+		os.Remove(file)
+		return fmt.Errorf("running %s: %w", editor, err)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("%w: reading persona: %w", ErrConfig, err)
+	}
+	if strings.TrimSpace(string(data)) == "" {
+		os.Remove(file)
+		return invalidInput(errors.New("the instruction is empty, the persona wasn't created"))
+	}
+	return nil
+}
+
+// systemInstruction returns the system instruction given with --system, --system-file or --persona. When none of them
+// is given, it returns the instruction of the system config key, which a project file can set, or else the one of the
+// persona config key. It returns an empty string when there is no instruction.
+func systemInstruction() (string, error) {
+	given := 0
+	for _, value := range []string{generation.system, generation.systemFile, generation.persona} {
+		if value != "" {
+			given++
+		}
+	}
+	if given > 1 {
+		return "", invalidInput(errors.New("only one of --system, --system-file and --persona can be given"))
+	}
+
+	switch {
+	case generation.system != "":
+		return generation.system, nil
+	case generation.systemFile != "":
+		data, err := os.ReadFile(generation.systemFile)
+		if err != nil {
+			return "", invalidInput(err)
+		}
+		if strings.TrimSpace(string(data)) == "" {
+			return "", fmt.Errorf("%w: the system instruction in %s is empty", ErrInvalidInput, generation.systemFile)
+		}
+		return strings.TrimSpace(string(data)), nil
+	case generation.persona != "":
+		return readPersona(generation.persona)
+	}
+
+	if instruction := GetConfigFunc("system"); instruction != "" {
+		return strings.TrimSpace(instruction), nil
+	}
+	if name := GetConfigFunc("persona"); name != "" {
+		instruction, err := readPersona(name)
+		if errors.Is(err, ErrInvalidInput) {
+			return "", fmt.Errorf("%w: persona %q in config file doesn't exist, run 'gencli persona list' to see the existing ones", ErrConfig, name)
+		}
+		return instruction, err
+	}
+	return "", nil
+}
+
+// readPersona returns the instruction of the given persona.
+func readPersona(name string) (string, error) {
+	file, err := personaFilePath(name)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("%w: persona %q doesn't exist, run 'gencli persona list' to see the existing ones", ErrInvalidInput, name)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: reading persona %q: %w", ErrConfig, name, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// personaFilePath returns the path of the file holding the given persona, ~/.gencli/personas/[name].md.
+func personaFilePath(name string) (string, error) {
+	if err := validatePersonaName(name); err != nil {
+		return "", err
+	}
+	homeDir, err := getHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, configFileDir, personasDir, name+"."+personaFileType), nil
+}
+
+// listPersonas returns the names of the personas that have been created, sorted.
+func listPersonas() ([]string, error) {
+	homeDir, err := getHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(filepath.Join(homeDir, configFileDir, personasDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading personas: %w", ErrConfig, err)
+	}
+
+	var personas []string
+	for _, entry := range entries {
+		if name, ok := strings.CutSuffix(entry.Name(), "."+personaFileType); ok && !entry.IsDir() && profileNamePattern.MatchString(name) {
+			personas = append(personas, name)
+		}
+	}
+	slices.Sort(personas)
+	return personas, nil
+}
+
+// validatePersonaName allows the same names as profiles, so that a name can't point outside the personas directory.
+func validatePersonaName(name string) error {
+	if !profileNamePattern.MatchString(name) {
+		return fmt.Errorf("%w: invalid persona name %q, use only letters, digits, '-' and '_'", ErrInvalidInput, name)
+	}
+	return nil
+}
+
+func init() {
+	personaDeleteCmd.Flags().BoolVarP(&deletePersonaYes, "yes", "y", false, "Delete without asking for confirmation")
+
+	personaCmd.AddCommand(personaListCmd)
+	personaCmd.AddCommand(personaShowCmd)
+	personaCmd.AddCommand(personaCreateCmd)
+	personaCmd.AddCommand(personaDeleteCmd)
+	rootCmd.AddCommand(personaCmd)
+}
@@ -0,0 +1,293 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/spf13/cobra"
+)
+
+// defaultProfile is the name of the profile made of the config file alone, which is used when no other is selected.
+const defaultProfile = "default"
+
+var profilesDir string = "profiles"
+
+var profileNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// errUnknownProfile is returned by SetDefaultConfig when the selected profile hasn't been created.
+var errUnknownProfile = errors.New("unknown profile")
+
+var (
+	profileName        string
+	newProfileProvider string
+	newProfileModel    string
+	newProfileKeyEnv   string
+	newProfileBaseURL  string
+	deleteProfileYes   bool
+)
+
+var profileCmd = &cobra.Command{
+	Use:     "profile",
+	Example: "gencli profile create work --provider openai --model gpt-4o --api-key-env WORK_OPENAI_KEY\ngencli profile use work\ngencli --profile personal search 'What is new in Golang?'",
+	Short:   "Manage named configuration profiles",
+	Long:    "Manage named configuration profiles, such as work, personal or ci. A profile has its own model, provider, API key environment variable and defaults, and falls back to the config file for the values it doesn't set. Select a profile for one command with --profile or the GENCLI_PROFILE environment variable, or for every command with 'gencli profile use'.",
+	Args:    unknownCommand,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return cmd.Help()
+	},
+}
+
+var profileCreateCmd = &cobra.Command{
+	Use:     "create [name]",
+	Example: "gencli profile create ci --model gemini-2.5-flash-lite --api-key-env CI_GOOGLE_API_KEY",
+	Short:   "Create a profile",
+	Long:    "Create a profile. Other values, such as the default language or temperature, can be set afterwards with 'gencli --profile [name] config set'.",
+	Args:    validArgs(cobra.ExactArgs(1)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+		if err := validateProfileName(name); err != nil {
+			return err
+		}
+		file, err := profileFilePath(name)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(file); err == nil {
+			return fmt.Errorf("%w: profile %q already exists", ErrInvalidInput, name)
+		}
+
+		values := []struct{ key, value string }{
+			{"provider", newProfileProvider},
+			{"genai_model", newProfileModel},
+			{"api_key_env", newProfileKeyEnv},
+			{"base_url", newProfileBaseURL},
+		}
+		settings := map[string]any{}
+		for _, v := range values {
+			if v.value == "" {
+				continue
+			}
+			key, err := findConfigKey(v.key)
+			if err != nil {
+				return err
+			}
+			validate := key.validate
+			if v.key == "genai_model" {
+				// The model is checked against the profile's own provider, not the one currently in use.
+				validate = func(value string) error { return validateModelFor(newProfileProvider, value) }
+			}
+			if err := validate(v.value); err != nil {
+				return fmt.Errorf("%w: %w", ErrInvalidInput, err)
+			}
+			settings[v.key] = v.value
+		}
+
+		if err := writeConfigFile(file, func(s map[string]any) {
+			for key, value := range settings {
+				s[key] = value
+			}
+		}); err != nil {
+			return err
+		}
+		fmt.Printf("Profile %s created, select it with 'gencli profile use %s' or --profile %s\n", name, name, name)
+		return nil
+	},
+}
+
+var profileUseCmd = &cobra.Command{
+	Use:     "use [name]",
+	Example: "gencli profile use work\ngencli profile use default",
+	Short:   "Select the profile used by every command",
+	Long:    "Select the profile used by every command that isn't given --profile or GENCLI_PROFILE. Use 'default' to go back to the config file alone.",
+	Args:    validArgs(cobra.ExactArgs(1)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+		if name != defaultProfile {
+			if err := checkProfileExists(name); err != nil {
+				return err
+			}
+		}
+
+		configFile, err := configFilePath()
+		if err != nil {
+			return err
+		}
+		if err := writeConfigFile(configFile, func(settings map[string]any) {
+			if name == defaultProfile {
+				delete(settings, "current_profile")
+			} else {
+				settings["current_profile"] = name
+			}
+		}); err != nil {
+			return err
+		}
+		fmt.Println("Now using profile:", name)
+		return nil
+	},
+}
+
+var profileListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the profiles, marking the active one with *",
+	Args:  validArgs(cobra.NoArgs),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		profiles, err := listProfiles()
+		if err != nil {
+			return err
+		}
+
+		active := activeProfile()
+		if active == "" {
+			active = defaultProfile
+		}
+		for _, name := range append([]string{defaultProfile}, profiles...) {
+			marker := " "
+			if name == active {
+				marker = "*"
+			}
+			fmt.Println(marker, name)
+		}
+		return nil
+	},
+}
+
+var profileDeleteCmd = &cobra.Command{
+	Use:   "delete [name]",
+	Short: "Delete a profile",
+	Args:  validArgs(cobra.ExactArgs(1)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+		if name == defaultProfile {
+			return fmt.Errorf("%w: the default profile can't be deleted", ErrInvalidInput)
+		}
+		if err := checkProfileExists(name); err != nil {
+			return err
+		}
+
+		if !deleteProfileYes {
+			confirmed := false
+			prompt := &survey.Confirm{Message: fmt.Sprintf("Delete profile %s?", name)}
+			if err := surveyAskOne(prompt, &confirmed); err != nil {
+				return err
+			}
+			if !confirmed {
+				fmt.Println("Profile not deleted")
+				return nil
+			}
+		}
+
+		file, err := profileFilePath(name)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(file); err != nil {
+			return fmt.Errorf("%w: deleting profile %q: %w", ErrConfig, name, err)
+		}
+
+		// Don't leave the config file pointing to a profile that is gone.
+		if GetConfigFunc("current_profile") == name {
+			configFile, err := configFilePath()
+			if err != nil {
+				return err
+			}
+			if err := writeConfigFile(configFile, func(settings map[string]any) { delete(settings, "current_profile") }); err != nil {
+				return err
+			}
+		}
+		fmt.Println("Profile deleted:", name)
+		return nil
+	},
+}
+
+// activeProfile returns the profile selected with --profile, GENCLI_PROFILE or 'gencli profile use', in that order
+// of precedence. It returns an empty string when the default profile is used.
+func activeProfile() string {
+	name := profileName
+	if name == "" {
+		name = os.Getenv("GENCLI_PROFILE")
+	}
+	if name == "" {
+		name = GetConfigFunc("current_profile")
+	}
+	if name == defaultProfile {
+		return ""
+	}
+	return name
+}
+
+// profileFilePath returns the path of the file holding the given profile, ~/.gencli/profiles/[name].yaml.
+func profileFilePath(name string) (string, error) {
+	if err := validateProfileName(name); err != nil {
+		return "", err
+	}
+	homeDir, err := getHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, configFileDir, profilesDir, name+"."+configFileType), nil
+}
+
+// listProfiles returns the names of the profiles that have been created, sorted.
+func listProfiles() ([]string, error) {
+	homeDir, err := getHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(filepath.Join(homeDir, configFileDir, profilesDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading profiles: %w", ErrConfig, err)
+	}
+
+	var profiles []string
+	for _, entry := range entries {
+		if name, ok := strings.CutSuffix(entry.Name(), "."+configFileType); ok && !entry.IsDir() {
+			profiles = append(profiles, name)
+		}
+	}
+	slices.Sort(profiles)
+	return profiles, nil
+}
+
+func checkProfileExists(name string) error {
+	file, err := profileFilePath(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(file); err != nil {
+		return fmt.Errorf("%w: profile %q doesn't exist, run 'gencli profile list' to see the existing ones", ErrInvalidInput, name)
+	}
+	return nil
+}
+
+func validateProfileName(name string) error {
+	if name == defaultProfile {
+		return fmt.Errorf("%w: %q is reserved for the config file", ErrInvalidInput, defaultProfile)
+	}
+	if !profileNamePattern.MatchString(name) {
+		return fmt.Errorf("%w: invalid profile name %q, use only letters, digits, '-' and '_'", ErrInvalidInput, name)
+	}
+	return nil
+}
+
+func init() {
+	profileCreateCmd.Flags().StringVar(&newProfileProvider, "provider", "", "GenAI backend: "+strings.Join(supportedProviders, ", "))
+	profileCreateCmd.Flags().StringVar(&newProfileModel, "model", "", "Model used by the profile")
+	profileCreateCmd.Flags().StringVar(&newProfileKeyEnv, "api-key-env", "", "Environment variable holding the profile's API key")
+	profileCreateCmd.Flags().StringVar(&newProfileBaseURL, "base-url", "", "URL of the provider's API")
+	profileDeleteCmd.Flags().BoolVarP(&deleteProfileYes, "yes", "y", false, "Delete without asking for confirmation")
+
+	profileCmd.AddCommand(profileCreateCmd)
+	profileCmd.AddCommand(profileUseCmd)
+	profileCmd.AddCommand(profileListCmd)
+	profileCmd.AddCommand(profileDeleteCmd)
+	rootCmd.AddCommand(profileCmd)
+}
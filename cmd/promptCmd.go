@@ -0,0 +1,538 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+	"go.yaml.in/yaml/v3"
+	"google.golang.org/genai"
+)
+
+var promptsDir string = "prompts"
+
+// promptFileType is the extension of prompt template files, which hold YAML front-matter and a text/template body.
+const promptFileType = "md"
+
+// promptVarPattern matches the names of template variables, which are used as {{.name}} in the templates.
+var promptVarPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+var (
+	promptVars        []string
+	promptTemperature float32
+	streamPrompt      bool
+	newPromptProject  bool
+)
+
+// promptTemplate is a prompt stored in a template file.
+type promptTemplate struct {
+	name string
+	file string
+	// origin is "project" for the templates of the project's .gencli/prompts directory, "user" for the others.
+	origin      string
+	description string
+	// vars lists the variables that must be given to run the template.
+	vars []string
+	// model and temperature are empty when the template doesn't set them.
+	model       string
+	temperature *float32
+	schema      *jsonSchema
+	body        *template.Template
+}
+
+var promptCmd = &cobra.Command{
+	Use:     "prompt",
+	Example: "gencli prompt new review\ngit diff | gencli prompt run review --var focus=concurrency\ngencli prompt run release-notes --var version=v1.4.0 --var audience=users",
+	Short:   "Manage and run reusable prompt templates",
+	Long:    "Manage and run reusable prompt templates, written with Go's text/template. Templates are stored in ~/.gencli/prompts/[name].md, and in the .gencli/prompts directory of a project, found in the current directory or the closest of its parents, whose templates take precedence over yours. A template starts with YAML front-matter between --- lines, which can declare its description, the variables that must be given (vars), the model and temperature it is run with, and the JSON Schema of its response (schema), as a file relative to the template or inline.",
+	Args:    unknownCommand,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return cmd.Help()
+	},
+}
+
+var promptRunCmd = &cobra.Command{
+	Use:     "run [name] --var [key=value]...",
+	Example: "gencli prompt run release-notes --var version=v1.4.0 --var audience=users\ngit diff | gencli prompt run review",
+	Short:   "Fill in a prompt template and send it",
+	Long:    "Fill in a prompt template with the variables given with --var and send it. Anything piped to stdin is available to the template as {{.input}}, unless --var input=... is given. Every variable declared in the template's front-matter must be given before anything is sent, and the others are empty when they aren't. The template's model and temperature replace the configured ones, and --temperature replaces both. A template with a schema prints JSON matching it, asked for again up to 2 times when it doesn't.",
+	Args:    validArgs(cobra.ExactArgs(1)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		tmpl, err := loadPromptTemplate(args[0])
+		if err != nil {
+			return err
+		}
+
+		vars, err := parsePromptVars(promptVars)
+		if err != nil {
+			return err
+		}
+		input, err := readPipedInput(cmd.InOrStdin(), maxPipedTextSize, "input")
+		if err != nil {
+			return err
+		}
+		if _, ok := vars["input"]; !ok && len(input) > 0 {
+			vars["input"] = string(input)
+		}
+		var missing []string
+		for _, name := range tmpl.vars {
+			if _, ok := vars[name]; !ok {
+				missing = append(missing, name)
+			}
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("%w: prompt %s needs the variables %s, give them with --var name=value", ErrInvalidInput, tmpl.name, strings.Join(missing, ", "))
+		}
+		prompt, err := tmpl.execute(vars)
+		if err != nil {
+			return err
+		}
+
+		if err := validateRenderMode(); err != nil {
+			return err
+		}
+		model := GetConfigFunc("genai_model")
+		if tmpl.model != "" {
+			if err := validateModelFor(GetConfigFunc("provider"), tmpl.model); err != nil {
+				return fmt.Errorf("%w: model of prompt %s: %w", ErrInvalidInput, tmpl.name, err)
+			}
+			model = tmpl.model
+		}
+		if err := validateGeneration(cmd, GetConfigFunc("provider"), model, 1); err != nil {
+			return err
+		}
+		temperature := promptTemperature
+		if tmpl.temperature != nil && !cmd.Flags().Changed("temperature") {
+			temperature = *tmpl.temperature
+		}
+
+		ctx := context.Background()
+		provider, err := newProviderFunc(ctx)
+		if err != nil {
+			return err
+		}
+		contents := []*genai.Content{genai.NewContentFromText(prompt, genai.RoleUser)}
+		config := generation.apply(&genai.GenerateContentConfig{Temperature: genai.Ptr(temperature)})
+
+		// JSON is only printed once it is valid, and as is, so that it can be parsed.
+		if tmpl.schema != nil {
+			res, err := generateJSON(ctx, provider, model, contents, config, tmpl.schema)
+			if err != nil {
+				return err
+			}
+			fmt.Println(res)
+			return nil
+		}
+		if !streamPrompt {
+			resp, err := generateContent(ctx, provider, model, contents, config)
+			if err != nil {
+				return err
+			}
+			fmt.Println(renderResponse(resp.Text(), renderMode))
+			return nil
+		}
+		stream := newMarkdownStream(os.Stdout, renderMode)
+		for resp, err := range generateContentStream(ctx, provider, model, contents, config) {
+			if err != nil {
+				return err
+			}
+			if err := stream.Write(resp.Text()); err != nil {
+				return err
+			}
+		}
+		return stream.Flush()
+	},
+}
+
+var promptListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the prompt templates with their origin and description",
+	Args:  validArgs(cobra.NoArgs),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		templates, err := listPromptTemplates()
+		if err != nil {
+			return err
+		}
+		if len(templates) == 0 {
+			fmt.Println("No prompt templates yet, create one with 'gencli prompt new [name]'")
+			return nil
+		}
+
+		width := 0
+		for _, tmpl := range templates {
+			width = max(width, len(tmpl.name))
+		}
+		for _, tmpl := range templates {
+			fmt.Printf("%-*s  %-7s  %s\n", width, tmpl.name, tmpl.origin, tmpl.description)
+		}
+		return nil
+	},
+}
+
+var promptShowCmd = &cobra.Command{
+	Use:   "show [name]",
+	Short: "Print a prompt template",
+	Args:  validArgs(cobra.ExactArgs(1)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file, _, err := findPromptFile(args[0])
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("%w: reading prompt %q: %w", ErrConfig, args[0], err)
+		}
+		fmt.Print(string(data))
+		return nil
+	},
+}
+
+var promptNewCmd = &cobra.Command{
+	Use:     "new [name]",
+	Example: "gencli prompt new review\ngencli prompt new changelog --project",
+	Short:   "Create a prompt template and open it in your editor",
+	Long:    "Create a prompt template from an example and open it in the editor set by $VISUAL or $EDITOR, or vi when neither is set. The template is checked once the editor exits. With --project, it is created in the project's .gencli/prompts directory, or in the current directory's when there is none, so that it can be committed and shared.",
+	Args:    validArgs(cobra.ExactArgs(1)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+		if err := validatePromptName(name); err != nil {
+			return err
+		}
+
+		var dir string
+		var err error
+		if newPromptProject {
+			if dir = findProjectPromptsDir(); dir == "" {
+				dir, err = filepath.Abs(filepath.Join(configFileDir, promptsDir))
+			}
+		} else {
+			dir, err = userPromptsDir()
+		}
+		if err != nil {
+			return err
+		}
+		file := filepath.Join(dir, name+"."+promptFileType)
+		if _, err := os.Stat(file); err == nil {
+			return fmt.Errorf("%w: prompt %q already exists in %s", ErrInvalidInput, name, file)
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("%w: creating prompts directory: %w", ErrConfig, err)
+		}
+		if err := os.WriteFile(file, []byte(promptExample), 0644); err != nil {
+			return fmt.Errorf("%w: writing prompt %q: %w", ErrConfig, name, err)
+		}
+
+		if err := editPromptTemplate(name, file); err != nil {
+			return err
+		}
+		fmt.Printf("Prompt %s created in %s, run it with 'gencli prompt run %s'\n", name, file, name)
+		return nil
+	},
+}
+
+var promptEditCmd = &cobra.Command{
+	Use:   "edit [name]",
+	Short: "Open a prompt template in your editor",
+	Long:  "Open a prompt template in the editor set by $VISUAL or $EDITOR, or vi when neither is set. The template is checked once the editor exits.",
+	Args:  validArgs(cobra.ExactArgs(1)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file, _, err := findPromptFile(args[0])
+		if err != nil {
+			return err
+		}
+		return editPromptTemplate(args[0], file)
+	},
+}
+
+// promptExample is the template written by 'gencli prompt new'.
+const promptExample = `---
+description: Explain a piece of code
+# Variables that must be given with --var, anything piped to stdin is {{.input}}.
+vars: [language]
+# model: gemini-2.5-pro
+# temperature: 0.2
+# schema: explanation.schema.json
+---
+Explain what the following {{.language}} code does, then point out anything surprising in it.
+{{- if .focus}} Pay particular attention to {{.focus}}.{{end}}
+
+{{.input}}
+`
+
+// editPromptTemplate opens file in the user's editor and checks the template once the editor exits.
+func editPromptTemplate(name, file string) error {
+	editor := editorCommand()
+	if err := runEditorFunc(editor, file); err != nil {
+		return fmt.Errorf("running %s: %w", editor, err)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("%w: reading prompt %q: %w", ErrConfig, name, err)
+	}
+	_, err = parsePromptTemplate(name, file, data)
+	return err
+}
+
+// loadPromptTemplate reads and checks the template called name, from the project's prompts if it has one.
+func loadPromptTemplate(name string) (*promptTemplate, error) {
+	file, origin, err := findPromptFile(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading prompt %q: %w", ErrConfig, name, err)
+	}
+	tmpl, err := parsePromptTemplate(name, file, data)
+	if err != nil {
+		return nil, err
+	}
+	tmpl.origin = origin
+	return tmpl, nil
+}
+
+// parsePromptTemplate parses the front-matter and the body of a template, and loads its schema.
+func parsePromptTemplate(name, file string, data []byte) (*promptTemplate, error) {
+	tmpl := &promptTemplate{name: name, file: file}
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	if rest, ok := strings.CutPrefix(text, "---\n"); ok {
+		frontMatter, body, found := strings.Cut(rest, "\n---\n")
+		if !found {
+			frontMatter, found = strings.CutSuffix(rest, "\n---")
+		}
+		if !found {
+			return nil, fmt.Errorf("%w: prompt %s: the front-matter isn't closed with a --- line", ErrInvalidInput, name)
+		}
+		if err := tmpl.parseFrontMatter(frontMatter); err != nil {
+			return nil, fmt.Errorf("%w: prompt %s: %w", ErrInvalidInput, name, err)
+		}
+		text = body
+	}
+
+	body, err := template.New(name).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("%w: prompt %s: %w", ErrInvalidInput, name, err)
+	}
+	tmpl.body = body
+	return tmpl, nil
+}
+
+func (t *promptTemplate) parseFrontMatter(frontMatter string) error {
+	var fields struct {
+		Description string   `yaml:"description"`
+		Vars        []string `yaml:"vars"`
+		Model       string   `yaml:"model"`
+		Temperature *float32 `yaml:"temperature"`
+		Schema      any      `yaml:"schema"`
+	}
+	decoder := yaml.NewDecoder(strings.NewReader(frontMatter))
+	// Unknown keys are reported, so that a typo such as "temprature" isn't silently ignored.
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&fields); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid front-matter: %w", err)
+	}
+
+	t.description = fields.Description
+	t.vars = fields.Vars
+	for _, name := range t.vars {
+		if !promptVarPattern.MatchString(name) {
+			return fmt.Errorf("invalid variable name %q, use only letters, digits and '_'", name)
+		}
+	}
+	t.model = fields.Model
+	if fields.Temperature != nil && (*fields.Temperature < 0 || *fields.Temperature > 1) {
+		return fmt.Errorf("temperature must be between 0.0 and 1.0, got %v", *fields.Temperature)
+	}
+	t.temperature = fields.Temperature
+
+	switch schema := fields.Schema.(type) {
+	case nil:
+	case string:
+		// A schema file is found next to the template, so that both can be shared together.
+		file := schema
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(filepath.Dir(t.file), file)
+		}
+		loaded, err := loadJSONSchema(file)
+		if err != nil {
+			return err
+		}
+		t.schema = loaded
+	case map[string]any:
+		data, err := json.Marshal(schema)
+		if err != nil {
+			return fmt.Errorf("invalid schema: %w", err)
+		}
+		if t.schema, err = compileJSONSchema(t.file, data); err != nil {
+			return fmt.Errorf("invalid JSON Schema: %w", err)
+		}
+	default:
+		return errors.New("schema must be a JSON Schema file or an inline schema")
+	}
+	return nil
+}
+
+// execute fills in the template with vars.
+func (t *promptTemplate) execute(vars map[string]string) (string, error) {
+	var prompt bytes.Buffer
+	if err := t.body.Execute(&prompt, vars); err != nil {
+		return "", fmt.Errorf("%w: prompt %s: %w", ErrInvalidInput, t.name, err)
+	}
+	if strings.TrimSpace(prompt.String()) == "" {
+		return "", fmt.Errorf("%w: prompt %s is empty once filled in", ErrInvalidInput, t.name)
+	}
+	return strings.TrimSpace(prompt.String()), nil
+}
+
+// parsePromptVars parses the key=value pairs given with --var.
+func parsePromptVars(pairs []string) (map[string]string, error) {
+	vars := map[string]string{}
+	for _, pair := range pairs {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: invalid --var %q, use --var name=value", ErrInvalidInput, pair)
+		}
+		if !promptVarPattern.MatchString(name) {
+			return nil, fmt.Errorf("%w: invalid variable name %q, use only letters, digits and '_'", ErrInvalidInput, name)
+		}
+		vars[name] = value
+	}
+	return vars, nil
+}
+
+// findPromptFile returns the file of the template called name and its origin, looking in the project's prompts
+// first.
+func findPromptFile(name string) (string, string, error) {
+	if err := validatePromptName(name); err != nil {
+		return "", "", err
+	}
+	userDir, err := userPromptsDir()
+	if err != nil {
+		return "", "", err
+	}
+	for _, dir := range []struct{ path, origin string }{{findProjectPromptsDir(), "project"}, {userDir, "user"}} {
+		if dir.path == "" {
+			continue
+		}
+		file := filepath.Join(dir.path, name+"."+promptFileType)
+		if info, err := os.Stat(file); err == nil && !info.IsDir() {
+			return file, dir.origin, nil
+		}
+	}
+	return "", "", fmt.Errorf("%w: prompt %q doesn't exist, run 'gencli prompt list' to see the existing ones", ErrInvalidInput, name)
+}
+
+// listPromptTemplates returns the templates of the project and the user's, sorted by name. A template of the project
+// hides the user's template of the same name, like it does when the template is run.
+func listPromptTemplates() ([]*promptTemplate, error) {
+	userDir, err := userPromptsDir()
+	if err != nil {
+		return nil, err
+	}
+
+	var templates []*promptTemplate
+	for _, dir := range []struct{ path, origin string }{{findProjectPromptsDir(), "project"}, {userDir, "user"}} {
+		if dir.path == "" {
+			continue
+		}
+		entries, err := os.ReadDir(dir.path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: reading prompts: %w", ErrConfig, err)
+		}
+		for _, entry := range entries {
+			name, ok := strings.CutSuffix(entry.Name(), "."+promptFileType)
+			if !ok || entry.IsDir() || !profileNamePattern.MatchString(name) {
+				continue
+			}
+			if slices.ContainsFunc(templates, func(t *promptTemplate) bool { return t.name == name }) {
+				continue
+			}
+			file := filepath.Join(dir.path, entry.Name())
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("%w: reading prompt %q: %w", ErrConfig, name, err)
+			}
+			// A template with mistakes is still listed, so that it can be found and fixed.
+			tmpl, err := parsePromptTemplate(name, file, data)
+			if err != nil {
+				tmpl = &promptTemplate{name: name, file: file, description: "(invalid, see 'gencli prompt run " + name + "')"}
+			}
+			tmpl.origin = dir.origin
+			templates = append(templates, tmpl)
+		}
+	}
+	slices.SortFunc(templates, func(a, b *promptTemplate) int { return strings.Compare(a.name, b.name) })
+	return templates, nil
+}
+
+// userPromptsDir returns the directory of the user's templates, ~/.gencli/prompts.
+func userPromptsDir() (string, error) {
+	homeDir, err := getHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, configFileDir, promptsDir), nil
+}
+
+// findProjectPromptsDir returns the .gencli/prompts directory in the current directory or the closest of its
+// parents, or an empty string when there is none. The user's own directory, found when working under the home
+// directory, isn't a project's.
+func findProjectPromptsDir() string {
+	userDir, err := userPromptsDir()
+	if err != nil {
+		return ""
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	for {
+		prompts := filepath.Join(dir, configFileDir, promptsDir)
+		if info, err := os.Stat(prompts); err == nil && info.IsDir() && prompts != userDir {
+			return prompts
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// validatePromptName allows the same names as profiles, so that a name can't point outside the prompts directories.
+func validatePromptName(name string) error {
+	if !profileNamePattern.MatchString(name) {
+		return fmt.Errorf("%w: invalid prompt name %q, use only letters, digits, '-' and '_'", ErrInvalidInput, name)
+	}
+	return nil
+}
+
+func init() {
+	promptRunCmd.Flags().StringArrayVar(&promptVars, "var", nil, "Variable of the template, as name=value (repeatable)")
+	promptRunCmd.Flags().Float32VarP(&promptTemperature, "temperature", "t", defaultTemperature, "Response creativity (0.0-1.0), replaces the template's")
+	addGenerationFlags(promptRunCmd)
+	addRenderFlags(promptRunCmd)
+	promptRunCmd.Flags().BoolVar(&streamPrompt, "stream", isTerminal(os.Stdout), "Print the response while it is being generated, enabled by default on a terminal")
+	promptNewCmd.Flags().BoolVar(&newPromptProject, "project", false, "Create the template in the project's .gencli/prompts directory")
+
+	promptCmd.AddCommand(promptRunCmd)
+	promptCmd.AddCommand(promptListCmd)
+	promptCmd.AddCommand(promptShowCmd)
+	promptCmd.AddCommand(promptNewCmd)
+	promptCmd.AddCommand(promptEditCmd)
+	rootCmd.AddCommand(promptCmd)
+}
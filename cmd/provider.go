@@ -0,0 +1,189 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+const (
+	providerGemini = "gemini"
+	providerOpenAI = "openai"
+	providerOllama = "ollama"
+)
+
+var supportedProviders = []string{providerGemini, providerOpenAI, providerOllama}
+
+// Provider is a GenAI backend that the commands send their requests to. Requests and responses use the genai
+// types so that every backend can be used interchangeably by search, image and chat.
+type Provider interface {
+	// GenerateContent returns the complete response for contents.
+	GenerateContent(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error)
+	// GenerateContentStream yields the response in chunks while it is being generated.
+	GenerateContentStream(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) iter.Seq2[*genai.GenerateContentResponse, error]
+	// CountTokens returns the number of input tokens contents would use.
+	CountTokens(ctx context.Context, model string, contents []*genai.Content) (int32, error)
+	// ListModels returns the IDs of the models that can generate content.
+	ListModels(ctx context.Context) ([]string, error)
+}
+
+// modelDescriber is implemented by the providers that report the limits of their models, such as Gemini.
+type modelDescriber interface {
+	// ModelLimits returns the limits of model. The limits the provider doesn't report are zero.
+	ModelLimits(ctx context.Context, model string) (modelLimits, error)
+}
+
+// This function is used to create the provider used by the commands, and was created to allow for testing.
+var newProviderFunc = newProvider
+
+// newProvider creates the provider selected by the "provider" config key. Gemini is used when none is set.
+func newProvider(ctx context.Context) (Provider, error) {
+	switch name := GetConfigFunc("provider"); name {
+	case "", providerGemini:
+		return newGeminiProvider(ctx)
+	case providerOpenAI:
+		return newOpenAIProvider(GetConfigFunc("base_url"), os.Getenv(apiKeyEnv(name)))
+	case providerOllama:
+		return newOllamaProvider(GetConfigFunc("base_url"))
+	default:
+		return nil, fmt.Errorf("%w: unknown provider %q, supported providers are: %s", ErrConfig, name, strings.Join(supportedProviders, ", "))
+	}
+}
+
+// generateContent sends a request through provider and reports failures and blocked responses as typed errors.
+// Transient failures are retried as described by newRetryPolicy.
+func generateContent(ctx context.Context, provider Provider, model string, contents []*genai.Content, config *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error) {
+	policy, err := newRetryPolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := provider.GenerateContent(ctx, model, contents, config)
+		if err == nil {
+			return resp, checkBlocked(resp)
+		}
+
+		wait, retry := policy.backoff(attempt, err)
+		if !retry {
+			return nil, classifyError(err)
+		}
+		if err := sleepFunc(ctx, wait); err != nil {
+			return nil, classifyError(err)
+		}
+	}
+}
+
+// generateContentStream is the streaming counterpart of generateContent. A request is only retried when it fails
+// before the first chunk, so that no part of the response is printed twice.
+func generateContentStream(ctx context.Context, provider Provider, model string, contents []*genai.Content, config *genai.GenerateContentConfig) iter.Seq2[*genai.GenerateContentResponse, error] {
+	return func(yield func(*genai.GenerateContentResponse, error) bool) {
+		policy, err := newRetryPolicy()
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+
+		for attempt := 0; ; attempt++ {
+			var failed error
+			started := false
+			for resp, err := range provider.GenerateContentStream(ctx, model, contents, config) {
+				if err != nil && !started {
+					failed = err
+					break
+				}
+				if err != nil {
+					yield(nil, classifyError(err))
+					return
+				}
+				if err := checkBlocked(resp); err != nil {
+					yield(nil, err)
+					return
+				}
+				started = true
+				if !yield(resp, nil) {
+					return
+				}
+			}
+			if failed == nil {
+				return
+			}
+
+			wait, retry := policy.backoff(attempt, failed)
+			if !retry {
+				yield(nil, classifyError(failed))
+				return
+			}
+			if err := sleepFunc(ctx, wait); err != nil {
+				yield(nil, classifyError(err))
+				return
+			}
+		}
+	}
+}
+
+// apiKeyEnv returns the name of the environment variable holding the API key of the given provider.
+// The "api_key_env" config key overrides the provider's usual variable.
+func apiKeyEnv(provider string) string {
+	if env := GetConfigFunc("api_key_env"); env != "" {
+		return env
+	}
+	switch provider {
+	case providerOpenAI:
+		return "OPENAI_API_KEY"
+	default:
+		return "GOOGLE_API_KEY"
+	}
+}
+
+// statusError is returned by the HTTP-based providers when the server answers with a non-2xx status.
+type statusError struct {
+	Provider string
+	Code     int
+	Message  string
+	// RetryAfter is the delay asked for by the server's Retry-After header, if any.
+	RetryAfter time.Duration
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s API error %d: %s", e.Provider, e.Code, e.Message)
+}
+
+// sendRequest sends req and returns the response body, or a *statusError holding the server's error message when
+// the status isn't 2xx. Both {"error": "message"} and {"error": {"message": "message"}} bodies are understood.
+func sendRequest(client *http.Client, req *http.Request, provider string) (io.ReadCloser, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp.Body, nil
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(resp.Body)
+	message := strings.TrimSpace(string(data))
+	var errResp struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if json.Unmarshal(data, &errResp) == nil && len(errResp.Error) > 0 {
+		var text string
+		var object struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(errResp.Error, &text) == nil && text != "" {
+			message = text
+		} else if json.Unmarshal(errResp.Error, &object) == nil && object.Message != "" {
+			message = object.Message
+		}
+	}
+	return nil, &statusError{Provider: provider, Code: resp.StatusCode, Message: message, RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+}
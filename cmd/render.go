@@ -0,0 +1,458 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/formatters"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/spf13/cobra"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	extast "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+const (
+	renderPlain    = "plain"
+	renderANSI     = "ansi"
+	renderMarkdown = "markdown"
+)
+
+// renderModes lists the values of --render: text without Markdown syntax, text styled with ANSI escape codes with
+// highlighted code blocks, or the Markdown returned by the model.
+var renderModes = []string{renderPlain, renderANSI, renderMarkdown}
+
+// renderMode is the --render flag of the commands printing a response, shared like the image flags.
+var renderMode string
+
+// codeStyle is the chroma style used to highlight code blocks in the ansi mode. It leaves plain text and the
+// background to the terminal, so that code reads on both dark and light themes.
+const codeStyle = "pygments"
+
+// ANSI escape codes. Each style is ended by its own code rather than a full reset, so that nested styles, such as
+// code inside a bold heading, are kept.
+const (
+	ansiBold          = "\x1b[1m"
+	ansiNormal        = "\x1b[22m"
+	ansiDim           = "\x1b[2m"
+	ansiItalic        = "\x1b[3m"
+	ansiItalicOff     = "\x1b[23m"
+	ansiUnderline     = "\x1b[4m"
+	ansiUnderlineOff  = "\x1b[24m"
+	ansiStrike        = "\x1b[9m"
+	ansiStrikeOff     = "\x1b[29m"
+	ansiCyan          = "\x1b[36m"
+	ansiForegroundOff = "\x1b[39m"
+)
+
+// ansiPattern matches the ANSI escape codes, which take no room on the screen.
+var ansiPattern = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+var markdownParser = goldmark.New(goldmark.WithExtensions(extension.GFM)).Parser()
+
+// addRenderFlags adds --render to cmd.
+func addRenderFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&renderMode, "render", defaultRenderMode(), "How the response is printed: "+strings.Join(renderModes, ", ")+", ansi by default on a terminal and markdown when piped")
+}
+
+// defaultRenderMode styles the responses printed on a terminal, and keeps the Markdown when they are piped.
+func defaultRenderMode() string {
+	if isTerminal(os.Stdout) {
+		return renderANSI
+	}
+	return renderMarkdown
+}
+
+func validateRenderMode() error {
+	if !slices.Contains(renderModes, renderMode) {
+		return invalidInput(fmt.Errorf("unknown render mode %q, supported modes are: %s", renderMode, strings.Join(renderModes, ", ")))
+	}
+	return nil
+}
+
+// fileRenderMode is the mode used for the responses saved to a file, where ANSI escape codes would only get in the
+// way.
+func fileRenderMode(mode string) string {
+	if mode == renderANSI {
+		return renderMarkdown
+	}
+	return mode
+}
+
+// renderResponse renders the Markdown of a response in mode. The Markdown is parsed rather than matched line by
+// line, so that code blocks, lists and snake_case identifiers come out as written.
+func renderResponse(source, mode string) string {
+	if mode == renderMarkdown {
+		return source
+	}
+	r := &markdownRenderer{source: []byte(source), ansi: mode == renderANSI}
+	doc := markdownParser.Parse(text.NewReader(r.source))
+	return r.blocks(doc, "\n\n")
+}
+
+type markdownRenderer struct {
+	source []byte
+	ansi   bool
+}
+
+// style wraps s in the ANSI codes on and off in the ansi mode, and returns it as is otherwise.
+func (r *markdownRenderer) style(s, on, off string) string {
+	if !r.ansi || s == "" {
+		return s
+	}
+	return on + s + off
+}
+
+// blocks renders the block children of node, separated by sep. Blocks rendering to nothing are skipped.
+func (r *markdownRenderer) blocks(node ast.Node, sep string) string {
+	var rendered []string
+	for child := node.FirstChild(); child != nil; child = child.NextSibling() {
+		if block := r.block(child); block != "" {
+			rendered = append(rendered, block)
+		}
+	}
+	return strings.Join(rendered, sep)
+}
+
+func (r *markdownRenderer) block(node ast.Node) string {
+	switch n := node.(type) {
+	case *ast.Paragraph, *ast.TextBlock:
+		return r.inlines(n)
+	case *ast.Heading:
+		if n.Level == 1 {
+			return r.style(r.style(r.inlines(n), ansiUnderline, ansiUnderlineOff), ansiBold, ansiNormal)
+		}
+		return r.style(r.inlines(n), ansiBold, ansiNormal)
+	case *ast.ThematicBreak:
+		return r.style(strings.Repeat("─", 40), ansiDim, ansiNormal)
+	case *ast.FencedCodeBlock:
+		return r.code(r.lines(n), string(n.Language(r.source)))
+	case *ast.CodeBlock:
+		return r.code(r.lines(n), "")
+	case *ast.HTMLBlock:
+		html := r.lines(n)
+		if n.HasClosure() {
+			html += string(n.ClosureLine.Value(r.source))
+		}
+		return strings.TrimRight(html, "\n")
+	case *ast.Blockquote:
+		prefix := "> "
+		if r.ansi {
+			prefix = ansiDim + "│" + ansiNormal + " "
+		}
+		return indentLines(r.blocks(n, "\n\n"), prefix, prefix)
+	case *ast.List:
+		return r.list(n)
+	case *extast.Table:
+		return r.table(n)
+	default:
+		return r.blocks(n, "\n\n")
+	}
+}
+
+// list renders the items of a list with their marker, the lines after the first being aligned with its text.
+// Ordered lists are numbered from their first number, so that a list split by a streamed response keeps counting.
+func (r *markdownRenderer) list(list *ast.List) string {
+	sep := "\n\n"
+	if list.IsTight {
+		sep = "\n"
+	}
+	var items []string
+	number := list.Start
+	for item := list.FirstChild(); item != nil; item = item.NextSibling() {
+		marker := "- "
+		if r.ansi {
+			marker = "• "
+		}
+		if list.IsOrdered() {
+			marker = strconv.Itoa(number) + string(list.Marker) + " "
+			number++
+		}
+		itemSep := "\n\n"
+		if list.IsTight {
+			itemSep = "\n"
+		}
+		items = append(items, indentLines(r.blocks(item, itemSep), marker, strings.Repeat(" ", utf8.RuneCountInString(marker))))
+	}
+	return strings.Join(items, sep)
+}
+
+// table renders a table with its columns aligned, and a rule under its header.
+func (r *markdownRenderer) table(table *extast.Table) string {
+	var rows [][]string
+	for row := table.FirstChild(); row != nil; row = row.NextSibling() {
+		var cells []string
+		for cell := row.FirstChild(); cell != nil; cell = cell.NextSibling() {
+			cells = append(cells, r.inlines(cell))
+		}
+		rows = append(rows, cells)
+	}
+
+	var widths []int
+	for _, row := range rows {
+		for i, cell := range row {
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+			widths[i] = max(widths[i], visibleWidth(cell))
+		}
+	}
+
+	var lines []string
+	for i, row := range rows {
+		cells := make([]string, len(row))
+		for j, cell := range row {
+			padding := strings.Repeat(" ", widths[j]-visibleWidth(cell))
+			if i == 0 {
+				cell = r.style(cell, ansiBold, ansiNormal)
+			}
+			if j < len(table.Alignments) && table.Alignments[j] == extast.AlignRight {
+				cells[j] = padding + cell
+			} else {
+				cells[j] = cell + padding
+			}
+		}
+		lines = append(lines, strings.TrimRight(strings.Join(cells, "  "), " "))
+		if i == 0 {
+			rules := make([]string, len(widths))
+			for j, width := range widths {
+				rules[j] = strings.Repeat("-", width)
+			}
+			lines = append(lines, r.style(strings.Join(rules, "  "), ansiDim, ansiNormal))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// code renders a code block, highlighted in the ansi mode and as is otherwise, so that it can be copied.
+func (r *markdownRenderer) code(code, language string) string {
+	code = strings.TrimRight(code, "\n")
+	if !r.ansi {
+		return code
+	}
+	lexer := lexers.Get(language)
+	if lexer == nil {
+		lexer = lexers.Analyse(code)
+	}
+	if lexer == nil {
+		return code
+	}
+	tokens, err := chroma.Coalesce(lexer).Tokenise(nil, code)
+	if err != nil {
+		return code
+	}
+	var highlighted bytes.Buffer
+	if err := formatters.TTY256.Format(&highlighted, styles.Get(codeStyle), tokens); err != nil {
+		return code
+	}
+	// The formatter ends the code with a newline followed by a reset.
+	return strings.TrimRight(strings.ReplaceAll(highlighted.String(), "\n\x1b[0m", "\x1b[0m\n"), "\n")
+}
+
+// lines returns the raw lines of a block, such as the code of a code block.
+func (r *markdownRenderer) lines(node ast.Node) string {
+	var b strings.Builder
+	lines := node.Lines()
+	for i := range lines.Len() {
+		segment := lines.At(i)
+		b.Write(segment.Value(r.source))
+	}
+	return b.String()
+}
+
+// inlines renders the inline children of node, such as the text of a paragraph.
+func (r *markdownRenderer) inlines(node ast.Node) string {
+	var b strings.Builder
+	for child := node.FirstChild(); child != nil; child = child.NextSibling() {
+		b.WriteString(r.inline(child))
+	}
+	return b.String()
+}
+
+func (r *markdownRenderer) inline(node ast.Node) string {
+	switch n := node.(type) {
+	case *ast.Text:
+		value := n.Value(r.source)
+		if !n.IsRaw() {
+			value = util.ResolveEntityNames(util.ResolveNumericReferences(util.UnescapePunctuations(value)))
+		}
+		s := string(value)
+		if n.SoftLineBreak() || n.HardLineBreak() {
+			s += "\n"
+		}
+		return s
+	case *ast.String:
+		return string(n.Value)
+	case *ast.CodeSpan:
+		var code strings.Builder
+		for child := n.FirstChild(); child != nil; child = child.NextSibling() {
+			switch c := child.(type) {
+			case *ast.Text:
+				code.Write(c.Value(r.source))
+			case *ast.String:
+				code.Write(c.Value)
+			}
+		}
+		return r.style(code.String(), ansiCyan, ansiForegroundOff)
+	case *ast.Emphasis:
+		if n.Level >= 2 {
+			return r.style(r.inlines(n), ansiBold, ansiNormal)
+		}
+		return r.style(r.inlines(n), ansiItalic, ansiItalicOff)
+	case *extast.Strikethrough:
+		return r.style(r.inlines(n), ansiStrike, ansiStrikeOff)
+	case *ast.Link:
+		return r.link(r.inlines(n), string(n.Destination))
+	case *ast.Image:
+		return r.link(r.inlines(n), string(n.Destination))
+	case *ast.AutoLink:
+		return r.style(string(n.URL(r.source)), ansiUnderline, ansiUnderlineOff)
+	case *ast.RawHTML:
+		var html strings.Builder
+		for i := range n.Segments.Len() {
+			segment := n.Segments.At(i)
+			html.Write(segment.Value(r.source))
+		}
+		return html.String()
+	case *extast.TaskCheckBox:
+		if n.IsChecked {
+			return "[x] "
+		}
+		return "[ ] "
+	default:
+		return r.inlines(n)
+	}
+}
+
+// link renders the text of a link followed by its destination, unless the text is the destination itself.
+func (r *markdownRenderer) link(label, destination string) string {
+	if label == "" || label == destination {
+		return r.style(destination, ansiUnderline, ansiUnderlineOff)
+	}
+	return r.style(label, ansiUnderline, ansiUnderlineOff) + " " + r.style("("+destination+")", ansiDim, ansiNormal)
+}
+
+// indentLines prefixes the first line of s with first, and the others with rest, leaving blank lines empty.
+func indentLines(s, first, rest string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		prefix := rest
+		if i == 0 {
+			prefix = first
+		}
+		if line == "" && i > 0 {
+			prefix = strings.TrimRight(prefix, " ")
+		}
+		lines[i] = prefix + line
+	}
+	return strings.Join(lines, "\n")
+}
+
+// visibleWidth returns the number of characters s takes on the screen, without its ANSI escape codes.
+func visibleWidth(s string) int {
+	return utf8.RuneCountInString(ansiPattern.ReplaceAllString(s, ""))
+}
+
+// markdownStream renders a streamed response. A Markdown block can only be rendered once it is complete, so text
+// is held back until a blank line ends the blocks before it, outside of a code block, and they are then rendered
+// the same way renderResponse renders them as part of the complete response. The markdown mode is written as is.
+type markdownStream struct {
+	w    io.Writer
+	mode string
+	// pending holds the complete lines not rendered yet, and partial the line being received.
+	pending, partial string
+	// fence is the marker opening the code block the stream is in, if any.
+	fence   string
+	written bool
+}
+
+func newMarkdownStream(w io.Writer, mode string) *markdownStream {
+	return &markdownStream{w: w, mode: mode}
+}
+
+// Write buffers chunk and renders every block that is now complete.
+func (s *markdownStream) Write(chunk string) error {
+	if s.mode == renderMarkdown {
+		_, err := io.WriteString(s.w, chunk)
+		return err
+	}
+
+	s.partial += chunk
+	for {
+		i := strings.IndexByte(s.partial, '\n')
+		if i < 0 {
+			return nil
+		}
+		line := s.partial[:i+1]
+		s.partial = s.partial[i+1:]
+		s.trackFence(line)
+		if s.fence == "" && strings.TrimSpace(line) == "" && strings.TrimSpace(s.pending) != "" {
+			if err := s.render(s.pending); err != nil {
+				return err
+			}
+			s.pending = ""
+			continue
+		}
+		s.pending += line
+	}
+}
+
+// Flush renders whatever is left once the stream has ended, followed by a final newline.
+func (s *markdownStream) Flush() error {
+	if s.mode == renderMarkdown {
+		_, err := io.WriteString(s.w, "\n")
+		return err
+	}
+	rest := s.pending + s.partial
+	s.pending, s.partial = "", ""
+	if err := s.render(rest); err != nil {
+		return err
+	}
+	if !s.written {
+		_, err := io.WriteString(s.w, "\n")
+		return err
+	}
+	return nil
+}
+
+func (s *markdownStream) render(source string) error {
+	rendered := renderResponse(source, s.mode)
+	if rendered == "" {
+		return nil
+	}
+	if s.written {
+		rendered = "\n" + rendered
+	}
+	s.written = true
+	_, err := io.WriteString(s.w, rendered+"\n")
+	return err
+}
+
+// trackFence notes when line opens or closes a fenced code block, in which blank lines don't end a block.
+func (s *markdownStream) trackFence(line string) {
+	trimmed := strings.TrimSpace(line)
+	if s.fence != "" {
+		if strings.HasPrefix(trimmed, s.fence) && strings.Trim(trimmed, s.fence[:1]) == "" {
+			s.fence = ""
+		}
+		return
+	}
+	for _, char := range []string{"`", "~"} {
+		if strings.HasPrefix(trimmed, char+char+char) {
+			s.fence = trimmed[:len(trimmed)-len(strings.TrimLeft(trimmed, char))]
+			return
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+var (
+	defaultMaxRetries   int           = 3
+	defaultRetryMaxWait time.Duration = 30 * time.Second
+	retryBaseDelay      time.Duration = time.Second
+)
+
+var (
+	maxRetries   int
+	retryMaxWait time.Duration
+)
+
+// retryPolicy decides whether a failed request is sent again, and how long to wait before doing so.
+type retryPolicy struct {
+	maxRetries int
+	maxWait    time.Duration
+}
+
+// newRetryPolicy returns the policy set by the --max-retries and --retry-max-wait flags, whose defaults come from
+// the "max_retries" and "retry_max_wait" config keys.
+func newRetryPolicy() (retryPolicy, error) {
+	policy := retryPolicy{maxRetries: maxRetries, maxWait: retryMaxWait}
+	if policy.maxRetries < 0 {
+		return policy, fmt.Errorf("%w: max retries can't be negative", ErrInvalidInput)
+	}
+	if policy.maxWait < 0 {
+		return policy, fmt.Errorf("%w: retry max wait can't be negative", ErrInvalidInput)
+	}
+	return policy, nil
+}
+
+// backoff returns how long to wait before sending the request again after its attempt-th retry failed with err,
+// counting from zero, and false when it shouldn't be retried. The wait doubles with every attempt, half of it is
+// random so that parallel clients don't retry in lockstep, and a delay asked for by the server takes precedence.
+// The wait never exceeds maxWait.
+func (p retryPolicy) backoff(attempt int, err error) (time.Duration, bool) {
+	if attempt >= p.maxRetries || !isRetryable(err) {
+		return 0, false
+	}
+
+	if wait, ok := retryAfter(err); ok {
+		return min(wait, p.maxWait), true
+	}
+
+	wait := min(retryBaseDelay<<min(attempt, 30), p.maxWait)
+	half := wait / 2
+	return half + rand.N(wait-half+1), true
+}
+
+// isRetryable reports whether err is a transient failure: the server is rate limiting, overloaded or briefly
+// unavailable.
+func isRetryable(err error) bool {
+	var apiErr genai.APIError
+	var statusErr *statusError
+	var code int
+	switch {
+	case errors.As(err, &apiErr):
+		code = apiErr.Code
+	case errors.As(err, &statusErr):
+		code = statusErr.Code
+	default:
+		return false
+	}
+
+	switch code {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter returns the delay the server asked for before the next request. The HTTP-based providers read it
+// from the Retry-After header, and Gemini sends it as a google.rpc.RetryInfo error detail.
+func retryAfter(err error) (time.Duration, bool) {
+	var apiErr genai.APIError
+	var statusErr *statusError
+	switch {
+	case errors.As(err, &apiErr):
+		for _, detail := range apiErr.Details {
+			kind, _ := detail["@type"].(string)
+			delay, _ := detail["retryDelay"].(string)
+			if !strings.HasSuffix(kind, "google.rpc.RetryInfo") || delay == "" {
+				continue
+			}
+			if d, err := time.ParseDuration(delay); err == nil && d >= 0 {
+				return d, true
+			}
+		}
+	case errors.As(err, &statusErr):
+		if statusErr.RetryAfter > 0 {
+			return statusErr.RetryAfter, true
+		}
+	}
+	return 0, false
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which is either a number of seconds or an HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0)
+	}
+	return 0
+}
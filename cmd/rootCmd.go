@@ -1,34 +1,67 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
 	"github.com/spf13/cobra"
 )
 
+var errorFormat string
+
 var rootCmd = &cobra.Command{
 	Use:   "gencli",
 	Short: "A CLI tool to interact with the Gemini API",
 	Long:  "A CLI tool to interact with the Gemini API. You can ask questions and get responses in text or image format.",
-	Run: func(cmd *cobra.Command, args []string) {
-		err := cmd.Help()
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+	Args:  unknownCommand,
+	// Errors are printed by Execute, in the format chosen with --error-format.
+	SilenceErrors: true,
+	SilenceUsage:  true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if errorFormat != "text" && errorFormat != "json" {
+			return invalidInput(fmt.Errorf("invalid error format %q, supported formats are: text, json", errorFormat))
+		}
+		// The config is loaded once the flags are parsed, because --profile decides which files are read.
+		if err := SetDefaultConfig(); err != nil {
+			// The profile commands must still work, for example to create the missing profile.
+			if cmd.Parent() != profileCmd || !errors.Is(err, errUnknownProfile) {
+				return err
+			}
 		}
+		return applyConfigDefaults(cmd)
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return cmd.Help()
 	},
 }
 
+// Execute runs gencli and exits with the code matching the error, if any. See errors.go for the exit codes.
 func Execute() {
 	rootCmd.CompletionOptions.DisableDefaultCmd = true
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+	cmd, err := rootCmd.ExecuteC()
+	if err == nil {
+		return
+	}
+
+	printError(os.Stderr, err, errorFormat)
+	code, _ := exitCode(err)
+	if code == ExitInvalidInput && errorFormat != "json" {
+		fmt.Fprintf(os.Stderr, "Run '%s --help' for usage.\n", cmd.CommandPath())
 	}
+	exitFunc(code)
 }
 
 func init() {
+	rootCmd.PersistentFlags().StringVar(&errorFormat, "error-format", "text", "Format of error messages: text or json")
+	rootCmd.PersistentFlags().StringVar(&profileName, "profile", "", "Configuration profile to use, overrides GENCLI_PROFILE")
+	rootCmd.PersistentFlags().IntVar(&maxRetries, "max-retries", defaultMaxRetries, "Number of times a request is retried after a transient API error")
+	rootCmd.PersistentFlags().BoolVar(&noStdin, "no-stdin", false, "Don't read the input piped to stdin, for scripts whose stdin is never closed")
+	rootCmd.PersistentFlags().DurationVar(&retryMaxWait, "retry-max-wait", defaultRetryMaxWait, "Longest wait between two retries")
+	rootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return invalidInput(err)
+	})
 	rootCmd.AddCommand(searchCmd)
 	rootCmd.AddCommand(imageCmd)
+	rootCmd.AddCommand(chatCmd)
 }
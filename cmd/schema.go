@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"google.golang.org/genai"
+)
+
+// maxSchemaRetries is the number of times a JSON response that isn't valid is asked for again.
+const maxSchemaRetries = 2
+
+// jsonSchema is a JSON Schema that responses are asked to match and are checked against.
+type jsonSchema struct {
+	// document is the decoded schema, sent to the model.
+	document any
+	compiled *jsonschema.Schema
+}
+
+// loadJSONSchema reads and compiles the JSON Schema in file, so that mistakes in it are reported before any request
+// is sent.
+func loadJSONSchema(file string) (*jsonSchema, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, invalidInput(err)
+	}
+	schema, err := compileJSONSchema(file, data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid JSON Schema in %s: %w", ErrInvalidInput, file, err)
+	}
+	return schema, nil
+}
+
+// compileJSONSchema compiles the JSON Schema in data. name identifies it in error messages.
+func compileJSONSchema(name string, data []byte) (*jsonSchema, error) {
+	document, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	compiler := jsonschema.NewCompiler()
+	if err := compiler.AddResource(name, document); err != nil {
+		return nil, err
+	}
+	compiled, err := compiler.Compile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// The document sent to the model is decoded again with encoding/json, because the one used by the compiler holds
+	// json.Number values.
+	var sent any
+	if err := json.Unmarshal(data, &sent); err != nil {
+		return nil, err
+	}
+	return &jsonSchema{document: sent, compiled: compiled}, nil
+}
+
+// validate checks that text is JSON matching the schema, and returns an error describing every mismatch otherwise.
+// A nil schema only checks that text is JSON.
+func (s *jsonSchema) validate(text string) error {
+	if s == nil {
+		if !json.Valid([]byte(text)) {
+			return errors.New("the response isn't valid JSON")
+		}
+		return nil
+	}
+	value, err := jsonschema.UnmarshalJSON(strings.NewReader(text))
+	if err != nil {
+		return fmt.Errorf("the response isn't valid JSON: %w", err)
+	}
+	if err := s.compiled.Validate(value); err != nil {
+		return fmt.Errorf("the response doesn't match the schema: %w", err)
+	}
+	return nil
+}
+
+// generateJSON asks for a JSON response, matching schema unless it is nil, and returns it once it is valid. A
+// response that isn't is sent back to the model along with what is wrong with it, up to maxSchemaRetries times.
+func generateJSON(ctx context.Context, provider Provider, model string, contents []*genai.Content, config *genai.GenerateContentConfig, schema *jsonSchema) (string, error) {
+	if config == nil {
+		config = &genai.GenerateContentConfig{}
+	}
+	config.ResponseMIMEType = "application/json"
+	if schema != nil {
+		config.ResponseJsonSchema = schema.document
+	}
+
+	contents = slices.Clone(contents)
+	for attempt := 0; ; attempt++ {
+		resp, err := generateContent(ctx, provider, model, contents, config)
+		if err != nil {
+			return "", err
+		}
+		text := stripCodeFence(resp.Text())
+		err = schema.validate(text)
+		if err == nil {
+			return text, nil
+		}
+		if attempt == maxSchemaRetries {
+			return "", fmt.Errorf("%w: %w (%d attempts)", ErrAPI, err, attempt+1)
+		}
+		contents = append(contents,
+			genai.NewContentFromText(resp.Text(), genai.RoleModel),
+			genai.NewContentFromText(err.Error()+". Answer again with only the corrected JSON.", genai.RoleUser))
+	}
+}
@@ -2,11 +2,11 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"os"
-	"path/filepath"
-	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -20,90 +20,213 @@ var (
 	temperature    float32
 	saveOutput     bool
 	outputFile     string
+	streamOutput   bool
+	// searchInput holds what was piped to stdin, used as context for the question or as the question itself.
+	searchInput       string
+	attachPaths       []string
+	deleteSearchFiles bool
+	groundedSearch    bool
+	searchFormat      string
+	searchSchemaFile  string
+	// searchSchema is the schema loaded from --json-schema, nil when none is given.
+	searchSchema *jsonSchema
 )
 
+// searchOutputFormats lists the values of 'search --output-format'.
+var searchOutputFormats = []string{"text", "json"}
+
 var searchCmd = &cobra.Command{
 	Use:     "search [your question]",
-	Example: "gencli search 'What is new in Golang?'",
+	Example: "gencli search 'What is new in Golang?'\ngit diff | gencli search 'Review this change'\ngencli search 'Summarize this paper' --attach paper.pdf\ngencli search 'Who won the last Champions League final?' --grounded\ngencli search 'List the 3 largest moons of Jupiter' --json-schema moons.schema.json | jq '.[].name'",
 	Short:   "Ask a question and get a response (Please put your question in quotes)",
-	Long:    "Ask a question and get a response in a specified number of words. The default number of words is 150. You can change the number of words by using the --words flag.",
-	Args:    cobra.MinimumNArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
-		res := getApiResponseFunc(args)
+	Long:    "Ask a question and get a response in a specified number of words. The default number of words is 150. You can change the number of words by using the --words flag. The Markdown of the response is styled on a terminal and kept as is when piped; --render plain prints it as plain text. Anything piped to stdin, such as a diff or a log, is sent as context for the question, or is the question itself when no argument is given. With --grounded, the model searches the web with Google Search, and the response cites the pages it is based on with markers such as [1], listed as numbered sources at the end. With --output-format json, the response is JSON, matching the JSON Schema given with --json-schema if any; a response that isn't valid is asked for again, up to 2 times, before failing with an error.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		input, err := readPipedInput(cmd.InOrStdin(), maxPipedTextSize, "input")
+		if err != nil {
+			return err
+		}
+		searchInput = string(input)
+		if len(args) == 0 && strings.TrimSpace(searchInput) == "" {
+			return invalidInput(errors.New("requires a question, as an argument or piped to stdin"))
+		}
 
-		if saveOutput {
-			// Create directory if it doesn't exist
-			dir := filepath.Dir(outputFile)
-			if dir != "." {
-				err := os.MkdirAll(dir, 0755)
-				CheckNilError(err)
+		if !slices.Contains(searchOutputFormats, searchFormat) {
+			return invalidInput(fmt.Errorf("unknown output format %q, supported formats are: %s", searchFormat, strings.Join(searchOutputFormats, ", ")))
+		}
+		searchSchema = nil
+		if searchSchemaFile != "" {
+			if cmd.Flags().Changed("output-format") && searchFormat != "json" {
+				return invalidInput(errors.New("--json-schema needs --output-format json"))
+			}
+			searchFormat = "json"
+			if searchSchema, err = loadJSONSchema(searchSchemaFile); err != nil {
+				return err
 			}
+		}
+		if searchFormat == "json" && groundedSearch {
+			return invalidInput(errors.New("--grounded can't be used with --output-format json"))
+		}
+		if err := validateRenderMode(); err != nil {
+			return err
+		}
+		if err := validateGeneration(cmd, GetConfigFunc("provider"), GetConfigFunc("genai_model"), candidateCount); err != nil {
+			return err
+		}
+		if candidateCount > 1 && (groundedSearch || searchFormat == "json") {
+			return invalidInput(errors.New("--candidates can't be used with --grounded or --output-format json"))
+		}
 
-			err := os.WriteFile(outputFile, []byte(res), 0644)
-			CheckNilError(err)
-			fmt.Printf("Response saved to: %s\n", outputFile)
+		// The citations of a grounded response are only known once it is complete, a JSON response is only printed
+		// once it is valid, and several responses would be mixed up, so none of them is streamed.
+		buffered := groundedSearch || searchFormat == "json" || candidateCount > 1
+		var res string
+		if streamOutput && !buffered {
+			res, err = streamApiResponseFunc(args, os.Stdout)
 		} else {
-			fmt.Println(res)
+			res, err = getApiResponseFunc(args)
+		}
+		if err != nil {
+			return err
 		}
+
+		// JSON is printed as is, so that it can be parsed.
+		mode := renderMode
+		if searchFormat == "json" {
+			mode = renderMarkdown
+		}
+		if saveOutput {
+			if err := saveResponseToFile(outputFile, renderResponse(res, fileRenderMode(mode))); err != nil {
+				return err
+			}
+			fmt.Printf("Response saved to: %s\n", outputFile)
+		} else if !streamOutput || buffered {
+			fmt.Println(renderResponse(res, mode))
+		}
+		return nil
 	},
 }
 
 // This function is used to get the response from the GenAI API, and was created to allow for testing.
 var getApiResponseFunc = getApiResponse
 
-func getApiResponse(args []string) string {
-	userArgs := strings.Join(args[0:], " ")
-
+func getApiResponse(args []string) (string, error) {
 	ctx := context.Background()
-	client, err := genai.NewClient(ctx, &genai.ClientConfig{
-		APIKey:  os.Getenv("GOOGLE_API_KEY"),
-		Backend: genai.BackendGeminiAPI,
-	})
-	CheckNilError(err)
+	provider, prompt, config, attached, err := newSearchRequest(ctx, args)
+	defer attached.cleanup(ctx, provider, deleteSearchFiles)
+	if err != nil {
+		return "", err
+	}
 
-	// Validate user input is a number
-	_, err = strconv.Atoi(numWords)
+	if searchFormat == "json" {
+		return generateJSON(ctx, provider, GetConfigFunc("genai_model"), prompt, config, searchSchema)
+	}
+	resp, err := generateContent(ctx, provider, GetConfigFunc("genai_model"), prompt, config)
 	if err != nil {
-		log.Fatal("Invalid number of words")
+		return "", err
 	}
 
-	currentGenaiModel := GetConfigFunc("genai_model")
-	config := &genai.GenerateContentConfig{Temperature: genai.Ptr(temperature)}
-	prompt := genai.Text(userArgs + " in " + numWords + " words" + " in " + outputLanguage + " language")
-	resp, err := client.Models.GenerateContent(ctx, currentGenaiModel, prompt, config)
-	CheckNilError(err)
+	if groundedSearch {
+		text, sources := citeSources(resp)
+		return text + sources, nil
+	}
+	return candidatesText(resp), nil
+}
+
+// This function is used to stream the response from the GenAI API, and was created to allow for testing.
+var streamApiResponseFunc = streamApiResponse
 
-	return formatAsPlainText(resp.Text())
+// streamApiResponse prints the response to w, rendered with --render, while it is being generated and returns the
+// complete Markdown.
+func streamApiResponse(args []string, w io.Writer) (string, error) {
+	ctx := context.Background()
+	provider, prompt, config, attached, err := newSearchRequest(ctx, args)
+	defer attached.cleanup(ctx, provider, deleteSearchFiles)
+	if err != nil {
+		return "", err
+	}
+
+	var full strings.Builder
+	stream := newMarkdownStream(w, renderMode)
+	for resp, err := range generateContentStream(ctx, provider, GetConfigFunc("genai_model"), prompt, config) {
+		if err != nil {
+			return "", err
+		}
+		full.WriteString(resp.Text())
+		if err := stream.Write(resp.Text()); err != nil {
+			return "", err
+		}
+	}
+	if err := stream.Flush(); err != nil {
+		return "", err
+	}
+
+	return full.String(), nil
 }
 
-func formatAsPlainText(input string) string {
-	// Remove Markdown headings
-	re := regexp.MustCompile(`^#{1,6}\s+(.*)`)
-	input = re.ReplaceAllString(input, "$1")
+func newSearchRequest(ctx context.Context, args []string) (Provider, []*genai.Content, *genai.GenerateContentConfig, *attachments, error) {
+	userArgs := strings.Join(args[0:], " ")
+	input := searchInput
+	if userArgs == "" {
+		userArgs, input = strings.TrimSpace(searchInput), ""
+	}
 
-	// Remove horizontal rules
-	re = regexp.MustCompile(`\n---\n`)
-	input = re.ReplaceAllString(input, "\n")
+	// Validate user input is a number
+	if _, err := strconv.Atoi(numWords); err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("%w: invalid number of words %q", ErrInvalidInput, numWords)
+	}
 
-	// Remove italic formatting
-	re = regexp.MustCompile(`_([^_]+)_`)
-	input = re.ReplaceAllString(input, "$1")
+	if groundedSearch {
+		if name := GetConfigFunc("provider"); name != "" && name != providerGemini {
+			return nil, nil, nil, nil, fmt.Errorf("%w: --grounded uses Google Search, which is only available with the gemini provider, not %s", ErrInvalidInput, name)
+		}
+	}
 
-	// Remove bold formatting
-	re = regexp.MustCompile(`\*\*([^*]+)\*\*`)
-	input = re.ReplaceAllString(input, "$1")
+	provider, err := newProviderFunc(ctx)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	attached, err := attachFiles(ctx, provider, attachPaths, imageStripMetadata)
+	if err != nil {
+		return provider, nil, nil, attached, err
+	}
 
-	// Remove bullet points
-	re = regexp.MustCompile(`\n\* (.*)`)
-	input = re.ReplaceAllString(input, "\n- $1")
+	config := generation.apply(&genai.GenerateContentConfig{Temperature: genai.Ptr(temperature)})
+	if candidateCount > 1 {
+		config.CandidateCount = int32(candidateCount)
+	}
+	if groundedSearch {
+		config.Tools = []*genai.Tool{googleSearchTool}
+	}
+	// Attachments and piped input come first, so that the question reads as being about them.
+	parts := slices.Clone(attached.parts)
+	if input != "" {
+		parts = append(parts, genai.NewPartFromText(input+"\n\n"))
+	}
+	if searchFormat == "json" {
+		// The length of a JSON response is set by its schema rather than a number of words.
+		parts = append(parts, genai.NewPartFromText(userArgs+" in "+outputLanguage+" language. Answer with JSON only."))
+	} else {
+		parts = append(parts, genai.NewPartFromText(userArgs+" in "+numWords+" words"+" in "+outputLanguage+" language"))
+	}
+	prompt := []*genai.Content{genai.NewContentFromParts(parts, genai.RoleUser)}
 
-	return input
+	return provider, prompt, config, attached, nil
 }
 
 func init() {
-	searchCmd.Flags().StringVarP(&numWords, "words", "w", "150", "Number of words in the response")
-	searchCmd.Flags().StringVarP(&outputLanguage, "language", "l", "english", "Output language")
-	searchCmd.Flags().Float32VarP(&temperature, "temperature", "t", 0.5, "Response creativity (0.0-1.0)")
+	searchCmd.Flags().StringVarP(&numWords, "words", "w", defaultWords, "Number of words in the response")
+	searchCmd.Flags().StringVarP(&outputLanguage, "language", "l", defaultLanguage, "Output language")
+	searchCmd.Flags().Float32VarP(&temperature, "temperature", "t", defaultTemperature, "Response creativity (0.0-1.0)")
 	searchCmd.Flags().BoolVarP(&saveOutput, "save", "s", false, "Save the output to a file")
-	searchCmd.Flags().StringVarP(&outputFile, "output", "o", "output.txt", "Output file name")
+	searchCmd.Flags().StringVarP(&outputFile, "output", "o", defaultOutputFile, "Output file name")
+	searchCmd.Flags().StringArrayVarP(&attachPaths, "attach", "a", nil, "Attach a file, such as source code, a PDF, an image, audio or a video (repeatable)")
+	searchCmd.Flags().BoolVar(&deleteSearchFiles, "delete-uploads", false, "Delete the attachments uploaded with the Gemini Files API once the response is received")
+	searchCmd.Flags().BoolVar(&imageStripMetadata, "strip-metadata", true, "Remove the metadata of the attached JPEG and PNG images, such as their GPS location, before sending them")
+	searchCmd.Flags().BoolVar(&groundedSearch, "grounded", false, "Search the web with Google Search and cite the sources of the response (gemini provider only, not streamed)")
+	searchCmd.Flags().StringVar(&searchFormat, "output-format", "text", "Output format: "+strings.Join(searchOutputFormats, ", "))
+	searchCmd.Flags().StringVar(&searchSchemaFile, "json-schema", "", "JSON Schema file the JSON response must match, implies --output-format json")
+	addGenerationFlags(searchCmd)
+	addCandidatesFlag(searchCmd)
+	addRenderFlags(searchCmd)
+	searchCmd.Flags().BoolVar(&streamOutput, "stream", isTerminal(os.Stdout), "Print the response while it is being generated, enabled by default on a terminal")
 }
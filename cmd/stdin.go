@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// noStdin is set with --no-stdin, for scripts whose stdin is a pipe that is never closed, such as some CI runners.
+var noStdin bool
+
+// stdinHintDelay is how long stdin is read from before a hint about --no-stdin is printed.
+var stdinHintDelay = 3 * time.Second
+
+// Limits of what can be piped to gencli. Larger inputs are rejected rather than silently truncated.
+var (
+	maxPipedTextSize  int64 = 4 << 20  // About a million tokens, the context size of the largest Gemini models.
+	maxPipedImageSize int64 = 20 << 20 // The largest request Gemini accepts with inline data.
+)
+
+// readPipedInput returns everything piped to in, or nil when in is a terminal or --no-stdin is given. what describes
+// the input in the error returned when it's larger than limit.
+func readPipedInput(in io.Reader, limit int64, what string) ([]byte, error) {
+	if noStdin {
+		return nil, nil
+	}
+	if f, ok := in.(*os.File); ok && !isPiped(f) {
+		return nil, nil
+	}
+
+	// A pipe left open by the caller would otherwise block without any explanation.
+	hint := time.AfterFunc(stdinHintDelay, func() {
+		fmt.Fprintln(os.Stderr, "Waiting for stdin to be closed, pass --no-stdin if nothing is piped to gencli")
+	})
+	defer hint.Stop()
+	data, err := io.ReadAll(io.LimitReader(hintStopper{in, hint}, limit+1))
+	if err != nil {
+		return nil, invalidInput(fmt.Errorf("reading stdin: %w", err))
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%w: the %s piped to stdin is larger than the %s limit", ErrInvalidInput, what, formatSize(limit))
+	}
+	return data, nil
+}
+
+// hintStopper cancels the hint about --no-stdin as soon as something is read, so that it isn't printed while a slow
+// command is still writing to the pipe.
+type hintStopper struct {
+	io.Reader
+	hint *time.Timer
+}
+
+func (r hintStopper) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 || err != nil {
+		r.hint.Stop()
+	}
+	return n, err
+}
+
+// isPiped reports whether f is a pipe or a redirected file. Terminals and devices are not read from, because reading
+// them would wait for input that the user never meant to give.
+func isPiped(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeNamedPipe != 0 || info.Mode().IsRegular()
+}
+
+// formatSize formats a number of bytes for humans, for example 4 MiB or 512 KiB.
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.3g MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.3g KiB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
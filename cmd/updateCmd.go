@@ -12,7 +12,7 @@ var (
 	// execCommand is a variable for creating commands. It can be overridden in tests.
 	execCommand = exec.Command
 
-	//nolint:unused // exitFunc wraps os.Exit so that it can be overridden in tests if needed.
+	// exitFunc wraps os.Exit so that it can be overridden in tests if needed.
 	exitFunc = func(code int) {
 		os.Exit(code)
 	}
@@ -34,7 +34,6 @@ func update() error {
 	cmd := execCommand("go", "install", "github.com/Pradumnasaraf/gencli@latest")
 	_, err := cmd.Output()
 	if err != nil {
-		// Instead of calling CheckNilError (which might exit), return the error.
 		return fmt.Errorf("failed to update CLI: %w", err)
 	}
 